@@ -0,0 +1,39 @@
+package dashboard_router
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"go_gateway/common"
+	"log"
+	"net/http"
+	"time"
+)
+
+var (
+	HttpSrvHandler *http.Server
+)
+
+func HttpServerRun() {
+	gin.SetMode(common.GetStringConf("base.base.debug_mode"))
+	r := InitRouter()
+	HttpSrvHandler = &http.Server{
+		Addr:           common.GetStringConf("base.http.addr"),
+		Handler:        r,
+		ReadTimeout:    time.Duration(common.GetIntConf("base.http.read_timeout")) * time.Second,
+		WriteTimeout:   time.Duration(common.GetIntConf("base.http.write_timeout")) * time.Second,
+		MaxHeaderBytes: 1 << uint(common.GetIntConf("base.http.max_header_bytes")),
+	}
+	log.Printf(" [INFO] dashboard_run %s\n", common.GetStringConf("base.http.addr"))
+	if err := HttpSrvHandler.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatalf(" [ERROR] dashboard_run %s err:%v\n", common.GetStringConf("base.http.addr"), err)
+	}
+}
+
+func HttpServerStop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := HttpSrvHandler.Shutdown(ctx); err != nil {
+		log.Printf(" [ERROR] dashboard_stop err:%v\n", err)
+	}
+	log.Printf(" [INFO] dashboard_stop %v stopped\n", common.GetStringConf("base.http.addr"))
+}
@@ -0,0 +1,100 @@
+package dashboard_router
+
+import (
+	"github.com/gin-gonic/contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"go_gateway/bussiness/mvc/controller"
+	"go_gateway/common"
+	"go_gateway/gateway/middleware"
+	"log"
+)
+
+func InitRouter(middlewares ...gin.HandlerFunc) *gin.Engine {
+	router := gin.Default()
+	router.Use(middlewares...)
+	router.GET("/ping", func(c *gin.Context) {
+		c.JSON(200, gin.H{
+			"message": "pong",
+		})
+	})
+
+	// 管理员session存放在redis中，cookie签名密钥未配置时拒绝启动
+	secret := common.GetSecretConf("base.session.secret", "GATEWAY_SESSION_SECRET")
+	if secret == "" {
+		log.Fatalf("session secret not configured, set base.session.secret or GATEWAY_SESSION_SECRET")
+	}
+	store, err := sessions.NewRedisStore(10, "tcp",
+		common.GetStringConf("base.session.redis_server"),
+		common.GetStringConf("base.session.redis_password"),
+		[]byte(secret))
+	if err != nil {
+		log.Fatalf("sessions.NewRedisStore err:%v", err)
+	}
+
+	adminLoginRouter := router.Group("/admin_login")
+	adminLoginRouter.Use(
+		sessions.Sessions("mysession", store),
+		middleware.RecoveryMiddleware(),
+		middleware.RequestLog(),
+		middleware.TranslationMiddleware())
+	{
+		controller.AdminLoginRegister(adminLoginRouter)
+	}
+
+	adminRouter := router.Group("/admin")
+	adminRouter.Use(
+		sessions.Sessions("mysession", store),
+		middleware.RecoveryMiddleware(),
+		middleware.RequestLog(),
+		middleware.SessionAuthMiddleware(),
+		middleware.TranslationMiddleware())
+	{
+		controller.AdminRegister(adminRouter)
+	}
+
+	serviceRouter := router.Group("/service")
+	serviceRouter.Use(
+		sessions.Sessions("mysession", store),
+		middleware.RecoveryMiddleware(),
+		middleware.RequestLog(),
+		middleware.SessionAuthMiddleware(),
+		middleware.TranslationMiddleware())
+	{
+		controller.ServiceRegister(serviceRouter)
+	}
+
+	appRouter := router.Group("/app")
+	appRouter.Use(
+		sessions.Sessions("mysession", store),
+		middleware.RecoveryMiddleware(),
+		middleware.RequestLog(),
+		middleware.SessionAuthMiddleware(),
+		middleware.TranslationMiddleware())
+	{
+		controller.APPRegister(appRouter)
+	}
+
+	dashRouter := router.Group("/dashboard")
+	dashRouter.Use(
+		sessions.Sessions("mysession", store),
+		middleware.RecoveryMiddleware(),
+		middleware.RequestLog(),
+		middleware.SessionAuthMiddleware(),
+		middleware.TranslationMiddleware())
+	{
+		controller.DashboardRegister(dashRouter)
+	}
+
+	configRouter := router.Group("/config")
+	configRouter.Use(
+		sessions.Sessions("mysession", store),
+		middleware.RecoveryMiddleware(),
+		middleware.RequestLog(),
+		middleware.SessionAuthMiddleware(),
+		middleware.TranslationMiddleware())
+	{
+		controller.ConfigRegister(configRouter)
+	}
+
+	return router
+}
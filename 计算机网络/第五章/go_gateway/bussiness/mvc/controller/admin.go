@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"go_gateway/bussiness/mvc/dao"
+	"go_gateway/bussiness/mvc/dto"
+	"go_gateway/common"
+	"go_gateway/gateway/middleware"
+)
+
+type AdminController struct{}
+
+func AdminRegister(group *gin.RouterGroup) {
+	admin := &AdminController{}
+	group.GET("/info", admin.AdminInfo)
+	group.POST("/change_password", admin.ChangePwd)
+}
+
+// AdminInfo godoc
+// @Summary 管理员信息
+// @Description 管理员信息
+// @Tags 管理员接口
+// @ID /admin/info
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} middleware.Response{data=dto.AdminInfoOutput} "success"
+// @Router /admin/info [get]
+func (adminlogin *AdminController) AdminInfo(c *gin.Context) {
+	adminSessionInfo, err := GetAdminSessionInfo(c)
+	if err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+
+	// 1. 读取sessionKey对应json 转换为结构体
+	// 2. 取出数据然后封装输出结构体
+	out := &dto.AdminInfoOutput{
+		ID:           adminSessionInfo.ID,
+		Name:         adminSessionInfo.UserName,
+		LoginTime:    adminSessionInfo.LoginTime,
+		Avatar:       "https://wpimg.wallstcn.com/f778738c-e4f8-4870-b634-56703b4acafe.gif",
+		Introduction: "I am a super administrator",
+		Roles:        []string{"admin"},
+	}
+	middleware.ResponseSuccess(c, out)
+}
+
+// ChangePwd godoc
+// @Summary 修改密码
+// @Description 修改密码
+// @Tags 管理员接口
+// @ID /admin/change_password
+// @Accept  json
+// @Produce  json
+// @Param body body dto.ChangePwdInput true "body"
+// @Success 200 {object} middleware.Response{data=string} "success"
+// @Router /admin/change_password [post]
+func (adminlogin *AdminController) ChangePwd(c *gin.Context) {
+	params := &dto.ChangePwdInput{}
+	if err := params.BindValidParam(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+
+	// 1. session读取用户信息到结构体 sessInfo
+	// 2. sessInfo.ID 读取数据库信息 adminInfo
+	// 3. params.password+adminInfo.salt sha256 saltPassword
+	// 4. saltPassword==> adminInfo.password 执行数据保存
+	adminSessionInfo, err := GetAdminSessionInfo(c)
+	if err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
+	}
+
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+	adminInfo := &dao.Admin{}
+	adminInfo, err = adminInfo.Find(c, tx, (&dao.Admin{UserName: adminSessionInfo.UserName}))
+	if err != nil {
+		middleware.ResponseError(c, 2003, err)
+		return
+	}
+
+	adminInfo.Password = common.GenSaltPassword(adminInfo.Salt, params.Password)
+	if err := adminInfo.Save(c, tx); err != nil {
+		middleware.ResponseError(c, 2004, err)
+		return
+	}
+	middleware.ResponseSuccess(c, "")
+}
+
+// GetAdminSessionInfo 从session中读取当前登录的管理员信息
+func GetAdminSessionInfo(c *gin.Context) (*dto.AdminSessionInfo, error) {
+	sess := sessions.Default(c)
+	sessInfo := sess.Get(common.AdminSessionInfoKey)
+	adminSessionInfo := &dto.AdminSessionInfo{}
+	if err := json.Unmarshal([]byte(fmt.Sprint(sessInfo)), adminSessionInfo); err != nil {
+		return nil, err
+	}
+	return adminSessionInfo, nil
+}
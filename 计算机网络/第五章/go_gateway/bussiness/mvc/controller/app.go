@@ -0,0 +1,473 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"go_gateway/bussiness/gorm"
+	"go_gateway/bussiness/mvc/dao"
+	"go_gateway/bussiness/mvc/dto"
+	"go_gateway/common"
+	"go_gateway/gateway/middleware"
+	"time"
+)
+
+type APPController struct{}
+
+func APPRegister(group *gin.RouterGroup) {
+	app := &APPController{}
+	group.GET("/app_list", app.APPList)
+	group.GET("/app_detail", app.APPDetail)
+	group.GET("/app_stat", app.AppStatistics)
+	group.GET("/app_delete", app.APPDelete)
+	group.POST("/app_add", app.AppAdd)
+	group.POST("/app_update", app.AppUpdate)
+	group.GET("/app_history", app.AppHistory)
+	group.POST("/app_rollback", app.AppRollback)
+}
+
+// APPList godoc
+// @Summary 租户列表
+// @Description 租户列表
+// @Tags 租户管理
+// @ID /app/app_list
+// @Accept  json
+// @Produce  json
+// @Param info query string false "关键词"
+// @Param page_size query string true "每页多少条"
+// @Param page_no query string true "页码"
+// @Success 200 {object} middleware.Response{data=dto.APPListOutput} "success"
+// @Router /app/app_list [get]
+func (admin *APPController) APPList(c *gin.Context) {
+	params := &dto.APPListInput{}
+	if err := params.GetValidParams(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
+	}
+	info := &dao.App{}
+	list, total, err := info.APPList(c, tx, params)
+	if err != nil {
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+
+	outputList := []dto.APPListItemOutput{}
+	for _, item := range list {
+		appCounter, err := middleware.FlowCounterHandler.GetCounter(common.FlowAppPrefix + item.AppID)
+		if err != nil {
+			middleware.ResponseError(c, 2003, err)
+			return
+		}
+		outputList = append(outputList, dto.APPListItemOutput{
+			ID:        item.ID,
+			AppID:     item.AppID,
+			Name:      item.Name,
+			Secret:    item.Secret,
+			WhiteIPS:  item.WhiteIPS,
+			Qpd:       item.Qpd,
+			Qps:       item.Qps,
+			RealQpd:   appCounter.TotalCount,
+			RealQps:   appCounter.QPS,
+			CreatedAt: item.CreatedAt,
+			UpdatedAt: item.UpdatedAt,
+			IsDelete:  item.IsDelete,
+		})
+	}
+	output := dto.APPListOutput{
+		List:  outputList,
+		Total: total,
+	}
+	middleware.ResponseSuccess(c, output)
+}
+
+// APPDetail godoc
+// @Summary 租户详情
+// @Description 租户详情
+// @Tags 租户管理
+// @ID /app/app_detail
+// @Accept  json
+// @Produce  json
+// @Param id query string true "租户ID"
+// @Success 200 {object} middleware.Response{data=dao.App} "success"
+// @Router /app/app_detail [get]
+func (admin *APPController) APPDetail(c *gin.Context) {
+	params := &dto.APPDetailInput{}
+	if err := params.GetValidParams(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
+	}
+	search := &dao.App{ID: params.ID}
+	detail, err := search.Find(c, tx, search)
+	if err != nil || detail.IsDelete == 1 {
+		middleware.ResponseError(c, 2002, errors.New("租户不存在"))
+		return
+	}
+	middleware.ResponseSuccess(c, detail)
+}
+
+// APPDelete godoc
+// @Summary 租户删除
+// @Description 租户删除
+// @Tags 租户管理
+// @ID /app/app_delete
+// @Accept  json
+// @Produce  json
+// @Param id query string true "租户ID"
+// @Success 200 {object} middleware.Response{data=string} "success"
+// @Router /app/app_delete [get]
+func (admin *APPController) APPDelete(c *gin.Context) {
+	params := &dto.APPDetailInput{}
+	if err := params.GetValidParams(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
+	}
+	tx = tx.Begin()
+	search := &dao.App{ID: params.ID}
+	info, err := search.Find(c, tx, search)
+	if err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+	before := common.Obj2Json(info)
+	info.IsDelete = 1
+	if err := info.Save(c, tx); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2003, err)
+		return
+	}
+	if err := saveConfAudit(c, tx, dao.AuditTargetApp, info.ID, info.AppID,
+		dao.AuditActionDelete, before, common.Obj2Json(info)); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2004, err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		middleware.ResponseError(c, 2005, err)
+		return
+	}
+	publishConfChange(c, dao.ConfChangeTypeApp, info.AppID)
+	middleware.ResponseSuccess(c, "")
+}
+
+// AppAdd godoc
+// @Summary 租户添加
+// @Description 租户添加
+// @Tags 租户管理
+// @ID /app/app_add
+// @Accept  json
+// @Produce  json
+// @Param body body dto.APPAddHttpInput true "body"
+// @Success 200 {object} middleware.Response{data=string} "success"
+// @Router /app/app_add [post]
+func (admin *APPController) AppAdd(c *gin.Context) {
+	params := &dto.APPAddHttpInput{}
+	if err := params.GetValidParams(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
+	}
+
+	// 验证app_id是否被占用
+	tx = tx.Begin()
+	if err := checkAppIDUsable(c, tx, params.AppID); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+	if params.Secret == "" {
+		params.Secret = common.MD5(params.AppID)
+	}
+	info := &dao.App{
+		AppID:    params.AppID,
+		Name:     params.Name,
+		Secret:   params.Secret,
+		WhiteIPS: params.WhiteIPS,
+		Qps:      params.Qps,
+		Qpd:      params.Qpd,
+	}
+	if err := info.Save(c, tx); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2003, err)
+		return
+	}
+	if err := saveConfAudit(c, tx, dao.AuditTargetApp, info.ID, info.AppID,
+		dao.AuditActionAdd, "", common.Obj2Json(info)); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2004, err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		middleware.ResponseError(c, 2005, err)
+		return
+	}
+	publishConfChange(c, dao.ConfChangeTypeApp, info.AppID)
+	middleware.ResponseSuccess(c, "")
+}
+
+// AppUpdate godoc
+// @Summary 租户更新
+// @Description 租户更新
+// @Tags 租户管理
+// @ID /app/app_update
+// @Accept  json
+// @Produce  json
+// @Param body body dto.APPUpdateHttpInput true "body"
+// @Success 200 {object} middleware.Response{data=string} "success"
+// @Router /app/app_update [post]
+func (admin *APPController) AppUpdate(c *gin.Context) {
+	params := &dto.APPUpdateHttpInput{}
+	if err := params.GetValidParams(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
+	}
+	tx = tx.Begin()
+	search := &dao.App{ID: params.ID}
+	info, err := search.Find(c, tx, search)
+	if err != nil || info.IsDelete == 1 {
+		tx.Rollback()
+		middleware.ResponseError(c, 2002, errors.New("租户不存在"))
+		return
+	}
+	// app_id作为鉴权与统计的key，不允许修改
+	if params.AppID != "" && params.AppID != info.AppID {
+		tx.Rollback()
+		middleware.ResponseError(c, 2003, errors.New("租户ID不可修改"))
+		return
+	}
+	before := common.Obj2Json(info)
+	if params.Secret == "" {
+		params.Secret = common.MD5(info.AppID)
+	}
+	info.Name = params.Name
+	info.Secret = params.Secret
+	info.WhiteIPS = params.WhiteIPS
+	info.Qps = params.Qps
+	info.Qpd = params.Qpd
+	if err := info.Save(c, tx); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2004, err)
+		return
+	}
+	if err := saveConfAudit(c, tx, dao.AuditTargetApp, info.ID, info.AppID,
+		dao.AuditActionUpdate, before, common.Obj2Json(info)); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2005, err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		middleware.ResponseError(c, 2006, err)
+		return
+	}
+	publishConfChange(c, dao.ConfChangeTypeApp, info.AppID)
+	middleware.ResponseSuccess(c, "")
+}
+
+// AppStatistics godoc
+// @Summary 租户统计
+// @Description 租户统计
+// @Tags 租户管理
+// @ID /app/app_stat
+// @Accept  json
+// @Produce  json
+// @Param id query string true "租户ID"
+// @Success 200 {object} middleware.Response{data=dto.StatisticsOutput} "success"
+// @Router /app/app_stat [get]
+func (admin *APPController) AppStatistics(c *gin.Context) {
+	params := &dto.APPDetailInput{}
+	if err := params.GetValidParams(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
+	}
+	search := &dao.App{ID: params.ID}
+	detail, err := search.Find(c, tx, search)
+	if err != nil || detail.IsDelete == 1 {
+		middleware.ResponseError(c, 2002, errors.New("租户不存在"))
+		return
+	}
+
+	counter, err := middleware.FlowCounterHandler.GetCounter(common.FlowAppPrefix + detail.AppID)
+	if err != nil {
+		middleware.ResponseError(c, 2003, err)
+		return
+	}
+	todayStat, yesterdayStat := hourStatistics(counter)
+	middleware.ResponseSuccess(c, &dto.StatisticsOutput{
+		Today:     todayStat,
+		Yesterday: yesterdayStat,
+	})
+}
+
+// AppHistory godoc
+// @Summary 租户变更记录
+// @Description 租户变更记录
+// @Tags 租户管理
+// @ID /app/app_history
+// @Accept  json
+// @Produce  json
+// @Param id query string true "租户ID"
+// @Param page_size query int true "每页个数"
+// @Param page_no query int true "当前页数"
+// @Success 200 {object} middleware.Response{data=dto.ConfHistoryOutput} "success"
+// @Router /app/app_history [get]
+func (admin *APPController) AppHistory(c *gin.Context) {
+	params := &dto.ConfHistoryInput{}
+	if err := params.BindValidParam(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
+	}
+	out, err := confHistory(c, tx, dao.AuditTargetApp, params)
+	if err != nil {
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+	middleware.ResponseSuccess(c, out)
+}
+
+// AppRollback godoc
+// @Summary 租户回滚
+// @Description 将租户恢复为指定版本变更后的配置
+// @Tags 租户管理
+// @ID /app/app_rollback
+// @Accept  json
+// @Produce  json
+// @Param body body dto.ConfRollbackInput true "body"
+// @Success 200 {object} middleware.Response{data=string} "success"
+// @Router /app/app_rollback [post]
+func (admin *APPController) AppRollback(c *gin.Context) {
+	params := &dto.ConfRollbackInput{}
+	if err := params.BindValidParam(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
+	}
+	tx = tx.Begin()
+	search := &dao.App{ID: params.ID}
+	current, err := search.Find(c, tx, search)
+	if err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2002, errors.New("租户不存在"))
+		return
+	}
+	before := common.Obj2Json(current)
+
+	// 取目标版本变更后的快照
+	auditSearch := &dao.ConfAudit{TargetType: dao.AuditTargetApp, TargetID: params.ID, Version: params.Version}
+	audit, err := auditSearch.Find(c, tx, auditSearch)
+	if err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2003, errors.New("版本不存在"))
+		return
+	}
+	info := &dao.App{}
+	if err := json.Unmarshal([]byte(audit.AfterData), info); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2004, err)
+		return
+	}
+	if info.ID != current.ID || info.AppID != current.AppID {
+		tx.Rollback()
+		middleware.ResponseError(c, 2005, errors.New("版本快照与租户不匹配"))
+		return
+	}
+	// 恢复已删除的租户时，app_id需未被其他租户占用
+	if info.IsDelete == 0 && current.IsDelete == 1 {
+		if err := checkAppIDUsable(c, tx, info.AppID); err != nil {
+			tx.Rollback()
+			middleware.ResponseError(c, 2006, err)
+			return
+		}
+	}
+	if err := info.Save(c, tx); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2007, err)
+		return
+	}
+	if err := saveConfAudit(c, tx, dao.AuditTargetApp, info.ID, info.AppID,
+		dao.AuditActionRollback, before, common.Obj2Json(info)); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2008, err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		middleware.ResponseError(c, 2009, err)
+		return
+	}
+	publishConfChange(c, dao.ConfChangeTypeApp, info.AppID)
+	middleware.ResponseSuccess(c, "")
+}
+
+// checkAppIDUsable app_id不能与未删除的租户重复
+func checkAppIDUsable(c *gin.Context, tx *gorm.DB, appID string) error {
+	search := &dao.App{AppID: appID}
+	if _, err := search.Find(c, tx.Where("is_delete=0"), search); err == nil {
+		return errors.New("租户ID被占用，请重新输入")
+	}
+	return nil
+}
+
+// hourStatistics 读取今日截至当前小时、昨日全天的每小时请求量，小时key不存在时计为0
+func hourStatistics(counter *middleware.RedisFlowCountService) ([]int64, []int64) {
+	todayStat := []int64{}
+	currentTime := time.Now().In(common.TimeLocation)
+	for i := 0; i <= currentTime.Hour(); i++ {
+		dateTime := time.Date(currentTime.Year(), currentTime.Month(), currentTime.Day(), i, 0, 0, 0, common.TimeLocation)
+		hourData, _ := counter.GetHourData(dateTime)
+		todayStat = append(todayStat, hourData)
+	}
+
+	yesterdayStat := []int64{}
+	yesterdayTime := currentTime.Add(-1 * 24 * time.Hour)
+	for i := 0; i <= 23; i++ {
+		dateTime := time.Date(yesterdayTime.Year(), yesterdayTime.Month(), yesterdayTime.Day(), i, 0, 0, 0, common.TimeLocation)
+		hourData, _ := counter.GetHourData(dateTime)
+		yesterdayStat = append(yesterdayStat, hourData)
+	}
+	return todayStat, yesterdayStat
+}
@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"go_gateway/bussiness/gorm"
+	"go_gateway/bussiness/mvc/dao"
+	"go_gateway/bussiness/mvc/dto"
+)
+
+// saveConfAudit 记录配置变更，需与配置写入在同一事务中执行，审计失败则整体回滚
+func saveConfAudit(c *gin.Context, tx *gorm.DB, targetType string, targetID int64, targetName string,
+	action string, before string, after string) error {
+	adminUser := ""
+	if adminSessionInfo, err := GetAdminSessionInfo(c); err == nil {
+		adminUser = adminSessionInfo.UserName
+	}
+	audit := &dao.ConfAudit{
+		TargetType: targetType,
+		TargetID:   targetID,
+		TargetName: targetName,
+		Action:     action,
+		BeforeData: before,
+		AfterData:  after,
+		AdminUser:  adminUser,
+	}
+	return audit.Save(c, tx)
+}
+
+// confHistory 分页读取服务或租户的变更记录，按版本倒序
+func confHistory(c *gin.Context, tx *gorm.DB, targetType string, params *dto.ConfHistoryInput) (*dto.ConfHistoryOutput, error) {
+	list, total, err := (&dao.ConfAudit{}).PageList(c, tx, targetType, params)
+	if err != nil {
+		return nil, err
+	}
+	outList := []dto.ConfHistoryItemOutput{}
+	for _, item := range list {
+		outList = append(outList, dto.ConfHistoryItemOutput{
+			ID:         item.ID,
+			TargetType: item.TargetType,
+			TargetID:   item.TargetID,
+			TargetName: item.TargetName,
+			Version:    item.Version,
+			Action:     item.Action,
+			BeforeData: item.BeforeData,
+			AfterData:  item.AfterData,
+			AdminUser:  item.AdminUser,
+			CreatedAt:  item.CreatedAt,
+		})
+	}
+	return &dto.ConfHistoryOutput{
+		Total: total,
+		List:  outList,
+	}, nil
+}
@@ -0,0 +1,254 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"go_gateway/bussiness/mvc/dao"
+	"go_gateway/bussiness/mvc/dto"
+	"go_gateway/bussiness/util"
+	"go_gateway/common"
+	"go_gateway/gateway/middleware"
+	"strings"
+)
+
+type ConfigController struct{}
+
+func ConfigRegister(group *gin.RouterGroup) {
+	config := &ConfigController{}
+	group.GET("/export", config.ConfExport)
+	group.POST("/import", config.ConfImport)
+}
+
+// ConfExport godoc
+// @Summary 导出配置
+// @Description 以yaml、toml或json文档导出服务与租户配置
+// @Tags 配置管理
+// @ID /config/export
+// @Accept  json
+// @Produce  json
+// @Param format query string true "文档格式 yaml/toml/json"
+// @Param scope query string false "导出范围 all/service/app"
+// @Param services query string false "服务名，逗号间隔"
+// @Param apps query string false "租户id，逗号间隔"
+// @Success 200 {object} middleware.Response{data=dto.ConfExportOutput} "success"
+// @Router /config/export [get]
+func (config *ConfigController) ConfExport(c *gin.Context) {
+	params := &dto.ConfExportInput{}
+	if err := params.BindValidParam(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
+	}
+	withServices := params.Scope != "app"
+	withApps := params.Scope != "service"
+	doc, err := dao.ExportConfDoc(c, tx, withServices, splitNames(params.Services), withApps, splitNames(params.Apps))
+	if err != nil {
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+	content, err := common.EncodeConfDoc(doc, params.Format, dao.ConfDocOmitKeys...)
+	if err != nil {
+		middleware.ResponseError(c, 2003, err)
+		return
+	}
+	middleware.ResponseSuccess(c, &dto.ConfExportOutput{
+		Format:  params.Format,
+		Content: string(content),
+	})
+}
+
+// ConfImport godoc
+// @Summary 导入配置
+// @Description 导入yaml、toml或json文档，dry_run=1时仅返回执行计划，prune=1时删除文档中没有的服务、租户
+// @Tags 配置管理
+// @ID /config/import
+// @Accept  json
+// @Produce  json
+// @Param body body dto.ConfImportInput true "body"
+// @Success 200 {object} middleware.Response{data=dto.ConfImportOutput} "success"
+// @Router /config/import [post]
+func (config *ConfigController) ConfImport(c *gin.Context) {
+	params := &dto.ConfImportInput{}
+	if err := params.BindValidParam(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+	doc := &dao.ConfDocument{}
+	if err := common.DecodeConfDoc([]byte(params.Content), params.Format, doc); err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
+	}
+	if err := validConfDoc(c, doc); err != nil {
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2003, err)
+		return
+	}
+	tx = tx.Begin()
+	plan, err := dao.PlanConfImport(c, tx, doc, params.Prune == 1)
+	if err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2004, err)
+		return
+	}
+	out := &dto.ConfImportOutput{
+		DryRun: params.DryRun,
+		Plan:   plan.Describe(),
+	}
+	if params.DryRun == 1 {
+		tx.Rollback()
+		middleware.ResponseSuccess(c, out)
+		return
+	}
+
+	adminUser := ""
+	if adminSessionInfo, err := GetAdminSessionInfo(c); err == nil {
+		adminUser = adminSessionInfo.UserName
+	}
+	if err := dao.ApplyConfImport(c, tx, plan, adminUser); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2005, err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		middleware.ResponseError(c, 2006, err)
+		return
+	}
+
+	for _, list := range [][]*dao.ServiceDetail{plan.CreateServices, plan.UpdateServices, plan.DeleteServices} {
+		for _, item := range list {
+			publishConfChange(c, dao.ConfChangeTypeService, item.Info.ServiceName)
+		}
+	}
+	for _, list := range [][]*dao.App{plan.CreateApps, plan.UpdateApps, plan.DeleteApps} {
+		for _, item := range list {
+			publishConfChange(c, dao.ConfChangeTypeApp, item.AppID)
+		}
+	}
+	middleware.ResponseSuccess(c, out)
+}
+
+// validConfDoc 将文档中的服务、租户转换为新增接口的参数，复用dto的校验规则
+func validConfDoc(c *gin.Context, doc *dao.ConfDocument) error {
+	if doc.Services == nil && doc.Apps == nil {
+		return errors.New("文档中没有services或apps")
+	}
+	for i, item := range doc.Services {
+		if item.Info == nil || item.AccessControl == nil || item.LoadBalance == nil {
+			return fmt.Errorf("第%d个服务缺少info、access_control或loadbalance", i+1)
+		}
+		var params interface{}
+		switch item.Info.LoadType {
+		case common.LoadTypeHTTP:
+			if item.HTTPRule == nil {
+				return fmt.Errorf("服务%s缺少http_rule", item.Info.ServiceName)
+			}
+			params = &dto.ServiceAddHTTPInput{
+				ServiceName:             item.Info.ServiceName,
+				ServiceDesc:             item.Info.ServiceDesc,
+				RuleType:                item.HTTPRule.RuleType,
+				Rule:                    item.HTTPRule.Rule,
+				NeedHttps:               item.HTTPRule.NeedHttps,
+				NeedStripUri:            item.HTTPRule.NeedStripUri,
+				NeedWebsocket:           item.HTTPRule.NeedWebsocket,
+				UrlRewrite:              item.HTTPRule.UrlRewrite,
+				HeaderTransfor:          item.HTTPRule.HeaderTransfor,
+				WebsocketIdleTimeout:    item.HTTPRule.WebsocketIdleTimeout,
+				OpenAuth:                item.AccessControl.OpenAuth,
+				BlackList:               item.AccessControl.BlackList,
+				WhiteList:               item.AccessControl.WhiteList,
+				ClientipFlowLimit:       item.AccessControl.ClientIPFlowLimit,
+				ServiceFlowLimit:        item.AccessControl.ServiceFlowLimit,
+				RoundType:               item.LoadBalance.RoundType,
+				IpList:                  item.LoadBalance.IpList,
+				WeightList:              item.LoadBalance.WeightList,
+				UpstreamConnectTimeout:  item.LoadBalance.UpstreamConnectTimeout,
+				UpstreamHeaderTimeout:   item.LoadBalance.UpstreamHeaderTimeout,
+				UpstreamIdleTimeout:     item.LoadBalance.UpstreamIdleTimeout,
+				UpstreamMaxIdle:         item.LoadBalance.UpstreamMaxIdle,
+				LoadBalanceSettingInput: item.LoadBalance.SettingInput(),
+			}
+		case common.LoadTypeTCP:
+			if item.TCPRule == nil {
+				return fmt.Errorf("服务%s缺少tcp_rule", item.Info.ServiceName)
+			}
+			params = &dto.ServiceAddTcpInput{
+				ServiceName:             item.Info.ServiceName,
+				ServiceDesc:             item.Info.ServiceDesc,
+				Port:                    item.TCPRule.Port,
+				OpenAuth:                item.AccessControl.OpenAuth,
+				BlackList:               item.AccessControl.BlackList,
+				WhiteList:               item.AccessControl.WhiteList,
+				WhiteHostName:           item.AccessControl.WhiteHostName,
+				ClientIPFlowLimit:       item.AccessControl.ClientIPFlowLimit,
+				ServiceFlowLimit:        item.AccessControl.ServiceFlowLimit,
+				RoundType:               item.LoadBalance.RoundType,
+				IpList:                  item.LoadBalance.IpList,
+				WeightList:              item.LoadBalance.WeightList,
+				LoadBalanceSettingInput: item.LoadBalance.SettingInput(),
+			}
+		case common.LoadTypeGRPC:
+			if item.GRPCRule == nil {
+				return fmt.Errorf("服务%s缺少grpc_rule", item.Info.ServiceName)
+			}
+			params = &dto.ServiceAddGrpcInput{
+				ServiceName:             item.Info.ServiceName,
+				ServiceDesc:             item.Info.ServiceDesc,
+				Port:                    item.GRPCRule.Port,
+				HeaderTransfor:          item.GRPCRule.HeaderTransfor,
+				OpenAuth:                item.AccessControl.OpenAuth,
+				BlackList:               item.AccessControl.BlackList,
+				WhiteList:               item.AccessControl.WhiteList,
+				WhiteHostName:           item.AccessControl.WhiteHostName,
+				ClientIPFlowLimit:       item.AccessControl.ClientIPFlowLimit,
+				ServiceFlowLimit:        item.AccessControl.ServiceFlowLimit,
+				RoundType:               item.LoadBalance.RoundType,
+				IpList:                  item.LoadBalance.IpList,
+				WeightList:              item.LoadBalance.WeightList,
+				LoadBalanceSettingInput: item.LoadBalance.SettingInput(),
+			}
+		default:
+			return fmt.Errorf("服务%s类型%d不支持", item.Info.ServiceName, item.Info.LoadType)
+		}
+		if err := util.ValidParams(c, params); err != nil {
+			return fmt.Errorf("服务%s: %v", item.Info.ServiceName, err)
+		}
+		if len(strings.Split(item.LoadBalance.IpList, ",")) != len(strings.Split(item.LoadBalance.WeightList, ",")) {
+			return fmt.Errorf("服务%s: IP列表与权重列表数量不一致", item.Info.ServiceName)
+		}
+	}
+	for _, item := range doc.Apps {
+		params := &dto.APPAddHttpInput{
+			AppID:    item.AppID,
+			Name:     item.Name,
+			Secret:   item.Secret,
+			WhiteIPS: item.WhiteIPS,
+			Qpd:      item.Qpd,
+			Qps:      item.Qps,
+		}
+		if err := util.ValidParams(c, params); err != nil {
+			return fmt.Errorf("租户%s: %v", item.AppID, err)
+		}
+	}
+	return nil
+}
+
+func splitNames(names string) []string {
+	out := []string{}
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			out = append(out, name)
+		}
+	}
+	return out
+}
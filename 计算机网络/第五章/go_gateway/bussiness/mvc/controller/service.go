@@ -0,0 +1,1038 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"go_gateway/bussiness/gorm"
+	"go_gateway/bussiness/mvc/dao"
+	"go_gateway/bussiness/mvc/dto"
+	"go_gateway/bussiness/util"
+	"go_gateway/common"
+	"go_gateway/gateway/loadbalance"
+	"go_gateway/gateway/middleware"
+	"strings"
+)
+
+type ServiceController struct{}
+
+func ServiceRegister(group *gin.RouterGroup) {
+	service := &ServiceController{}
+	group.GET("/service_list", service.ServiceList)
+	group.GET("/service_detail", service.ServiceDetail)
+	group.GET("/service_nodes", service.ServiceNodes)
+	group.GET("/service_delete", service.ServiceDelete)
+	group.POST("/service_add_http", service.ServiceAddHTTP)
+	group.POST("/service_update_http", service.ServiceUpdateHTTP)
+	group.POST("/service_add_tcp", service.ServiceAddTcp)
+	group.POST("/service_update_tcp", service.ServiceUpdateTcp)
+	group.POST("/service_add_grpc", service.ServiceAddGrpc)
+	group.POST("/service_update_grpc", service.ServiceUpdateGrpc)
+	group.GET("/service_history", service.ServiceHistory)
+	group.POST("/service_rollback", service.ServiceRollback)
+}
+
+// ServiceList godoc
+// @Summary 服务列表
+// @Description 服务列表
+// @Tags 服务管理
+// @ID /service/service_list
+// @Accept  json
+// @Produce  json
+// @Param info query string false "关键词"
+// @Param page_size query int true "每页个数"
+// @Param page_no query int true "当前页数"
+// @Success 200 {object} middleware.Response{data=dto.ServiceListOutput} "success"
+// @Router /service/service_list [get]
+func (service *ServiceController) ServiceList(c *gin.Context) {
+	params := &dto.ServiceListInput{}
+	if err := params.BindValidParam(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
+	}
+
+	// 从db中分页读取基本信息
+	serviceInfo := &dao.ServiceInfo{}
+	list, total, err := serviceInfo.PageList(c, tx, params)
+	if err != nil {
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+
+	// 格式化输出信息
+	outList := []dto.ServiceListItemOutput{}
+	for _, listItem := range list {
+		tmpItem := listItem
+		serviceDetail, err := tmpItem.ServiceDetail(c, tx, &tmpItem)
+		if err != nil {
+			middleware.ResponseError(c, 2003, err)
+			return
+		}
+		counter, err := middleware.FlowCounterHandler.GetCounter(common.FlowServicePrefix + tmpItem.ServiceName)
+		if err != nil {
+			middleware.ResponseError(c, 2004, err)
+			return
+		}
+		outItem := dto.ServiceListItemOutput{
+			ID:          tmpItem.ID,
+			LoadType:    tmpItem.LoadType,
+			ServiceName: tmpItem.ServiceName,
+			ServiceDesc: tmpItem.ServiceDesc,
+			ServiceAddr: serviceAddr(serviceDetail),
+			Qps:         counter.QPS,
+			Qpd:         counter.TotalCount,
+			TotalNode:   len(serviceDetail.LoadBalance.GetIPListByModel()),
+		}
+		outList = append(outList, outItem)
+	}
+	out := &dto.ServiceListOutput{
+		Total: total,
+		List:  outList,
+	}
+	middleware.ResponseSuccess(c, out)
+}
+
+// ServiceDetail godoc
+// @Summary 服务详情
+// @Description 服务详情
+// @Tags 服务管理
+// @ID /service/service_detail
+// @Accept  json
+// @Produce  json
+// @Param id query string true "服务ID"
+// @Success 200 {object} middleware.Response{data=dao.ServiceDetail} "success"
+// @Router /service/service_detail [get]
+func (service *ServiceController) ServiceDetail(c *gin.Context) {
+	params := &dto.ServiceDetailInput{}
+	if err := params.BindValidParam(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
+	}
+
+	serviceInfo := &dao.ServiceInfo{ID: params.ID}
+	serviceInfo, err = serviceInfo.Find(c, tx, serviceInfo)
+	if err != nil || serviceInfo.IsDelete == 1 {
+		middleware.ResponseError(c, 2002, errors.New("服务不存在"))
+		return
+	}
+	serviceDetail, err := serviceInfo.ServiceDetail(c, tx, serviceInfo)
+	if err != nil {
+		middleware.ResponseError(c, 2003, err)
+		return
+	}
+	middleware.ResponseSuccess(c, serviceDetail)
+}
+
+// ServiceNodes godoc
+// @Summary 服务节点状态
+// @Description 节点的禁用、排空状态及各代理上报的探活状态、进行中的连接数
+// @Tags 服务管理
+// @ID /service/service_nodes
+// @Accept  json
+// @Produce  json
+// @Param id query string true "服务ID"
+// @Success 200 {object} middleware.Response{data=dto.ServiceNodesOutput} "success"
+// @Router /service/service_nodes [get]
+func (service *ServiceController) ServiceNodes(c *gin.Context) {
+	params := &dto.ServiceDetailInput{}
+	if err := params.BindValidParam(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
+	}
+
+	serviceInfo := &dao.ServiceInfo{ID: params.ID}
+	serviceInfo, err = serviceInfo.Find(c, tx, serviceInfo)
+	if err != nil || serviceInfo.IsDelete == 1 {
+		middleware.ResponseError(c, 2002, errors.New("服务不存在"))
+		return
+	}
+	serviceDetail, err := serviceInfo.ServiceDetail(c, tx, serviceInfo)
+	if err != nil {
+		middleware.ResponseError(c, 2003, err)
+		return
+	}
+	reports, err := dao.GetNodeStateReports(serviceInfo.ServiceName, dao.NodeStateReportInterval())
+	if err != nil {
+		middleware.ResponseError(c, 2004, err)
+		return
+	}
+
+	// 汇总各代理上报的连接数，任一代理探活失败或摘除即展示该状态
+	conns := map[string]int64{}
+	health := map[string]string{}
+	for _, report := range reports {
+		for _, node := range report.Nodes {
+			conns[node.Addr] += node.Conns
+			switch node.State {
+			case loadbalance.NodeStateUnhealthy:
+				health[node.Addr] = node.State
+			case loadbalance.NodeStateEjected:
+				if health[node.Addr] != loadbalance.NodeStateUnhealthy {
+					health[node.Addr] = node.State
+				}
+			}
+		}
+	}
+	forbidList := serviceDetail.LoadBalance.GetForbidListByModel()
+	drainList := serviceDetail.LoadBalance.GetDrainListByModel()
+	weightList := serviceDetail.LoadBalance.GetWeightListByModel()
+	out := &dto.ServiceNodesOutput{Instances: len(reports), List: []dto.ServiceNodeItemOutput{}}
+	for index, ip := range serviceDetail.LoadBalance.GetIPListByModel() {
+		item := dto.ServiceNodeItemOutput{
+			Addr:        ip,
+			State:       loadbalance.NodeStateActive,
+			Health:      loadbalance.NodeStateActive,
+			ActiveConns: conns[ip],
+		}
+		if index < len(weightList) {
+			item.Weight = weightList[index]
+		}
+		if common.InArrayString(ip, drainList) {
+			item.State = loadbalance.NodeStateDraining
+			item.Drained = item.ActiveConns == 0
+		}
+		if common.InArrayString(ip, forbidList) {
+			item.State = loadbalance.NodeStateForbidden
+		}
+		if state, ok := health[ip]; ok {
+			item.Health = state
+		}
+		out.List = append(out.List, item)
+	}
+	middleware.ResponseSuccess(c, out)
+}
+
+// ServiceDelete godoc
+// @Summary 服务删除
+// @Description 服务删除
+// @Tags 服务管理
+// @ID /service/service_delete
+// @Accept  json
+// @Produce  json
+// @Param id query string true "服务ID"
+// @Success 200 {object} middleware.Response{data=string} "success"
+// @Router /service/service_delete [get]
+func (service *ServiceController) ServiceDelete(c *gin.Context) {
+	params := &dto.ServiceDeleteInput{}
+	if err := params.BindValidParam(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
+	}
+
+	// 读取服务详情，软删除
+	tx = tx.Begin()
+	serviceInfo := &dao.ServiceInfo{ID: params.ID}
+	serviceInfo, err = serviceInfo.Find(c, tx, serviceInfo)
+	if err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+	serviceDetail, err := serviceInfo.ServiceDetail(c, tx, serviceInfo)
+	if err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2003, err)
+		return
+	}
+	before := common.Obj2Json(serviceDetail)
+	serviceInfo.IsDelete = 1
+	if err := serviceInfo.Save(c, tx); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2004, err)
+		return
+	}
+	if err := saveConfAudit(c, tx, dao.AuditTargetService, serviceInfo.ID, serviceInfo.ServiceName,
+		dao.AuditActionDelete, before, common.Obj2Json(serviceDetail)); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2005, err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		middleware.ResponseError(c, 2006, err)
+		return
+	}
+	publishConfChange(c, dao.ConfChangeTypeService, serviceInfo.ServiceName)
+	middleware.ResponseSuccess(c, "")
+}
+
+// ServiceAddHTTP godoc
+// @Summary 添加HTTP服务
+// @Description 添加HTTP服务
+// @Tags 服务管理
+// @ID /service/service_add_http
+// @Accept  json
+// @Produce  json
+// @Param body body dto.ServiceAddHTTPInput true "body"
+// @Success 200 {object} middleware.Response{data=string} "success"
+// @Router /service/service_add_http [post]
+func (service *ServiceController) ServiceAddHTTP(c *gin.Context) {
+	params := &dto.ServiceAddHTTPInput{}
+	if err := params.BindValidParam(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+	if len(strings.Split(params.IpList, ",")) != len(strings.Split(params.WeightList, ",")) {
+		middleware.ResponseError(c, 2001, errors.New("IP列表与权重列表数量不一致"))
+		return
+	}
+	if params.DiscoveryType != loadbalance.DiscoveryStatic && params.DiscoveryTarget == "" {
+		middleware.ResponseError(c, 2001, errors.New("服务发现地址不能为空"))
+		return
+	}
+
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+	tx = tx.Begin()
+	if err := checkServiceNameUsable(c, tx, params.ServiceName); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2003, err)
+		return
+	}
+	if err := checkHTTPRuleUsable(c, tx, params.RuleType, params.Rule, 0); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2004, err)
+		return
+	}
+
+	serviceDetail := &dao.ServiceDetail{
+		Info: &dao.ServiceInfo{
+			LoadType:    common.LoadTypeHTTP,
+			ServiceName: params.ServiceName,
+			ServiceDesc: params.ServiceDesc,
+		},
+		HTTPRule: &dao.HttpRule{
+			RuleType:       params.RuleType,
+			Rule:           params.Rule,
+			NeedHttps:      params.NeedHttps,
+			NeedStripUri:   params.NeedStripUri,
+			NeedWebsocket:  params.NeedWebsocket,
+			UrlRewrite:     params.UrlRewrite,
+			HeaderTransfor: params.HeaderTransfor,
+
+			WebsocketIdleTimeout: params.WebsocketIdleTimeout,
+		},
+		AccessControl: &dao.AccessControl{
+			OpenAuth:          params.OpenAuth,
+			BlackList:         params.BlackList,
+			WhiteList:         params.WhiteList,
+			ClientIPFlowLimit: params.ClientipFlowLimit,
+			ServiceFlowLimit:  params.ServiceFlowLimit,
+		},
+		LoadBalance: &dao.LoadBalance{
+			RoundType:              params.RoundType,
+			IpList:                 params.IpList,
+			WeightList:             params.WeightList,
+			UpstreamConnectTimeout: params.UpstreamConnectTimeout,
+			UpstreamHeaderTimeout:  params.UpstreamHeaderTimeout,
+			UpstreamIdleTimeout:    params.UpstreamIdleTimeout,
+			UpstreamMaxIdle:        params.UpstreamMaxIdle,
+		},
+	}
+	serviceDetail.LoadBalance.ApplySetting(params.LoadBalanceSettingInput)
+	if err := serviceDetail.Save(c, tx); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2005, err)
+		return
+	}
+	if err := saveConfAudit(c, tx, dao.AuditTargetService, serviceDetail.Info.ID, serviceDetail.Info.ServiceName,
+		dao.AuditActionAdd, "", common.Obj2Json(serviceDetail)); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2006, err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		middleware.ResponseError(c, 2007, err)
+		return
+	}
+	publishConfChange(c, dao.ConfChangeTypeService, serviceDetail.Info.ServiceName)
+	middleware.ResponseSuccess(c, "")
+}
+
+// ServiceUpdateHTTP godoc
+// @Summary 修改HTTP服务
+// @Description 修改HTTP服务
+// @Tags 服务管理
+// @ID /service/service_update_http
+// @Accept  json
+// @Produce  json
+// @Param body body dto.ServiceUpdateHTTPInput true "body"
+// @Success 200 {object} middleware.Response{data=string} "success"
+// @Router /service/service_update_http [post]
+func (service *ServiceController) ServiceUpdateHTTP(c *gin.Context) {
+	params := &dto.ServiceUpdateHTTPInput{}
+	if err := params.BindValidParam(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+	if len(strings.Split(params.IpList, ",")) != len(strings.Split(params.WeightList, ",")) {
+		middleware.ResponseError(c, 2001, errors.New("IP列表与权重列表数量不一致"))
+		return
+	}
+	if params.DiscoveryType != loadbalance.DiscoveryStatic && params.DiscoveryTarget == "" {
+		middleware.ResponseError(c, 2001, errors.New("服务发现地址不能为空"))
+		return
+	}
+
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+	tx = tx.Begin()
+	serviceDetail, err := findServiceDetail(c, tx, params.ID, params.ServiceName, common.LoadTypeHTTP)
+	if err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2003, err)
+		return
+	}
+	// 记录修改前快照
+	before := common.Obj2Json(serviceDetail)
+	if err := checkHTTPRuleUsable(c, tx, params.RuleType, params.Rule, params.ID); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2004, err)
+		return
+	}
+
+	serviceDetail.Info.ServiceDesc = params.ServiceDesc
+
+	httpRule := serviceDetail.HTTPRule
+	httpRule.RuleType = params.RuleType
+	httpRule.Rule = params.Rule
+	httpRule.NeedHttps = params.NeedHttps
+	httpRule.NeedStripUri = params.NeedStripUri
+	httpRule.NeedWebsocket = params.NeedWebsocket
+	httpRule.UrlRewrite = params.UrlRewrite
+	httpRule.HeaderTransfor = params.HeaderTransfor
+	httpRule.WebsocketIdleTimeout = params.WebsocketIdleTimeout
+
+	accessControl := serviceDetail.AccessControl
+	accessControl.OpenAuth = params.OpenAuth
+	accessControl.BlackList = params.BlackList
+	accessControl.WhiteList = params.WhiteList
+	accessControl.ClientIPFlowLimit = params.ClientipFlowLimit
+	accessControl.ServiceFlowLimit = params.ServiceFlowLimit
+
+	loadBalance := serviceDetail.LoadBalance
+	loadBalance.RoundType = params.RoundType
+	loadBalance.IpList = params.IpList
+	loadBalance.WeightList = params.WeightList
+	loadBalance.UpstreamConnectTimeout = params.UpstreamConnectTimeout
+	loadBalance.UpstreamHeaderTimeout = params.UpstreamHeaderTimeout
+	loadBalance.UpstreamIdleTimeout = params.UpstreamIdleTimeout
+	loadBalance.UpstreamMaxIdle = params.UpstreamMaxIdle
+	loadBalance.ApplySetting(params.LoadBalanceSettingInput)
+
+	if err := serviceDetail.Save(c, tx); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2005, err)
+		return
+	}
+	if err := saveConfAudit(c, tx, dao.AuditTargetService, serviceDetail.Info.ID, serviceDetail.Info.ServiceName,
+		dao.AuditActionUpdate, before, common.Obj2Json(serviceDetail)); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2006, err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		middleware.ResponseError(c, 2007, err)
+		return
+	}
+	publishConfChange(c, dao.ConfChangeTypeService, serviceDetail.Info.ServiceName)
+	middleware.ResponseSuccess(c, "")
+}
+
+// ServiceAddTcp godoc
+// @Summary 添加TCP服务
+// @Description 添加TCP服务
+// @Tags 服务管理
+// @ID /service/service_add_tcp
+// @Accept  json
+// @Produce  json
+// @Param body body dto.ServiceAddTcpInput true "body"
+// @Success 200 {object} middleware.Response{data=string} "success"
+// @Router /service/service_add_tcp [post]
+func (service *ServiceController) ServiceAddTcp(c *gin.Context) {
+	params := &dto.ServiceAddTcpInput{}
+	if err := params.GetValidParams(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+	if len(strings.Split(params.IpList, ",")) != len(strings.Split(params.WeightList, ",")) {
+		middleware.ResponseError(c, 2001, errors.New("IP列表与权重列表数量不一致"))
+		return
+	}
+	if params.DiscoveryType != loadbalance.DiscoveryStatic && params.DiscoveryTarget == "" {
+		middleware.ResponseError(c, 2001, errors.New("服务发现地址不能为空"))
+		return
+	}
+
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+	tx = tx.Begin()
+	if err := checkServiceNameUsable(c, tx, params.ServiceName); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2003, err)
+		return
+	}
+	if err := checkPortUsable(c, tx, params.Port, 0); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2004, err)
+		return
+	}
+
+	serviceDetail := &dao.ServiceDetail{
+		Info: &dao.ServiceInfo{
+			LoadType:    common.LoadTypeTCP,
+			ServiceName: params.ServiceName,
+			ServiceDesc: params.ServiceDesc,
+		},
+		TCPRule: &dao.TcpRule{
+			Port: params.Port,
+		},
+		AccessControl: &dao.AccessControl{
+			OpenAuth:          params.OpenAuth,
+			BlackList:         params.BlackList,
+			WhiteList:         params.WhiteList,
+			WhiteHostName:     params.WhiteHostName,
+			ClientIPFlowLimit: params.ClientIPFlowLimit,
+			ServiceFlowLimit:  params.ServiceFlowLimit,
+		},
+		LoadBalance: &dao.LoadBalance{
+			RoundType:  params.RoundType,
+			IpList:     params.IpList,
+			WeightList: params.WeightList,
+		},
+	}
+	serviceDetail.LoadBalance.ApplySetting(params.LoadBalanceSettingInput)
+	if err := serviceDetail.Save(c, tx); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2005, err)
+		return
+	}
+	if err := saveConfAudit(c, tx, dao.AuditTargetService, serviceDetail.Info.ID, serviceDetail.Info.ServiceName,
+		dao.AuditActionAdd, "", common.Obj2Json(serviceDetail)); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2006, err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		middleware.ResponseError(c, 2007, err)
+		return
+	}
+	publishConfChange(c, dao.ConfChangeTypeService, serviceDetail.Info.ServiceName)
+	middleware.ResponseSuccess(c, "")
+}
+
+// ServiceUpdateTcp godoc
+// @Summary 修改TCP服务
+// @Description 修改TCP服务
+// @Tags 服务管理
+// @ID /service/service_update_tcp
+// @Accept  json
+// @Produce  json
+// @Param body body dto.ServiceUpdateTcpInput true "body"
+// @Success 200 {object} middleware.Response{data=string} "success"
+// @Router /service/service_update_tcp [post]
+func (service *ServiceController) ServiceUpdateTcp(c *gin.Context) {
+	params := &dto.ServiceUpdateTcpInput{}
+	if err := params.GetValidParams(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+	if len(strings.Split(params.IpList, ",")) != len(strings.Split(params.WeightList, ",")) {
+		middleware.ResponseError(c, 2001, errors.New("IP列表与权重列表数量不一致"))
+		return
+	}
+	if params.DiscoveryType != loadbalance.DiscoveryStatic && params.DiscoveryTarget == "" {
+		middleware.ResponseError(c, 2001, errors.New("服务发现地址不能为空"))
+		return
+	}
+
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+	tx = tx.Begin()
+	serviceDetail, err := findServiceDetail(c, tx, params.ID, params.ServiceName, common.LoadTypeTCP)
+	if err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2003, err)
+		return
+	}
+	// 记录修改前快照
+	before := common.Obj2Json(serviceDetail)
+	if err := checkPortUsable(c, tx, params.Port, params.ID); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2004, err)
+		return
+	}
+
+	serviceDetail.Info.ServiceDesc = params.ServiceDesc
+	serviceDetail.TCPRule.Port = params.Port
+
+	accessControl := serviceDetail.AccessControl
+	accessControl.OpenAuth = params.OpenAuth
+	accessControl.BlackList = params.BlackList
+	accessControl.WhiteList = params.WhiteList
+	accessControl.WhiteHostName = params.WhiteHostName
+	accessControl.ClientIPFlowLimit = params.ClientIPFlowLimit
+	accessControl.ServiceFlowLimit = params.ServiceFlowLimit
+
+	loadBalance := serviceDetail.LoadBalance
+	loadBalance.RoundType = params.RoundType
+	loadBalance.IpList = params.IpList
+	loadBalance.WeightList = params.WeightList
+	loadBalance.ApplySetting(params.LoadBalanceSettingInput)
+
+	if err := serviceDetail.Save(c, tx); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2005, err)
+		return
+	}
+	if err := saveConfAudit(c, tx, dao.AuditTargetService, serviceDetail.Info.ID, serviceDetail.Info.ServiceName,
+		dao.AuditActionUpdate, before, common.Obj2Json(serviceDetail)); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2006, err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		middleware.ResponseError(c, 2007, err)
+		return
+	}
+	publishConfChange(c, dao.ConfChangeTypeService, serviceDetail.Info.ServiceName)
+	middleware.ResponseSuccess(c, "")
+}
+
+// ServiceAddGrpc godoc
+// @Summary 添加GRPC服务
+// @Description 添加GRPC服务
+// @Tags 服务管理
+// @ID /service/service_add_grpc
+// @Accept  json
+// @Produce  json
+// @Param body body dto.ServiceAddGrpcInput true "body"
+// @Success 200 {object} middleware.Response{data=string} "success"
+// @Router /service/service_add_grpc [post]
+func (service *ServiceController) ServiceAddGrpc(c *gin.Context) {
+	params := &dto.ServiceAddGrpcInput{}
+	if err := params.GetValidParams(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+	if len(strings.Split(params.IpList, ",")) != len(strings.Split(params.WeightList, ",")) {
+		middleware.ResponseError(c, 2001, errors.New("IP列表与权重列表数量不一致"))
+		return
+	}
+	if params.DiscoveryType != loadbalance.DiscoveryStatic && params.DiscoveryTarget == "" {
+		middleware.ResponseError(c, 2001, errors.New("服务发现地址不能为空"))
+		return
+	}
+
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+	tx = tx.Begin()
+	if err := checkServiceNameUsable(c, tx, params.ServiceName); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2003, err)
+		return
+	}
+	if err := checkPortUsable(c, tx, params.Port, 0); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2004, err)
+		return
+	}
+
+	serviceDetail := &dao.ServiceDetail{
+		Info: &dao.ServiceInfo{
+			LoadType:    common.LoadTypeGRPC,
+			ServiceName: params.ServiceName,
+			ServiceDesc: params.ServiceDesc,
+		},
+		GRPCRule: &dao.GrpcRule{
+			Port:           params.Port,
+			HeaderTransfor: params.HeaderTransfor,
+		},
+		AccessControl: &dao.AccessControl{
+			OpenAuth:          params.OpenAuth,
+			BlackList:         params.BlackList,
+			WhiteList:         params.WhiteList,
+			WhiteHostName:     params.WhiteHostName,
+			ClientIPFlowLimit: params.ClientIPFlowLimit,
+			ServiceFlowLimit:  params.ServiceFlowLimit,
+		},
+		LoadBalance: &dao.LoadBalance{
+			RoundType:  params.RoundType,
+			IpList:     params.IpList,
+			WeightList: params.WeightList,
+		},
+	}
+	serviceDetail.LoadBalance.ApplySetting(params.LoadBalanceSettingInput)
+	if err := serviceDetail.Save(c, tx); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2005, err)
+		return
+	}
+	if err := saveConfAudit(c, tx, dao.AuditTargetService, serviceDetail.Info.ID, serviceDetail.Info.ServiceName,
+		dao.AuditActionAdd, "", common.Obj2Json(serviceDetail)); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2006, err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		middleware.ResponseError(c, 2007, err)
+		return
+	}
+	publishConfChange(c, dao.ConfChangeTypeService, serviceDetail.Info.ServiceName)
+	middleware.ResponseSuccess(c, "")
+}
+
+// ServiceUpdateGrpc godoc
+// @Summary 修改GRPC服务
+// @Description 修改GRPC服务
+// @Tags 服务管理
+// @ID /service/service_update_grpc
+// @Accept  json
+// @Produce  json
+// @Param body body dto.ServiceUpdateGrpcInput true "body"
+// @Success 200 {object} middleware.Response{data=string} "success"
+// @Router /service/service_update_grpc [post]
+func (service *ServiceController) ServiceUpdateGrpc(c *gin.Context) {
+	params := &dto.ServiceUpdateGrpcInput{}
+	if err := params.GetValidParams(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+	if len(strings.Split(params.IpList, ",")) != len(strings.Split(params.WeightList, ",")) {
+		middleware.ResponseError(c, 2001, errors.New("IP列表与权重列表数量不一致"))
+		return
+	}
+	if params.DiscoveryType != loadbalance.DiscoveryStatic && params.DiscoveryTarget == "" {
+		middleware.ResponseError(c, 2001, errors.New("服务发现地址不能为空"))
+		return
+	}
+
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+	tx = tx.Begin()
+	serviceDetail, err := findServiceDetail(c, tx, params.ID, params.ServiceName, common.LoadTypeGRPC)
+	if err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2003, err)
+		return
+	}
+	// 记录修改前快照
+	before := common.Obj2Json(serviceDetail)
+	if err := checkPortUsable(c, tx, params.Port, params.ID); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2004, err)
+		return
+	}
+
+	serviceDetail.Info.ServiceDesc = params.ServiceDesc
+	serviceDetail.GRPCRule.Port = params.Port
+	serviceDetail.GRPCRule.HeaderTransfor = params.HeaderTransfor
+
+	accessControl := serviceDetail.AccessControl
+	accessControl.OpenAuth = params.OpenAuth
+	accessControl.BlackList = params.BlackList
+	accessControl.WhiteList = params.WhiteList
+	accessControl.WhiteHostName = params.WhiteHostName
+	accessControl.ClientIPFlowLimit = params.ClientIPFlowLimit
+	accessControl.ServiceFlowLimit = params.ServiceFlowLimit
+
+	loadBalance := serviceDetail.LoadBalance
+	loadBalance.RoundType = params.RoundType
+	loadBalance.IpList = params.IpList
+	loadBalance.WeightList = params.WeightList
+	loadBalance.ApplySetting(params.LoadBalanceSettingInput)
+
+	if err := serviceDetail.Save(c, tx); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2005, err)
+		return
+	}
+	if err := saveConfAudit(c, tx, dao.AuditTargetService, serviceDetail.Info.ID, serviceDetail.Info.ServiceName,
+		dao.AuditActionUpdate, before, common.Obj2Json(serviceDetail)); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2006, err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		middleware.ResponseError(c, 2007, err)
+		return
+	}
+	publishConfChange(c, dao.ConfChangeTypeService, serviceDetail.Info.ServiceName)
+	middleware.ResponseSuccess(c, "")
+}
+
+// serviceAddr 拼接服务对外的接入地址
+//
+//	1、http后缀接入 clusterIP+clusterPort+path
+//	2、http域名接入 domain
+//	3、tcp、grpc接入 clusterIP+servicePort
+func serviceAddr(serviceDetail *dao.ServiceDetail) string {
+	clusterIP := common.GetStringConf("base.cluster.cluster_ip")
+	clusterPort := common.GetStringConf("base.cluster.cluster_port")
+	clusterSSLPort := common.GetStringConf("base.cluster.cluster_ssl_port")
+	switch serviceDetail.Info.LoadType {
+	case common.LoadTypeHTTP:
+		if serviceDetail.HTTPRule.RuleType == common.HTTPRuleTypeDomain {
+			return serviceDetail.HTTPRule.Rule
+		}
+		if serviceDetail.HTTPRule.NeedHttps == 1 {
+			return fmt.Sprintf("%s:%s%s", clusterIP, clusterSSLPort, serviceDetail.HTTPRule.Rule)
+		}
+		return fmt.Sprintf("%s:%s%s", clusterIP, clusterPort, serviceDetail.HTTPRule.Rule)
+	case common.LoadTypeTCP:
+		return fmt.Sprintf("%s:%d", clusterIP, serviceDetail.TCPRule.Port)
+	case common.LoadTypeGRPC:
+		return fmt.Sprintf("%s:%d", clusterIP, serviceDetail.GRPCRule.Port)
+	}
+	return "unknown"
+}
+
+// findServiceDetail 读取待修改的服务详情，服务名称作为缓存与统计的key，不允许修改
+func findServiceDetail(c *gin.Context, tx *gorm.DB, id int64, serviceName string, loadType int) (*dao.ServiceDetail, error) {
+	serviceInfo := &dao.ServiceInfo{ID: id}
+	serviceInfo, err := serviceInfo.Find(c, tx, serviceInfo)
+	if err != nil || serviceInfo.IsDelete == 1 {
+		return nil, errors.New("服务不存在")
+	}
+	if serviceInfo.LoadType != loadType {
+		return nil, errors.New("服务类型不匹配")
+	}
+	if serviceInfo.ServiceName != serviceName {
+		return nil, errors.New("服务名称不可修改")
+	}
+	return serviceInfo.ServiceDetail(c, tx, serviceInfo)
+}
+
+// checkServiceNameUsable 服务名称不能与未删除的服务重复
+func checkServiceNameUsable(c *gin.Context, tx *gorm.DB, serviceName string) error {
+	serviceInfo := &dao.ServiceInfo{ServiceName: serviceName}
+	if _, err := serviceInfo.Find(c, tx.Where("is_delete=0"), serviceInfo); err == nil {
+		return errors.New("服务名被占用，请重新输入")
+	}
+	return nil
+}
+
+// checkHTTPRuleUsable 接入前缀或域名不能被其他服务占用
+func checkHTTPRuleUsable(c *gin.Context, tx *gorm.DB, ruleType int, rule string, serviceID int64) error {
+	list, err := (&dao.HttpRule{}).ListByRule(c, tx, ruleType, rule)
+	if err != nil {
+		return err
+	}
+	for _, item := range list {
+		if item.ServiceID != serviceID {
+			return errors.New("服务接入前缀或域名已存在")
+		}
+	}
+	return nil
+}
+
+// checkPortUsable TCP与GRPC服务共用8001-8999端口段，端口不能被其他服务占用
+func checkPortUsable(c *gin.Context, tx *gorm.DB, port int, serviceID int64) error {
+	tcpList, err := (&dao.TcpRule{}).ListByPort(c, tx, port)
+	if err != nil {
+		return err
+	}
+	for _, item := range tcpList {
+		if item.ServiceID != serviceID {
+			return errors.New(fmt.Sprintf("端口%d已被TCP服务占用，请重新输入", port))
+		}
+	}
+	grpcList, err := (&dao.GrpcRule{}).ListByPort(c, tx, port)
+	if err != nil {
+		return err
+	}
+	for _, item := range grpcList {
+		if item.ServiceID != serviceID {
+			return errors.New(fmt.Sprintf("端口%d已被GRPC服务占用，请重新输入", port))
+		}
+	}
+	return nil
+}
+
+// publishConfChange 通知网关节点重新加载变更项，需在事务提交成功后调用
+// 发布失败时由节点轮询ConfVersion兜底，变更与审计记录同一事务写入，审计id变化即触发全量reload
+func publishConfChange(c *gin.Context, changeType string, name string) {
+	if err := dao.PublishConfChange(changeType, name); err != nil {
+		util.ComLogWarning(c, "_com_conf_publish_failure", map[string]interface{}{
+			"type": changeType,
+			"name": name,
+			"err":  err.Error(),
+		})
+	}
+}
+
+// ServiceHistory godoc
+// @Summary 服务变更记录
+// @Description 服务变更记录
+// @Tags 服务管理
+// @ID /service/service_history
+// @Accept  json
+// @Produce  json
+// @Param id query string true "服务ID"
+// @Param page_size query int true "每页个数"
+// @Param page_no query int true "当前页数"
+// @Success 200 {object} middleware.Response{data=dto.ConfHistoryOutput} "success"
+// @Router /service/service_history [get]
+func (service *ServiceController) ServiceHistory(c *gin.Context) {
+	params := &dto.ConfHistoryInput{}
+	if err := params.BindValidParam(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
+	}
+	out, err := confHistory(c, tx, dao.AuditTargetService, params)
+	if err != nil {
+		middleware.ResponseError(c, 2002, err)
+		return
+	}
+	middleware.ResponseSuccess(c, out)
+}
+
+// ServiceRollback godoc
+// @Summary 服务回滚
+// @Description 将服务恢复为指定版本变更后的配置
+// @Tags 服务管理
+// @ID /service/service_rollback
+// @Accept  json
+// @Produce  json
+// @Param body body dto.ConfRollbackInput true "body"
+// @Success 200 {object} middleware.Response{data=string} "success"
+// @Router /service/service_rollback [post]
+func (service *ServiceController) ServiceRollback(c *gin.Context) {
+	params := &dto.ConfRollbackInput{}
+	if err := params.BindValidParam(c); err != nil {
+		middleware.ResponseError(c, 2000, err)
+		return
+	}
+
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		middleware.ResponseError(c, 2001, err)
+		return
+	}
+	tx = tx.Begin()
+	serviceInfo := &dao.ServiceInfo{ID: params.ID}
+	serviceInfo, err = serviceInfo.Find(c, tx, serviceInfo)
+	if err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2002, errors.New("服务不存在"))
+		return
+	}
+	current, err := serviceInfo.ServiceDetail(c, tx, serviceInfo)
+	if err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2003, err)
+		return
+	}
+	before := common.Obj2Json(current)
+
+	// 取目标版本变更后的快照
+	search := &dao.ConfAudit{TargetType: dao.AuditTargetService, TargetID: params.ID, Version: params.Version}
+	audit, err := search.Find(c, tx, search)
+	if err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2004, errors.New("版本不存在"))
+		return
+	}
+	serviceDetail := &dao.ServiceDetail{}
+	if err := json.Unmarshal([]byte(audit.AfterData), serviceDetail); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2005, err)
+		return
+	}
+	if serviceDetail.Info == nil || serviceDetail.AccessControl == nil || serviceDetail.LoadBalance == nil || !serviceDetail.HasRule() ||
+		serviceDetail.Info.ServiceName != serviceInfo.ServiceName || serviceDetail.Info.LoadType != serviceInfo.LoadType {
+		tx.Rollback()
+		middleware.ResponseError(c, 2006, errors.New("版本快照与服务不匹配"))
+		return
+	}
+
+	// 回滚后服务处于启用状态时，名称、接入规则、端口需未被其他服务占用
+	if serviceDetail.Info.IsDelete == 0 {
+		if serviceInfo.IsDelete == 1 {
+			if err := checkServiceNameUsable(c, tx, serviceInfo.ServiceName); err != nil {
+				tx.Rollback()
+				middleware.ResponseError(c, 2007, err)
+				return
+			}
+		}
+		switch serviceDetail.Info.LoadType {
+		case common.LoadTypeHTTP:
+			err = checkHTTPRuleUsable(c, tx, serviceDetail.HTTPRule.RuleType, serviceDetail.HTTPRule.Rule, params.ID)
+		case common.LoadTypeTCP:
+			err = checkPortUsable(c, tx, serviceDetail.TCPRule.Port, params.ID)
+		case common.LoadTypeGRPC:
+			err = checkPortUsable(c, tx, serviceDetail.GRPCRule.Port, params.ID)
+		}
+		if err != nil {
+			tx.Rollback()
+			middleware.ResponseError(c, 2007, err)
+			return
+		}
+	}
+
+	if err := serviceDetail.Save(c, tx); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2008, err)
+		return
+	}
+	if err := saveConfAudit(c, tx, dao.AuditTargetService, serviceDetail.Info.ID, serviceDetail.Info.ServiceName,
+		dao.AuditActionRollback, before, common.Obj2Json(serviceDetail)); err != nil {
+		tx.Rollback()
+		middleware.ResponseError(c, 2009, err)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		middleware.ResponseError(c, 2010, err)
+		return
+	}
+	publishConfChange(c, dao.ConfChangeTypeService, serviceDetail.Info.ServiceName)
+	middleware.ResponseSuccess(c, "")
+}
@@ -7,6 +7,7 @@ import (
 	"go_gateway/bussiness/util"
 	"go_gateway/common"
 	"net/http/httptest"
+	"sort"
 	"sync"
 	"time"
 )
@@ -73,48 +74,152 @@ func init() {
 }
 
 type AppManager struct {
-	AppMap   map[string]*App
-	AppSlice []*App
-	Locker   sync.RWMutex
-	init     sync.Once
-	err      error
+	AppMap    map[string]*App
+	AppSlice  []*App
+	Locker    sync.RWMutex
+	init      sync.Once
+	err       error
+	signature map[string]string
+	observers []ConfObserver
 }
 
 func NewAppManager() *AppManager {
 	return &AppManager{
-		AppMap:   map[string]*App{},
-		AppSlice: []*App{},
-		Locker:   sync.RWMutex{},
-		init:     sync.Once{},
+		AppMap:    map[string]*App{},
+		AppSlice:  []*App{},
+		Locker:    sync.RWMutex{},
+		init:      sync.Once{},
+		signature: map[string]string{},
 	}
 }
 
+func (s *AppManager) Attach(o ConfObserver) {
+	s.Locker.Lock()
+	defer s.Locker.Unlock()
+	s.observers = append(s.observers, o)
+}
+
 func (s *AppManager) GetAppList() []*App {
+	s.Locker.RLock()
+	defer s.Locker.RUnlock()
 	return s.AppSlice
 }
 
 func (s *AppManager) LoadOnce() error {
 	s.init.Do(func() {
-		appInfo := &App{}
-		c, _ := gin.CreateTestContext(httptest.NewRecorder())
-		tx, err := common.GetGormPool("default")
-		if err != nil {
-			s.err = err
-			return
-		}
-		params := &dto.APPListInput{PageNo: 1, PageSize: 99999}
-		list, _, err := appInfo.APPList(c, tx, params)
+		appMap, appSlice, signature, err := s.load()
 		if err != nil {
 			s.err = err
 			return
 		}
 		s.Locker.Lock()
 		defer s.Locker.Unlock()
-		for _, listItem := range list {
-			tmpItem := listItem
-			s.AppMap[listItem.AppID] = &tmpItem
-			s.AppSlice = append(s.AppSlice, &tmpItem)
-		}
+		s.AppMap = appMap
+		s.AppSlice = appSlice
+		s.signature = signature
 	})
 	return s.err
 }
+
+// Reload 重新读取全部租户并整体替换快照，返回发生变更的租户app_id
+func (s *AppManager) Reload() ([]string, error) {
+	appMap, appSlice, signature, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return s.apply(appMap, appSlice, signature), nil
+}
+
+// ReloadApps 只重新读取指定的租户，其余租户沿用当前快照
+// 仅数据库来源支持按app_id读取，其他来源退化为全量reload
+func (s *AppManager) ReloadApps(appIDs []string) ([]string, error) {
+	if _, ok := ConfSourceHandler.(*DBConfSource); !ok {
+		return s.Reload()
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		return nil, err
+	}
+	s.Locker.RLock()
+	appMap := map[string]*App{}
+	for appID, item := range s.AppMap {
+		appMap[appID] = item
+	}
+	signature := map[string]string{}
+	for appID, sign := range s.signature {
+		signature[appID] = sign
+	}
+	s.Locker.RUnlock()
+
+	for _, appID := range appIDs {
+		delete(appMap, appID)
+		delete(signature, appID)
+		search := &App{AppID: appID}
+		appInfo, err := search.Find(c, tx.Where("is_delete=0"), search)
+		if err == gorm.ErrRecordNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		appMap[appID] = appInfo
+		signature[appID] = common.Obj2Json(appInfo)
+	}
+
+	appSlice := []*App{}
+	for _, item := range appMap {
+		appSlice = append(appSlice, item)
+	}
+	sort.Slice(appSlice, func(i, j int) bool {
+		return appSlice[i].ID > appSlice[j].ID
+	})
+	return s.apply(appMap, appSlice, signature), nil
+}
+
+// apply 替换快照并通知监听者，返回变更的租户app_id
+func (s *AppManager) apply(appMap map[string]*App, appSlice []*App, signature map[string]string) []string {
+	s.Locker.Lock()
+	changed := []string{}
+	for appID, sign := range signature {
+		if oldSign, ok := s.signature[appID]; !ok || oldSign != sign {
+			changed = append(changed, appID)
+		}
+	}
+	for appID := range s.signature {
+		if _, ok := signature[appID]; !ok {
+			changed = append(changed, appID)
+		}
+	}
+	s.AppMap = appMap
+	s.AppSlice = appSlice
+	s.signature = signature
+	observers := s.observers
+	s.Locker.Unlock()
+
+	if len(changed) == 0 {
+		return changed
+	}
+	changedKeys := []string{}
+	for _, appID := range changed {
+		changedKeys = append(changedKeys, common.FlowAppPrefix+appID)
+	}
+	for _, obs := range observers {
+		obs.Update(changedKeys)
+	}
+	return changed
+}
+
+func (s *AppManager) load() (map[string]*App, []*App, map[string]string, error) {
+	appSlice, err := ConfSourceHandler.LoadApps()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	appMap := map[string]*App{}
+	signature := map[string]string{}
+	for _, appItem := range appSlice {
+		appMap[appItem.AppID] = appItem
+		signature[appItem.AppID] = common.Obj2Json(appItem)
+	}
+	return appMap, appSlice, signature, nil
+}
@@ -0,0 +1,75 @@
+package dao
+
+import (
+	"github.com/gin-gonic/gin"
+	"go_gateway/bussiness/gorm"
+	"go_gateway/bussiness/mvc/dto"
+	"go_gateway/bussiness/util"
+	"time"
+)
+
+const (
+	AuditTargetService = "service"
+	AuditTargetApp     = "app"
+
+	AuditActionAdd      = "add"
+	AuditActionUpdate   = "update"
+	AuditActionDelete   = "delete"
+	AuditActionRollback = "rollback"
+)
+
+// ConfAudit 配置审计记录，BeforeData、AfterData为ServiceDetail或App的json快照
+type ConfAudit struct {
+	ID         int64     `json:"id" gorm:"primary_key" description:"自增主键"`
+	TargetType string    `json:"target_type" gorm:"column:target_type" description:"对象类型 service/app"`
+	TargetID   int64     `json:"target_id" gorm:"column:target_id" description:"服务id或租户自增id"`
+	TargetName string    `json:"target_name" gorm:"column:target_name" description:"服务名或租户app_id"`
+	Version    int       `json:"version" gorm:"column:version" description:"对象内递增的版本号"`
+	Action     string    `json:"action" gorm:"column:action" description:"操作 add/update/delete/rollback"`
+	BeforeData string    `json:"before_data" gorm:"column:before_data" description:"变更前快照"`
+	AfterData  string    `json:"after_data" gorm:"column:after_data" description:"变更后快照"`
+	AdminUser  string    `json:"admin_user" gorm:"column:admin_user" description:"操作管理员"`
+	CreatedAt  time.Time `json:"create_at" gorm:"column:create_at" description:"操作时间"`
+}
+
+func (t *ConfAudit) TableName() string {
+	return "gateway_conf_audit"
+}
+
+func (t *ConfAudit) Find(c *gin.Context, tx *gorm.DB, search *ConfAudit) (*ConfAudit, error) {
+	out := &ConfAudit{}
+	err := tx.SetCtx(util.GetGinTraceContext(c)).Where(search).Find(out).Error
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Save 版本号取该对象当前最大版本+1，需与配置写入在同一事务中执行
+func (t *ConfAudit) Save(c *gin.Context, tx *gorm.DB) error {
+	var maxVersion int
+	row := tx.SetCtx(util.GetGinTraceContext(c)).Table(t.TableName()).
+		Where("target_type=? and target_id=?", t.TargetType, t.TargetID).
+		Select("ifnull(max(version),0)").Row()
+	if err := row.Scan(&maxVersion); err != nil {
+		return err
+	}
+	t.Version = maxVersion + 1
+	return tx.SetCtx(util.GetGinTraceContext(c)).Save(t).Error
+}
+
+func (t *ConfAudit) PageList(c *gin.Context, tx *gorm.DB, targetType string, param *dto.ConfHistoryInput) ([]ConfAudit, int64, error) {
+	total := int64(0)
+	list := []ConfAudit{}
+	offset := (param.PageNo - 1) * param.PageSize
+
+	query := tx.SetCtx(util.GetGinTraceContext(c))
+	query = query.Table(t.TableName()).Where("target_type=? and target_id=?", targetType, param.ID)
+	if err := query.Limit(param.PageSize).Offset(offset).Order("version desc").Find(&list).Error; err != nil && err != gorm.ErrRecordNotFound {
+		return nil, 0, err
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
@@ -0,0 +1,412 @@
+package dao
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"go_gateway/bussiness/gorm"
+	"go_gateway/bussiness/mvc/dto"
+	"go_gateway/common"
+	"sort"
+	"time"
+)
+
+// ConfDocOmitKeys 导出文档时去掉的数据库字段，导入时按服务名、app_id与数据库记录对应
+var ConfDocOmitKeys = []string{"id", "service_id", "create_at", "update_at", "is_delete"}
+
+// ConfDocument 声明式配置文档，Services或Apps为nil时导入不处理该部分
+type ConfDocument struct {
+	Services []*ServiceDetail `json:"services,omitempty"`
+	Apps     []*App           `json:"apps,omitempty"`
+}
+
+// ConfImportPlan 导入计划，Update中的对象已填充数据库记录的主键
+type ConfImportPlan struct {
+	CreateServices []*ServiceDetail
+	UpdateServices []*ServiceDetail
+	DeleteServices []*ServiceDetail
+	CreateApps     []*App
+	UpdateApps     []*App
+	DeleteApps     []*App
+
+	serviceBefore map[string]string
+	appBefore     map[string]string
+}
+
+// Describe 输出可读的执行计划
+func (p *ConfImportPlan) Describe() []string {
+	out := []string{}
+	for _, item := range p.CreateServices {
+		out = append(out, fmt.Sprintf("create service %s", item.Info.ServiceName))
+	}
+	for _, item := range p.UpdateServices {
+		out = append(out, fmt.Sprintf("update service %s", item.Info.ServiceName))
+	}
+	for _, item := range p.DeleteServices {
+		out = append(out, fmt.Sprintf("delete service %s", item.Info.ServiceName))
+	}
+	for _, item := range p.CreateApps {
+		out = append(out, fmt.Sprintf("create app %s", item.AppID))
+	}
+	for _, item := range p.UpdateApps {
+		out = append(out, fmt.Sprintf("update app %s", item.AppID))
+	}
+	for _, item := range p.DeleteApps {
+		out = append(out, fmt.Sprintf("delete app %s", item.AppID))
+	}
+	return out
+}
+
+// ExportConfDoc 导出服务与租户，serviceNames、appIDs为空时导出该部分全部记录，withServices、withApps控制是否导出该部分
+func ExportConfDoc(c *gin.Context, tx *gorm.DB, withServices bool, serviceNames []string, withApps bool, appIDs []string) (*ConfDocument, error) {
+	doc := &ConfDocument{}
+	if withServices {
+		details, err := activeServiceDetails(c, tx)
+		if err != nil {
+			return nil, err
+		}
+		doc.Services = []*ServiceDetail{}
+		for _, item := range details {
+			if len(serviceNames) > 0 && !common.InStringSlice(serviceNames, item.Info.ServiceName) {
+				continue
+			}
+			detail := StripServiceDetail(item)
+			// 导出时去掉与服务类型无关的接入规则
+			if detail.Info.LoadType != common.LoadTypeHTTP {
+				detail.HTTPRule = nil
+			}
+			if detail.Info.LoadType != common.LoadTypeTCP {
+				detail.TCPRule = nil
+			}
+			if detail.Info.LoadType != common.LoadTypeGRPC {
+				detail.GRPCRule = nil
+			}
+			doc.Services = append(doc.Services, detail)
+		}
+	}
+	if withApps {
+		apps, err := activeApps(c, tx)
+		if err != nil {
+			return nil, err
+		}
+		doc.Apps = []*App{}
+		for _, item := range apps {
+			if len(appIDs) > 0 && !common.InStringSlice(appIDs, item.AppID) {
+				continue
+			}
+			doc.Apps = append(doc.Apps, StripApp(item))
+		}
+	}
+	return doc, nil
+}
+
+// PlanConfImport 对比文档与数据库生成导入计划，prune为true时删除文档中没有的服务、租户
+// 文档字段格式由调用方按dto规则校验，这里校验名称重复、端口及接入规则冲突
+func PlanConfImport(c *gin.Context, tx *gorm.DB, doc *ConfDocument, prune bool) (*ConfImportPlan, error) {
+	plan := &ConfImportPlan{
+		serviceBefore: map[string]string{},
+		appBefore:     map[string]string{},
+	}
+	if doc.Services != nil {
+		if err := planServices(c, tx, doc.Services, prune, plan); err != nil {
+			return nil, err
+		}
+	}
+	if doc.Apps != nil {
+		if err := planApps(c, tx, doc.Apps, prune, plan); err != nil {
+			return nil, err
+		}
+	}
+	return plan, nil
+}
+
+func planServices(c *gin.Context, tx *gorm.DB, services []*ServiceDetail, prune bool, plan *ConfImportPlan) error {
+	current, err := activeServiceDetails(c, tx)
+	if err != nil {
+		return err
+	}
+	currentMap := map[string]*ServiceDetail{}
+	for _, item := range current {
+		currentMap[item.Info.ServiceName] = item
+	}
+
+	docNames := map[string]bool{}
+	final := []*ServiceDetail{}
+	for _, item := range services {
+		if item.Info == nil || item.AccessControl == nil || item.LoadBalance == nil {
+			return errors.New("服务缺少info、access_control或loadbalance")
+		}
+		name := item.Info.ServiceName
+		if docNames[name] {
+			return fmt.Errorf("服务%s重复", name)
+		}
+		docNames[name] = true
+		detail := StripServiceDetail(item)
+
+		exist, ok := currentMap[name]
+		if !ok {
+			plan.CreateServices = append(plan.CreateServices, detail)
+			final = append(final, detail)
+			continue
+		}
+		if exist.Info.LoadType != detail.Info.LoadType {
+			return fmt.Errorf("服务%s类型不可修改", name)
+		}
+		final = append(final, detail)
+		if common.Obj2Json(StripServiceDetail(exist)) == common.Obj2Json(detail) {
+			continue
+		}
+		// 沿用数据库主键，保存时按主键更新
+		detail.Info.ID = exist.Info.ID
+		detail.Info.CreatedAt = exist.Info.CreatedAt
+		detail.HTTPRule.ID = exist.HTTPRule.ID
+		detail.TCPRule.ID = exist.TCPRule.ID
+		detail.GRPCRule.ID = exist.GRPCRule.ID
+		detail.AccessControl.ID = exist.AccessControl.ID
+		detail.LoadBalance.ID = exist.LoadBalance.ID
+		plan.UpdateServices = append(plan.UpdateServices, detail)
+		plan.serviceBefore[name] = common.Obj2Json(exist)
+	}
+	for _, item := range current {
+		if docNames[item.Info.ServiceName] {
+			continue
+		}
+		if prune {
+			plan.DeleteServices = append(plan.DeleteServices, item)
+			plan.serviceBefore[item.Info.ServiceName] = common.Obj2Json(item)
+			continue
+		}
+		final = append(final, item)
+	}
+	return checkServiceConflict(final)
+}
+
+// checkServiceConflict 校验导入后的全部服务：TCP与GRPC端口不重复，HTTP接入前缀或域名不重复
+func checkServiceConflict(services []*ServiceDetail) error {
+	ports := map[int]string{}
+	rules := map[string]string{}
+	for _, item := range services {
+		name := item.Info.ServiceName
+		switch item.Info.LoadType {
+		case common.LoadTypeHTTP:
+			key := fmt.Sprintf("%d_%s", item.HTTPRule.RuleType, item.HTTPRule.Rule)
+			if other, ok := rules[key]; ok {
+				return fmt.Errorf("服务%s与%s接入前缀或域名冲突", name, other)
+			}
+			rules[key] = name
+		case common.LoadTypeTCP, common.LoadTypeGRPC:
+			port := item.TCPRule.Port
+			if item.Info.LoadType == common.LoadTypeGRPC {
+				port = item.GRPCRule.Port
+			}
+			if other, ok := ports[port]; ok {
+				return fmt.Errorf("服务%s与%s端口%d冲突", name, other, port)
+			}
+			ports[port] = name
+		}
+	}
+	return nil
+}
+
+func planApps(c *gin.Context, tx *gorm.DB, apps []*App, prune bool, plan *ConfImportPlan) error {
+	current, err := activeApps(c, tx)
+	if err != nil {
+		return err
+	}
+	currentMap := map[string]*App{}
+	for _, item := range current {
+		currentMap[item.AppID] = item
+	}
+
+	docAppIDs := map[string]bool{}
+	for _, item := range apps {
+		if docAppIDs[item.AppID] {
+			return fmt.Errorf("租户%s重复", item.AppID)
+		}
+		docAppIDs[item.AppID] = true
+		app := StripApp(item)
+		if app.Secret == "" {
+			app.Secret = common.MD5(app.AppID)
+		}
+
+		exist, ok := currentMap[app.AppID]
+		if !ok {
+			plan.CreateApps = append(plan.CreateApps, app)
+			continue
+		}
+		if common.Obj2Json(StripApp(exist)) == common.Obj2Json(app) {
+			continue
+		}
+		app.ID = exist.ID
+		app.CreatedAt = exist.CreatedAt
+		plan.UpdateApps = append(plan.UpdateApps, app)
+		plan.appBefore[app.AppID] = common.Obj2Json(exist)
+	}
+	if prune {
+		for _, item := range current {
+			if docAppIDs[item.AppID] {
+				continue
+			}
+			plan.DeleteApps = append(plan.DeleteApps, item)
+			plan.appBefore[item.AppID] = common.Obj2Json(item)
+		}
+	}
+	return nil
+}
+
+// ApplyConfImport 在同一事务中执行导入计划并记录审计，调用方负责提交或回滚
+func ApplyConfImport(c *gin.Context, tx *gorm.DB, plan *ConfImportPlan, adminUser string) error {
+	for _, item := range plan.CreateServices {
+		if err := item.Save(c, tx); err != nil {
+			return err
+		}
+		if err := saveImportAudit(c, tx, AuditTargetService, item.Info.ID, item.Info.ServiceName,
+			AuditActionAdd, "", common.Obj2Json(item), adminUser); err != nil {
+			return err
+		}
+	}
+	for _, item := range plan.UpdateServices {
+		if err := item.Save(c, tx); err != nil {
+			return err
+		}
+		if err := saveImportAudit(c, tx, AuditTargetService, item.Info.ID, item.Info.ServiceName,
+			AuditActionUpdate, plan.serviceBefore[item.Info.ServiceName], common.Obj2Json(item), adminUser); err != nil {
+			return err
+		}
+	}
+	for _, item := range plan.DeleteServices {
+		item.Info.IsDelete = 1
+		if err := item.Info.Save(c, tx); err != nil {
+			return err
+		}
+		if err := saveImportAudit(c, tx, AuditTargetService, item.Info.ID, item.Info.ServiceName,
+			AuditActionDelete, plan.serviceBefore[item.Info.ServiceName], common.Obj2Json(item), adminUser); err != nil {
+			return err
+		}
+	}
+	for _, item := range plan.CreateApps {
+		if err := item.Save(c, tx); err != nil {
+			return err
+		}
+		if err := saveImportAudit(c, tx, AuditTargetApp, item.ID, item.AppID,
+			AuditActionAdd, "", common.Obj2Json(item), adminUser); err != nil {
+			return err
+		}
+	}
+	for _, item := range plan.UpdateApps {
+		if err := item.Save(c, tx); err != nil {
+			return err
+		}
+		if err := saveImportAudit(c, tx, AuditTargetApp, item.ID, item.AppID,
+			AuditActionUpdate, plan.appBefore[item.AppID], common.Obj2Json(item), adminUser); err != nil {
+			return err
+		}
+	}
+	for _, item := range plan.DeleteApps {
+		item.IsDelete = 1
+		if err := item.Save(c, tx); err != nil {
+			return err
+		}
+		if err := saveImportAudit(c, tx, AuditTargetApp, item.ID, item.AppID,
+			AuditActionDelete, plan.appBefore[item.AppID], common.Obj2Json(item), adminUser); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func saveImportAudit(c *gin.Context, tx *gorm.DB, targetType string, targetID int64, targetName string,
+	action string, before string, after string, adminUser string) error {
+	audit := &ConfAudit{
+		TargetType: targetType,
+		TargetID:   targetID,
+		TargetName: targetName,
+		Action:     action,
+		BeforeData: before,
+		AfterData:  after,
+		AdminUser:  adminUser,
+	}
+	return audit.Save(c, tx)
+}
+
+// StripServiceDetail 复制服务详情并清空主键、时间戳等数据库字段，与服务类型无关的接入规则置空
+func StripServiceDetail(detail *ServiceDetail) *ServiceDetail {
+	out := &ServiceDetail{}
+	bts, _ := json.Marshal(detail)
+	json.Unmarshal(bts, out)
+	out.Info.ID = 0
+	out.Info.IsDelete = 0
+	out.Info.CreatedAt, out.Info.UpdatedAt = time.Time{}, time.Time{}
+	httpRule, tcpRule, grpcRule := &HttpRule{}, &TcpRule{}, &GrpcRule{}
+	switch out.Info.LoadType {
+	case common.LoadTypeHTTP:
+		if out.HTTPRule != nil {
+			httpRule = out.HTTPRule
+		}
+	case common.LoadTypeTCP:
+		if out.TCPRule != nil {
+			tcpRule = out.TCPRule
+		}
+	case common.LoadTypeGRPC:
+		if out.GRPCRule != nil {
+			grpcRule = out.GRPCRule
+		}
+	}
+	httpRule.ID, httpRule.ServiceID = 0, 0
+	tcpRule.ID, tcpRule.ServiceID = 0, 0
+	grpcRule.ID, grpcRule.ServiceID = 0, 0
+	out.HTTPRule, out.TCPRule, out.GRPCRule = httpRule, tcpRule, grpcRule
+	if out.AccessControl != nil {
+		out.AccessControl.ID, out.AccessControl.ServiceID = 0, 0
+	}
+	if out.LoadBalance != nil {
+		out.LoadBalance.ID, out.LoadBalance.ServiceID = 0, 0
+	}
+	return out
+}
+
+// StripApp 复制租户并清空主键、时间戳等数据库字段
+func StripApp(app *App) *App {
+	out := *app
+	out.ID = 0
+	out.IsDelete = 0
+	out.CreatedAt, out.UpdatedAt = time.Time{}, time.Time{}
+	return &out
+}
+
+func activeServiceDetails(c *gin.Context, tx *gorm.DB) ([]*ServiceDetail, error) {
+	list, _, err := (&ServiceInfo{}).PageList(c, tx, &dto.ServiceListInput{PageNo: 1, PageSize: 99999})
+	if err != nil {
+		return nil, err
+	}
+	out := []*ServiceDetail{}
+	for _, listItem := range list {
+		tmpItem := listItem
+		detail, err := tmpItem.ServiceDetail(c, tx, &tmpItem)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, detail)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Info.ServiceName < out[j].Info.ServiceName
+	})
+	return out, nil
+}
+
+func activeApps(c *gin.Context, tx *gorm.DB) ([]*App, error) {
+	list, _, err := (&App{}).APPList(c, tx, &dto.APPListInput{PageNo: 1, PageSize: 99999})
+	if err != nil {
+		return nil, err
+	}
+	out := []*App{}
+	for _, listItem := range list {
+		tmpItem := listItem
+		out = append(out, &tmpItem)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].AppID < out[j].AppID
+	})
+	return out, nil
+}
@@ -0,0 +1,108 @@
+package dao
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go_gateway/bussiness/gorm"
+	"go_gateway/bussiness/util"
+	"go_gateway/common"
+	"log"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// DefaultConfReloadInterval 默认配置版本轮询间隔，单位秒
+const DefaultConfReloadInterval = 10
+
+var confReloadLocker sync.Mutex
+
+// ReloadConf 重新加载全部服务与租户，串行执行避免并发reload互相覆盖
+func ReloadConf() error {
+	confReloadLocker.Lock()
+	defer confReloadLocker.Unlock()
+	services, err := ServiceManagerHandler.Reload()
+	if err != nil {
+		return err
+	}
+	apps, err := AppManagerHandler.Reload()
+	if err != nil {
+		return err
+	}
+	if len(services) > 0 || len(apps) > 0 {
+		log.Printf(" [INFO] conf_reload changed services:%v apps:%v\n", services, apps)
+	}
+	return nil
+}
+
+// ConfVersion 配置版本号，由审计表的最大id及服务表、租户表的记录指纹组成
+// 后台的新增、修改、删除、回滚、导入都在同一事务中写入审计记录，规则、负载、权限表的变更也会使版本变化
+// 记录指纹用于发现绕过后台直接修改数据库的情况
+func ConfVersion() (string, error) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		return "", err
+	}
+	var auditID int64
+	row := tx.SetCtx(util.GetGinTraceContext(c)).Table((&ConfAudit{}).TableName()).
+		Select("ifnull(max(id),0)").Row()
+	if err := row.Scan(&auditID); err != nil {
+		return "", err
+	}
+	// ServiceInfo的UpdatedAt映射在create_at列，保存时刷新的是create_at
+	serviceVersion, err := tableVersion(c, tx, (&ServiceInfo{}).TableName(), "create_at")
+	if err != nil {
+		return "", err
+	}
+	appVersion, err := tableVersion(c, tx, (&App{}).TableName(), "update_at")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d|%s|%s", auditID, serviceVersion, appVersion), nil
+}
+
+// tableVersion 记录数、软删除数及updateColumn的最大值，新增、软删除、修改都会使其变化
+func tableVersion(c *gin.Context, tx *gorm.DB, tableName string, updateColumn string) (string, error) {
+	var num, deleted int64
+	var updateAt string
+	row := tx.SetCtx(util.GetGinTraceContext(c)).Table(tableName).
+		Select(fmt.Sprintf("count(*), ifnull(sum(is_delete),0), ifnull(max(%s),'')", updateColumn)).Row()
+	if err := row.Scan(&num, &deleted, &updateAt); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d_%d_%s", num, deleted, updateAt), nil
+}
+
+// WatchConfVersion 定时轮询配置来源的版本，版本变化时reload
+func WatchConfVersion(interval time.Duration) {
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				fmt.Println(err)
+			}
+		}()
+		lastVersion, err := ConfSourceHandler.Version()
+		if err != nil {
+			log.Printf(" [ERROR] conf_version err:%v\n", err)
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			<-ticker.C
+			version, err := ConfSourceHandler.Version()
+			if err != nil {
+				log.Printf(" [ERROR] conf_version err:%v\n", err)
+				continue
+			}
+			if version == lastVersion {
+				continue
+			}
+			if err := ReloadConf(); err != nil {
+				log.Printf(" [ERROR] conf_reload err:%v\n", err)
+				continue
+			}
+			lastVersion = version
+		}
+	}()
+}
@@ -0,0 +1,100 @@
+package dao
+
+import (
+	"github.com/gin-gonic/gin"
+	"go_gateway/bussiness/gorm"
+	_ "go_gateway/bussiness/gorm/dialects/sqlite"
+	"go_gateway/common"
+	"net/http/httptest"
+	"testing"
+)
+
+// testConfDB 以内存sqlite替换default连接池
+func testConfDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 内存库每个连接独立，限制为单连接
+	db.DB().SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&ServiceInfo{}, &LoadBalance{}, &ConfAudit{}, &App{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	pool := common.GORMMapPool
+	common.GORMMapPool = map[string]*gorm.DB{"default": db}
+	t.Cleanup(func() {
+		common.GORMMapPool = pool
+		db.Close()
+	})
+	return db
+}
+
+func mustConfVersion(t *testing.T) string {
+	version, err := ConfVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func TestConfVersionChanged(t *testing.T) {
+	db := testConfDB(t)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	info := &ServiceInfo{ServiceName: "test_service", ServiceDesc: "desc"}
+	lb := &LoadBalance{IpList: "127.0.0.1:80", WeightList: "50"}
+	if err := info.Save(c, db); err != nil {
+		t.Fatal(err)
+	}
+	lb.ServiceID = info.ID
+	if err := lb.Save(c, db); err != nil {
+		t.Fatal(err)
+	}
+	version := mustConfVersion(t)
+
+	// 与后台一致，配置与审计记录在同一事务中写入
+	edit := func(save func(tx *gorm.DB) error) {
+		tx := db.Begin()
+		if err := save(tx); err != nil {
+			tx.Rollback()
+			t.Fatal(err)
+		}
+		audit := &ConfAudit{TargetType: AuditTargetService, TargetID: info.ID, TargetName: info.ServiceName, Action: AuditActionUpdate}
+		if err := audit.Save(c, tx); err != nil {
+			tx.Rollback()
+			t.Fatal(err)
+		}
+		if err := tx.Commit().Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 修改服务描述
+	edit(func(tx *gorm.DB) error {
+		info.ServiceDesc = "new desc"
+		return info.Save(c, tx)
+	})
+	if next := mustConfVersion(t); next == version {
+		t.Fatalf("expect version changed after service edit, got %s", next)
+	} else {
+		version = next
+	}
+
+	// 只修改负载配置
+	edit(func(tx *gorm.DB) error {
+		lb.IpList = "127.0.0.1:81"
+		return lb.Save(c, tx)
+	})
+	if next := mustConfVersion(t); next == version {
+		t.Fatalf("expect version changed after load balance edit, got %s", next)
+	} else {
+		version = next
+	}
+
+	// 绕过后台直接软删除
+	if err := db.Table(info.TableName()).Where("id=?", info.ID).UpdateColumn("is_delete", 1).Error; err != nil {
+		t.Fatal(err)
+	}
+	if next := mustConfVersion(t); next == version {
+		t.Fatalf("expect version changed after soft delete, got %s", next)
+	}
+}
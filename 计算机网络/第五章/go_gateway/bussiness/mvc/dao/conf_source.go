@@ -0,0 +1,77 @@
+package dao
+
+import (
+	"github.com/gin-gonic/gin"
+	"go_gateway/bussiness/mvc/dto"
+	"go_gateway/common"
+	"net/http/httptest"
+)
+
+const (
+	ConfSourceDB   = "db"
+	ConfSourceFile = "file"
+)
+
+// ConfSource 服务、租户配置来源，ServiceManager、AppManager经由ConfSourceHandler加载配置
+type ConfSource interface {
+	// LoadServices 读取全部服务，返回顺序即HTTP接入规则的匹配顺序
+	LoadServices() ([]*ServiceDetail, error)
+	// LoadApps 读取全部租户
+	LoadApps() ([]*App, error)
+	// Version 配置版本号，版本变化时reload
+	Version() (string, error)
+}
+
+// ConfSourceHandler 当前配置来源，默认从数据库读取，单机模式下替换为FileConfSource
+var ConfSourceHandler ConfSource = &DBConfSource{}
+
+// DBConfSource 从mysql读取配置
+type DBConfSource struct{}
+
+func (s *DBConfSource) LoadServices() ([]*ServiceDetail, error) {
+	serviceInfo := &ServiceInfo{}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		return nil, err
+	}
+	params := &dto.ServiceListInput{PageNo: 1, PageSize: 99999}
+	list, _, err := serviceInfo.PageList(c, tx, params)
+	if err != nil {
+		return nil, err
+	}
+	serviceSlice := []*ServiceDetail{}
+	for _, listItem := range list {
+		tmpItem := listItem
+		serviceDetail, err := tmpItem.ServiceDetail(c, tx, &tmpItem)
+		if err != nil {
+			return nil, err
+		}
+		serviceSlice = append(serviceSlice, serviceDetail)
+	}
+	return serviceSlice, nil
+}
+
+func (s *DBConfSource) LoadApps() ([]*App, error) {
+	appInfo := &App{}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		return nil, err
+	}
+	params := &dto.APPListInput{PageNo: 1, PageSize: 99999}
+	list, _, err := appInfo.APPList(c, tx, params)
+	if err != nil {
+		return nil, err
+	}
+	appSlice := []*App{}
+	for _, listItem := range list {
+		tmpItem := listItem
+		appSlice = append(appSlice, &tmpItem)
+	}
+	return appSlice, nil
+}
+
+func (s *DBConfSource) Version() (string, error) {
+	return ConfVersion()
+}
@@ -0,0 +1,226 @@
+package dao
+
+import (
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"go_gateway/common"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// confSourceWatchDelay 文件变更后延迟reload，合并编辑器保存时产生的多次事件
+const confSourceWatchDelay = 500 * time.Millisecond
+
+// FileConfSource 从本地目录读取配置，无需mysql
+// 目录下每个yaml、toml、json文件都是与导入导出相同的ConfDocument格式，按文件名顺序合并，
+// 服务的匹配顺序即文件名及文件内的先后顺序
+type FileConfSource struct {
+	Dir string
+}
+
+func NewFileConfSource(dir string) *FileConfSource {
+	return &FileConfSource{Dir: dir}
+}
+
+func (s *FileConfSource) LoadServices() ([]*ServiceDetail, error) {
+	doc, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return doc.Services, nil
+}
+
+func (s *FileConfSource) LoadApps() ([]*App, error) {
+	doc, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return doc.Apps, nil
+}
+
+// Version 由配置文件的文件名、大小、修改时间组成
+func (s *FileConfSource) Version() (string, error) {
+	files, err := s.files()
+	if err != nil {
+		return "", err
+	}
+	versions := []string{}
+	for _, file := range files {
+		versions = append(versions, fmt.Sprintf("%s_%d_%d", file.Name, file.Size, file.ModTime.UnixNano()))
+	}
+	return strings.Join(versions, "|"), nil
+}
+
+// Watch 监听目录变更并reload，解析失败时保留当前配置
+func (s *FileConfSource) Watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(s.Dir); err != nil {
+		watcher.Close()
+		return err
+	}
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				fmt.Println(err)
+			}
+		}()
+		defer watcher.Close()
+		var timer *time.Timer
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if common.ConfDocFormatByExt(event.Name) == "" {
+					continue
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(confSourceWatchDelay, func() {
+					if err := ReloadConf(); err != nil {
+						log.Printf(" [ERROR] conf_reload err:%v\n", err)
+					}
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf(" [ERROR] conf_source_watch err:%v\n", err)
+			}
+		}
+	}()
+	return nil
+}
+
+type confSourceFile struct {
+	Name    string
+	Path    string
+	Format  string
+	Size    int64
+	ModTime time.Time
+}
+
+// files 目录下的配置文件，按文件名排序
+func (s *FileConfSource) files() ([]*confSourceFile, error) {
+	infos, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	files := []*confSourceFile{}
+	for _, info := range infos {
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		format := common.ConfDocFormatByExt(info.Name())
+		if format == "" {
+			continue
+		}
+		files = append(files, &confSourceFile{
+			Name:    info.Name(),
+			Path:    filepath.Join(s.Dir, info.Name()),
+			Format:  format,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+	return files, nil
+}
+
+// load 读取并合并全部配置文件，校验服务名、租户id是否重复以及端口、接入规则冲突
+func (s *FileConfSource) load() (*ConfDocument, error) {
+	files, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+	out := &ConfDocument{
+		Services: []*ServiceDetail{},
+		Apps:     []*App{},
+	}
+	serviceNames := map[string]string{}
+	appIDs := map[string]string{}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file.Path)
+		if err != nil {
+			return nil, err
+		}
+		doc := &ConfDocument{}
+		if err := common.DecodeConfDoc(data, file.Format, doc); err != nil {
+			return nil, errors.Wrap(err, file.Name)
+		}
+		for _, item := range doc.Services {
+			if err := checkFileService(item); err != nil {
+				return nil, errors.Wrap(err, file.Name)
+			}
+			name := item.Info.ServiceName
+			if other, ok := serviceNames[name]; ok {
+				return nil, fmt.Errorf("%s: 服务%s与%s中重复", file.Name, name, other)
+			}
+			serviceNames[name] = file.Name
+			out.Services = append(out.Services, StripServiceDetail(item))
+		}
+		for _, item := range doc.Apps {
+			if item.AppID == "" {
+				return nil, fmt.Errorf("%s: 租户缺少app_id", file.Name)
+			}
+			if other, ok := appIDs[item.AppID]; ok {
+				return nil, fmt.Errorf("%s: 租户%s与%s中重复", file.Name, item.AppID, other)
+			}
+			appIDs[item.AppID] = file.Name
+			app := StripApp(item)
+			if app.Secret == "" {
+				app.Secret = common.MD5(app.AppID)
+			}
+			out.Apps = append(out.Apps, app)
+		}
+	}
+	if err := checkServiceConflict(out.Services); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func checkFileService(item *ServiceDetail) error {
+	if item.Info == nil || item.AccessControl == nil || item.LoadBalance == nil {
+		return errors.New("服务缺少info、access_control或loadbalance")
+	}
+	name := item.Info.ServiceName
+	if name == "" {
+		return errors.New("服务缺少service_name")
+	}
+	switch item.Info.LoadType {
+	case common.LoadTypeHTTP:
+		if item.HTTPRule == nil || item.HTTPRule.Rule == "" {
+			return fmt.Errorf("服务%s缺少http_rule", name)
+		}
+	case common.LoadTypeTCP:
+		if item.TCPRule == nil || item.TCPRule.Port == 0 {
+			return fmt.Errorf("服务%s缺少tcp_rule", name)
+		}
+	case common.LoadTypeGRPC:
+		if item.GRPCRule == nil || item.GRPCRule.Port == 0 {
+			return fmt.Errorf("服务%s缺少grpc_rule", name)
+		}
+	default:
+		return fmt.Errorf("服务%s类型%d不支持", name, item.Info.LoadType)
+	}
+	if item.LoadBalance.IpList == "" {
+		return fmt.Errorf("服务%s缺少ip_list", name)
+	}
+	if len(strings.Split(item.LoadBalance.IpList, ",")) != len(strings.Split(item.LoadBalance.WeightList, ",")) {
+		return fmt.Errorf("服务%s: IP列表与权重列表数量不一致", name)
+	}
+	return nil
+}
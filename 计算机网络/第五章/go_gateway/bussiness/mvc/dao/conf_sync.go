@@ -0,0 +1,139 @@
+package dao
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"go_gateway/common"
+	"log"
+	"time"
+)
+
+const (
+	ConfChangeTypeService = "service"
+	ConfChangeTypeApp     = "app"
+
+	confSubscribeRetryInterval = 3 * time.Second
+	confSubscribePingInterval  = 30 * time.Second
+)
+
+// ConfChangeEvent 配置变更事件，Version全局递增，用于判断节点是否漏收事件
+type ConfChangeEvent struct {
+	Version int64  `json:"version"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+}
+
+// PublishConfChange 后台写入成功后发布变更事件，name为服务名或租户app_id
+func PublishConfChange(changeType string, name string) error {
+	version, err := redis.Int64(common.RedisDefaultConfDo("INCR", common.RedisConfVersionKey))
+	if err != nil {
+		return err
+	}
+	event := &ConfChangeEvent{
+		Version: version,
+		Type:    changeType,
+		Name:    name,
+	}
+	if _, err := common.RedisDefaultConfDo("PUBLISH", common.RedisConfChangeChannel, common.Obj2Json(event)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ReloadConfItems 按变更事件只重新加载受影响的服务或租户
+func ReloadConfItems(changeType string, names []string) error {
+	confReloadLocker.Lock()
+	defer confReloadLocker.Unlock()
+	switch changeType {
+	case ConfChangeTypeService:
+		_, err := ServiceManagerHandler.ReloadServices(names)
+		return err
+	case ConfChangeTypeApp:
+		_, err := AppManagerHandler.ReloadApps(names)
+		return err
+	}
+	return fmt.Errorf("unknown conf change type %s", changeType)
+}
+
+// SubscribeConfChange 订阅配置变更事件
+// 每次(重新)订阅成功后全量reload一次，补齐断线期间漏掉的变更；事件版本不连续时同样全量reload
+func SubscribeConfChange() {
+	go func() {
+		for {
+			if err := subscribeConfChange(); err != nil {
+				log.Printf(" [ERROR] conf_subscribe err:%v\n", err)
+			}
+			time.Sleep(confSubscribeRetryInterval)
+		}
+	}()
+}
+
+func subscribeConfChange() (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("panic:%v", e)
+		}
+	}()
+	c, err := common.RedisConnFactory("default")
+	if err != nil {
+		return err
+	}
+	psc := redis.PubSubConn{Conn: c}
+	defer psc.Close()
+	if err := psc.Subscribe(common.RedisConfChangeChannel); err != nil {
+		return err
+	}
+
+	var lastVersion int64
+	for {
+		switch msg := psc.ReceiveWithTimeout(confSubscribePingInterval + 5*time.Second).(type) {
+		case redis.Subscription:
+			if msg.Kind != "subscribe" {
+				continue
+			}
+			// 先读版本再reload，期间到达的事件会再次触发加载，不会遗漏
+			lastVersion, err = redis.Int64(common.RedisDefaultConfDo("GET", common.RedisConfVersionKey))
+			if err != nil && err != redis.ErrNil {
+				return err
+			}
+			if err := ReloadConf(); err != nil {
+				return err
+			}
+			log.Printf(" [INFO] conf_subscribe %s version:%d\n", common.RedisConfChangeChannel, lastVersion)
+			go pingPubSub(psc)
+		case redis.Message:
+			event := &ConfChangeEvent{}
+			if err := json.Unmarshal(msg.Data, event); err != nil {
+				log.Printf(" [ERROR] conf_subscribe event:%s err:%v\n", msg.Data, err)
+				continue
+			}
+			if event.Version <= lastVersion {
+				continue
+			}
+			if event.Version != lastVersion+1 {
+				log.Printf(" [INFO] conf_subscribe version gap %d->%d, full reload\n", lastVersion, event.Version)
+				if err := ReloadConf(); err != nil {
+					return err
+				}
+			} else if err := ReloadConfItems(event.Type, []string{event.Name}); err != nil {
+				return err
+			}
+			lastVersion = event.Version
+		case redis.Pong:
+		case error:
+			return msg
+		}
+	}
+}
+
+// pingPubSub 定时ping保持订阅连接，连接断开后ReceiveWithTimeout会超时返回错误
+func pingPubSub(psc redis.PubSubConn) {
+	ticker := time.NewTicker(confSubscribePingInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := psc.Ping(""); err != nil {
+			return
+		}
+	}
+}
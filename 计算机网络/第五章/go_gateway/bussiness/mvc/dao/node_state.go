@@ -0,0 +1,94 @@
+package dao
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"go_gateway/common"
+	"go_gateway/gateway/loadbalance"
+	"log"
+	"os"
+	"time"
+)
+
+// DefaultNodeStateReportInterval 代理上报节点状态的间隔，单位秒
+const DefaultNodeStateReportInterval = 5
+
+// NodeStateReport 单个代理实例上报的节点状态
+type NodeStateReport struct {
+	Instance string                   `json:"instance"`
+	Unix     int64                    `json:"unix"`
+	Nodes    []*loadbalance.NodeState `json:"nodes"`
+}
+
+// NodeStateReportInterval 节点状态上报间隔，读取base.cluster.node_state_interval
+func NodeStateReportInterval() time.Duration {
+	interval := common.GetIntConf("base.cluster.node_state_interval")
+	if interval <= 0 {
+		interval = DefaultNodeStateReportInterval
+	}
+	return time.Duration(interval) * time.Second
+}
+
+// nodeStateInstance 代理实例标识，同一台机器可能运行多个实例
+func nodeStateInstance() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s_%d", hostname, os.Getpid())
+}
+
+// ReportNodeStates 代理定时将各服务的节点状态及进行中的连接数写入redis，后台据此展示排空进度
+// 每个服务一个hash，field为代理实例，过期的实例由读取方忽略
+func ReportNodeStates(interval time.Duration) {
+	instance := nodeStateInstance()
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				fmt.Println(err)
+			}
+		}()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			<-ticker.C
+			now := time.Now().Unix()
+			states := LoadBalancerHandler.NodeStates()
+			if len(states) == 0 {
+				continue
+			}
+			if err := common.RedisConfPipeline(func(c redis.Conn) {
+				for serviceName, nodes := range states {
+					key := common.RedisNodeStatePrefix + serviceName
+					report := &NodeStateReport{Instance: instance, Unix: now, Nodes: nodes}
+					c.Send("HSET", key, instance, common.Obj2Json(report))
+					c.Send("EXPIRE", key, 86400)
+				}
+			}); err != nil {
+				log.Printf(" [ERROR] report_node_states err:%v\n", err)
+			}
+		}
+	}()
+}
+
+// GetNodeStateReports 读取各代理实例上报的服务节点状态，忽略超过3个上报周期未更新的实例
+func GetNodeStateReports(serviceName string, interval time.Duration) ([]*NodeStateReport, error) {
+	values, err := redis.StringMap(common.RedisDefaultConfDo("HGETALL", common.RedisNodeStatePrefix+serviceName))
+	if err != nil {
+		return nil, err
+	}
+	expire := time.Now().Add(-3 * interval).Unix()
+	reports := []*NodeStateReport{}
+	staleFields := []interface{}{common.RedisNodeStatePrefix + serviceName}
+	for field, value := range values {
+		report := &NodeStateReport{}
+		if err := json.Unmarshal([]byte(value), report); err != nil || report.Unix < expire {
+			staleFields = append(staleFields, field)
+			continue
+		}
+		reports = append(reports, report)
+	}
+	// 清理已停止的实例
+	if len(staleFields) > 1 {
+		common.RedisDefaultConfDo("HDEL", staleFields...)
+	}
+	return reports, nil
+}
@@ -3,9 +3,10 @@ package dao
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
-	"go_gateway/bussiness/mvc/dto"
+	"go_gateway/bussiness/gorm"
 	"go_gateway/common"
 	"net/http/httptest"
+	"sort"
 	"strings"
 	"sync"
 )
@@ -19,18 +20,75 @@ type ServiceDetail struct {
 	AccessControl *AccessControl `json:"access_control" description:"access_control"`
 }
 
+// HasRule 是否包含与服务类型对应的接入规则，回滚、导入的快照可能缺少
+func (s *ServiceDetail) HasRule() bool {
+	if s.Info == nil {
+		return false
+	}
+	switch s.Info.LoadType {
+	case common.LoadTypeHTTP:
+		return s.HTTPRule != nil
+	case common.LoadTypeTCP:
+		return s.TCPRule != nil
+	case common.LoadTypeGRPC:
+		return s.GRPCRule != nil
+	}
+	return false
+}
+
+// Save 保存服务详情，基本信息、接入规则、权限控制、负载配置需在同一事务中写入
+func (s *ServiceDetail) Save(c *gin.Context, tx *gorm.DB) error {
+	if err := s.Info.Save(c, tx); err != nil {
+		return err
+	}
+	switch s.Info.LoadType {
+	case common.LoadTypeHTTP:
+		s.HTTPRule.ServiceID = s.Info.ID
+		if err := s.HTTPRule.Save(c, tx); err != nil {
+			return err
+		}
+	case common.LoadTypeTCP:
+		s.TCPRule.ServiceID = s.Info.ID
+		if err := s.TCPRule.Save(c, tx); err != nil {
+			return err
+		}
+	case common.LoadTypeGRPC:
+		s.GRPCRule.ServiceID = s.Info.ID
+		if err := s.GRPCRule.Save(c, tx); err != nil {
+			return err
+		}
+	}
+	s.AccessControl.ServiceID = s.Info.ID
+	if err := s.AccessControl.Save(c, tx); err != nil {
+		return err
+	}
+	s.LoadBalance.ServiceID = s.Info.ID
+	if err := s.LoadBalance.Save(c, tx); err != nil {
+		return err
+	}
+	return nil
+}
+
 var ServiceManagerHandler *ServiceManager
 
 func init() {
 	ServiceManagerHandler = NewServiceManager()
 }
 
+// ConfObserver 配置变更监听者，reload后接收发生变更的统计key列表
+type ConfObserver interface {
+	Update(changedKeys []string)
+}
+
 type ServiceManager struct {
 	ServiceMap   map[string]*ServiceDetail
 	ServiceSlice []*ServiceDetail
 	Locker       sync.RWMutex
 	init         sync.Once
 	err          error
+	// 服务配置签名，reload时据此判断服务是否变更
+	signature map[string]string
+	observers []ConfObserver
 }
 
 func NewServiceManager() *ServiceManager {
@@ -39,12 +97,26 @@ func NewServiceManager() *ServiceManager {
 		ServiceSlice: []*ServiceDetail{},
 		Locker:       sync.RWMutex{},
 		init:         sync.Once{},
+		signature:    map[string]string{},
 	}
 }
 
+func (s *ServiceManager) Attach(o ConfObserver) {
+	s.Locker.Lock()
+	defer s.Locker.Unlock()
+	s.observers = append(s.observers, o)
+}
+
+// GetServiceSlice 获取当前服务快照，reload只替换快照不修改旧快照，正在处理的请求不受影响
+func (s *ServiceManager) GetServiceSlice() []*ServiceDetail {
+	s.Locker.RLock()
+	defer s.Locker.RUnlock()
+	return s.ServiceSlice
+}
+
 func (s *ServiceManager) GetTcpServiceList() []*ServiceDetail {
 	list := []*ServiceDetail{}
-	for _, serverItem := range s.ServiceSlice {
+	for _, serverItem := range s.GetServiceSlice() {
 		tempItem := serverItem
 		if tempItem.Info.LoadType == common.LoadTypeTCP {
 			list = append(list, tempItem)
@@ -55,7 +127,7 @@ func (s *ServiceManager) GetTcpServiceList() []*ServiceDetail {
 
 func (s *ServiceManager) GetGrpcServiceList() []*ServiceDetail {
 	list := []*ServiceDetail{}
-	for _, serverItem := range s.ServiceSlice {
+	for _, serverItem := range s.GetServiceSlice() {
 		tempItem := serverItem
 		if tempItem.Info.LoadType == common.LoadTypeGRPC {
 			list = append(list, tempItem)
@@ -64,6 +136,13 @@ func (s *ServiceManager) GetGrpcServiceList() []*ServiceDetail {
 	return list
 }
 
+// GetServiceDetail 当前快照中的服务详情，服务不存在时返回nil
+func (s *ServiceManager) GetServiceDetail(serviceName string) *ServiceDetail {
+	s.Locker.RLock()
+	defer s.Locker.RUnlock()
+	return s.ServiceMap[serviceName]
+}
+
 func (s *ServiceManager) HTTPAccessMode(c *gin.Context) (*ServiceDetail, error) {
 	//1、前缀匹配 /abc ==> serviceSlice.rule
 	//2、域名匹配 www.test.com ==> serviceSlice.rule
@@ -72,7 +151,7 @@ func (s *ServiceManager) HTTPAccessMode(c *gin.Context) (*ServiceDetail, error)
 	host := c.Request.Host
 	host = host[0:strings.Index(host, ":")]
 	path := c.Request.URL.Path
-	for _, serviceItem := range s.ServiceSlice {
+	for _, serviceItem := range s.GetServiceSlice() {
 		if serviceItem.Info.LoadType != common.LoadTypeHTTP {
 			continue
 		}
@@ -92,31 +171,131 @@ func (s *ServiceManager) HTTPAccessMode(c *gin.Context) (*ServiceDetail, error)
 
 func (s *ServiceManager) LoadOnce() error {
 	s.init.Do(func() {
-		serviceInfo := &ServiceInfo{}
-		c, _ := gin.CreateTestContext(httptest.NewRecorder())
-		tx, err := common.GetGormPool("default")
-		if err != nil {
-			s.err = err
-			return
-		}
-		params := &dto.ServiceListInput{PageNo: 1, PageSize: 99999}
-		list, _, err := serviceInfo.PageList(c, tx, params)
+		serviceMap, serviceSlice, signature, err := s.load()
 		if err != nil {
 			s.err = err
 			return
 		}
 		s.Locker.Lock()
 		defer s.Locker.Unlock()
-		for _, listItem := range list {
-			tmpItem := listItem
-			serviceDetail, err := tmpItem.ServiceDetail(c, tx, &tmpItem)
-			if err != nil {
-				s.err = err
-				return
-			}
-			s.ServiceMap[listItem.ServiceName] = serviceDetail
-			s.ServiceSlice = append(s.ServiceSlice, serviceDetail)
-		}
+		s.ServiceMap = serviceMap
+		s.ServiceSlice = serviceSlice
+		s.signature = signature
 	})
 	return s.err
 }
+
+// Reload 重新读取全部服务并整体替换快照，返回发生变更(新增、修改、删除)的服务名
+// 变更服务缓存的负载均衡器、连接池一并失效，限流器等由监听者自行失效
+func (s *ServiceManager) Reload() ([]string, error) {
+	serviceMap, serviceSlice, signature, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return s.apply(serviceMap, serviceSlice, signature), nil
+}
+
+// ReloadServices 只重新读取指定的服务，其余服务沿用当前快照
+// 仅数据库来源支持按服务名读取，其他来源退化为全量reload
+func (s *ServiceManager) ReloadServices(serviceNames []string) ([]string, error) {
+	if _, ok := ConfSourceHandler.(*DBConfSource); !ok {
+		return s.Reload()
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	tx, err := common.GetGormPool("default")
+	if err != nil {
+		return nil, err
+	}
+	s.Locker.RLock()
+	serviceMap := map[string]*ServiceDetail{}
+	for name, item := range s.ServiceMap {
+		serviceMap[name] = item
+	}
+	signature := map[string]string{}
+	for name, sign := range s.signature {
+		signature[name] = sign
+	}
+	s.Locker.RUnlock()
+
+	for _, name := range serviceNames {
+		delete(serviceMap, name)
+		delete(signature, name)
+		search := &ServiceInfo{ServiceName: name}
+		serviceInfo, err := search.Find(c, tx.Where("is_delete=0"), search)
+		if err == gorm.ErrRecordNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		serviceDetail, err := serviceInfo.ServiceDetail(c, tx, serviceInfo)
+		if err != nil {
+			return nil, err
+		}
+		serviceMap[name] = serviceDetail
+		signature[name] = common.Obj2Json(serviceDetail)
+	}
+
+	// 与全量加载保持一致，按id倒序匹配
+	serviceSlice := []*ServiceDetail{}
+	for _, item := range serviceMap {
+		serviceSlice = append(serviceSlice, item)
+	}
+	sort.Slice(serviceSlice, func(i, j int) bool {
+		return serviceSlice[i].Info.ID > serviceSlice[j].Info.ID
+	})
+	return s.apply(serviceMap, serviceSlice, signature), nil
+}
+
+// apply 替换快照，失效变更服务的缓存并通知监听者，返回变更的服务名
+func (s *ServiceManager) apply(serviceMap map[string]*ServiceDetail, serviceSlice []*ServiceDetail, signature map[string]string) []string {
+	s.Locker.Lock()
+	changed := []string{}
+	for name, sign := range signature {
+		if oldSign, ok := s.signature[name]; !ok || oldSign != sign {
+			changed = append(changed, name)
+		}
+	}
+	for name := range s.signature {
+		if _, ok := signature[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	s.ServiceMap = serviceMap
+	s.ServiceSlice = serviceSlice
+	s.signature = signature
+	observers := s.observers
+	s.Locker.Unlock()
+
+	if len(changed) == 0 {
+		return changed
+	}
+	changedKeys := []string{}
+	for _, name := range changed {
+		if _, ok := serviceMap[name]; ok {
+			LoadBalancerHandler.Remove(name)
+		} else {
+			LoadBalancerHandler.Delete(name)
+		}
+		TransportorHandler.Remove(name)
+		changedKeys = append(changedKeys, common.FlowServicePrefix+name)
+	}
+	for _, obs := range observers {
+		obs.Update(changedKeys)
+	}
+	return changed
+}
+
+func (s *ServiceManager) load() (map[string]*ServiceDetail, []*ServiceDetail, map[string]string, error) {
+	serviceSlice, err := ConfSourceHandler.LoadServices()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	serviceMap := map[string]*ServiceDetail{}
+	signature := map[string]string{}
+	for _, serviceDetail := range serviceSlice {
+		serviceMap[serviceDetail.Info.ServiceName] = serviceDetail
+		signature[serviceDetail.Info.ServiceName] = common.Obj2Json(serviceDetail)
+	}
+	return serviceMap, serviceSlice, signature, nil
+}
@@ -16,6 +16,8 @@ type HttpRule struct {
 	NeedStripUri   int    `json:"need_strip_uri" gorm:"column:need_strip_uri" description:"启用strip_uri 1=启用"`
 	UrlRewrite     string `json:"url_rewrite" gorm:"column:url_rewrite" description:"url重写功能，每行一个	"`
 	HeaderTransfor string `json:"header_transfor" gorm:"column:header_transfor" description:"header转换支持增加(add)、删除(del)、修改(edit) 格式: add headname headvalue	"`
+
+	WebsocketIdleTimeout int `json:"websocket_idle_timeout" gorm:"column:websocket_idle_timeout" description:"websocket空闲超时, 单位s, 0=默认60"`
 }
 
 func (t *HttpRule) TableName() string {
@@ -51,3 +53,17 @@ func (t *HttpRule) ListByServiceID(c *gin.Context, tx *gorm.DB, serviceID int64)
 	}
 	return list, count, nil
 }
+
+// ListByRule 查询使用相同接入前缀或域名的未删除服务规则
+func (t *HttpRule) ListByRule(c *gin.Context, tx *gorm.DB, ruleType int, rule string) ([]HttpRule, error) {
+	var list []HttpRule
+	infoTable := (&ServiceInfo{}).TableName()
+	query := tx.SetCtx(util.GetGinTraceContext(c))
+	query = query.Table(t.TableName()).Select(t.TableName() + ".*")
+	query = query.Joins("join " + infoTable + " on " + infoTable + ".id=" + t.TableName() + ".service_id")
+	query = query.Where(t.TableName()+".rule_type=? and "+t.TableName()+".rule=? and "+infoTable+".is_delete=0", ruleType, rule)
+	if err := query.Find(&list).Error; err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	return list, nil
+}
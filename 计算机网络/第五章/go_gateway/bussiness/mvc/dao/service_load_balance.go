@@ -3,32 +3,71 @@ package dao
 import (
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
 	"go_gateway/bussiness/gorm"
+	"go_gateway/bussiness/mvc/dto"
 	"go_gateway/bussiness/util"
 	"go_gateway/common"
 	"go_gateway/gateway/loadbalance"
+	"log"
 	"net"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type LoadBalance struct {
 	ID            int64  `json:"id" gorm:"primary_key"`
 	ServiceID     int64  `json:"service_id" gorm:"column:service_id" description:"服务id	"`
-	CheckMethod   int    `json:"check_method" gorm:"column:check_method" description:"检查方法 tcpchk=检测端口是否握手成功	"`
+	CheckMethod   int    `json:"check_method" gorm:"column:check_method" description:"检查方法 0=tcpchk,检测端口是否握手成功 1=httpchk 2=grpc.health.v1"`
 	CheckTimeout  int    `json:"check_timeout" gorm:"column:check_timeout" description:"check超时时间	"`
 	CheckInterval int    `json:"check_interval" gorm:"column:check_interval" description:"检查间隔, 单位s		"`
-	RoundType     int    `json:"round_type" gorm:"column:round_type" description:"轮询方式 round/weight_round/random/ip_hash"`
+	RoundType     int    `json:"round_type" gorm:"column:round_type" description:"轮询方式 0=random 1=round 2=weight_round 3=ip_hash 4=least_conn 5=p2c 6=maglev"`
 	IpList        string `json:"ip_list" gorm:"column:ip_list" description:"ip列表"`
 	WeightList    string `json:"weight_list" gorm:"column:weight_list" description:"权重列表"`
 	ForbidList    string `json:"forbid_list" gorm:"column:forbid_list" description:"禁用ip列表"`
+	DrainList     string `json:"drain_list" gorm:"column:drain_list" description:"排空中的ip列表, 不再分配新请求"`
 
 	UpstreamConnectTimeout int `json:"upstream_connect_timeout" gorm:"column:upstream_connect_timeout" description:"下游建立连接超时, 单位s"`
 	UpstreamHeaderTimeout  int `json:"upstream_header_timeout" gorm:"column:upstream_header_timeout" description:"下游获取header超时, 单位s	"`
 	UpstreamIdleTimeout    int `json:"upstream_idle_timeout" gorm:"column:upstream_idle_timeout" description:"下游链接最大空闲时间, 单位s	"`
 	UpstreamMaxIdle        int `json:"upstream_max_idle" gorm:"column:upstream_max_idle" description:"下游最大空闲链接数"`
+
+	CheckPath    string `json:"check_path" gorm:"column:check_path" description:"httpchk检查路径, grpc检查时为服务名"`
+	CheckStatus  string `json:"check_status" gorm:"column:check_status" description:"httpchk期望状态码, 如200,204或200-299"`
+	CheckBody    string `json:"check_body" gorm:"column:check_body" description:"httpchk响应体需包含的内容"`
+	CheckFailNum int    `json:"check_fail_num" gorm:"column:check_fail_num" description:"连续失败次数达到该值时摘除"`
+	CheckSuccNum int    `json:"check_succ_num" gorm:"column:check_succ_num" description:"连续成功次数达到该值时恢复"`
+
+	HashKeyType       int    `json:"hash_key_type" gorm:"column:hash_key_type" description:"哈希key 0=默认 1=客户端ip 2=header 3=cookie 4=query 5=jwt_claim"`
+	HashKeyName       string `json:"hash_key_name" gorm:"column:hash_key_name" description:"header、cookie、query参数或jwt claim名称"`
+	HashReplicas      int    `json:"hash_replicas" gorm:"column:hash_replicas" description:"一致性哈希虚拟节点数, 0=默认10"`
+	HashBalanceFactor int    `json:"hash_balance_factor" gorm:"column:hash_balance_factor" description:"有界负载系数, 百分比, 如125, 0=不限制"`
+
+	DiscoveryType     int    `json:"discovery_type" gorm:"column:discovery_type" description:"服务发现 0=ip_list 1=dns 2=file 3=http"`
+	DiscoveryTarget   string `json:"discovery_target" gorm:"column:discovery_target" description:"dns为host:port或_service._proto.name, file为文件路径, http为注册中心地址"`
+	DiscoveryInterval int    `json:"discovery_interval" gorm:"column:discovery_interval" description:"http轮询间隔, dns重新解析间隔上限, 单位s"`
+
+	SlowStart int `json:"slow_start" gorm:"column:slow_start" description:"加权轮询慢启动时长, 单位s, 0=不启用"`
+
+	StickySession    int    `json:"sticky_session" gorm:"column:sticky_session" description:"http会话保持 0=关闭 1=开启"`
+	StickyCookieName string `json:"sticky_cookie_name" gorm:"column:sticky_cookie_name" description:"会话保持cookie名称, 默认gw_sticky"`
+	StickyTTL        int    `json:"sticky_ttl" gorm:"column:sticky_ttl" description:"会话保持cookie有效期, 单位s, 0=浏览器会话"`
+
+	RetryAttempts      int    `json:"retry_attempts" gorm:"column:retry_attempts" description:"http最多尝试次数, 含首次请求, 0或1=不重试"`
+	RetryOn            string `json:"retry_on" gorm:"column:retry_on" description:"重试条件 connect_error,timeout或状态码, 默认connect_error,timeout,502,503"`
+	RetryNonIdempotent int    `json:"retry_non_idempotent" gorm:"column:retry_non_idempotent" description:"非幂等方法是否重试 0=否 1=是"`
+	RetryTryTimeout    int    `json:"retry_try_timeout" gorm:"column:retry_try_timeout" description:"单次尝试等待响应头超时, 单位s, 0=不限制"`
+
+	CircuitErrorRate  int `json:"circuit_error_rate" gorm:"column:circuit_error_rate" description:"熔断失败率, 百分比, 0=不按失败率熔断"`
+	CircuitSlowRate   int `json:"circuit_slow_rate" gorm:"column:circuit_slow_rate" description:"熔断慢请求占比, 百分比, 0=不按耗时熔断"`
+	CircuitSlowTime   int `json:"circuit_slow_time" gorm:"column:circuit_slow_time" description:"慢请求耗时阈值, 单位ms"`
+	CircuitMinRequest int `json:"circuit_min_request" gorm:"column:circuit_min_request" description:"统计窗口内最少请求数, 0=默认20"`
+	CircuitOpenTime   int `json:"circuit_open_time" gorm:"column:circuit_open_time" description:"熔断持续时间, 单位s, 0=默认30"`
+
+	CaptureBody int `json:"capture_body" gorm:"column:capture_body" description:"记录到请求日志的响应体前缀长度, 单位byte, 0=不记录"`
 }
 
 func (t *LoadBalance) TableName() string {
@@ -48,6 +87,146 @@ func (t *LoadBalance) Save(c *gin.Context, tx *gorm.DB) error {
 	return nil
 }
 
+// ApplySetting 写入新增、更新或导入服务时提交的负载均衡设置
+func (t *LoadBalance) ApplySetting(setting dto.LoadBalanceSettingInput) {
+	t.CheckMethod = setting.CheckMethod
+	t.CheckTimeout = setting.CheckTimeout
+	t.CheckInterval = setting.CheckInterval
+	t.CheckPath = setting.CheckPath
+	t.CheckStatus = setting.CheckStatus
+	t.CheckBody = setting.CheckBody
+	t.CheckFailNum = setting.CheckFailNum
+	t.CheckSuccNum = setting.CheckSuccNum
+	t.HashKeyType = setting.HashKeyType
+	t.HashKeyName = setting.HashKeyName
+	t.HashReplicas = setting.HashReplicas
+	t.HashBalanceFactor = setting.HashBalanceFactor
+	t.ForbidList = setting.ForbidList
+	t.DrainList = setting.DrainList
+	t.DiscoveryType = setting.DiscoveryType
+	t.DiscoveryTarget = setting.DiscoveryTarget
+	t.DiscoveryInterval = setting.DiscoveryInterval
+	t.SlowStart = setting.SlowStart
+	t.StickySession = setting.StickySession
+	t.StickyCookieName = setting.StickyCookieName
+	t.StickyTTL = setting.StickyTTL
+	t.RetryAttempts = setting.RetryAttempts
+	t.RetryOn = setting.RetryOn
+	t.RetryNonIdempotent = setting.RetryNonIdempotent
+	t.RetryTryTimeout = setting.RetryTryTimeout
+	t.CircuitErrorRate = setting.CircuitErrorRate
+	t.CircuitSlowRate = setting.CircuitSlowRate
+	t.CircuitSlowTime = setting.CircuitSlowTime
+	t.CircuitMinRequest = setting.CircuitMinRequest
+	t.CircuitOpenTime = setting.CircuitOpenTime
+	t.CaptureBody = setting.CaptureBody
+}
+
+// SettingInput 当前的负载均衡设置，导入配置时按dto规则校验
+func (t *LoadBalance) SettingInput() dto.LoadBalanceSettingInput {
+	return dto.LoadBalanceSettingInput{
+		CheckMethod:        t.CheckMethod,
+		CheckTimeout:       t.CheckTimeout,
+		CheckInterval:      t.CheckInterval,
+		CheckPath:          t.CheckPath,
+		CheckStatus:        t.CheckStatus,
+		CheckBody:          t.CheckBody,
+		CheckFailNum:       t.CheckFailNum,
+		CheckSuccNum:       t.CheckSuccNum,
+		HashKeyType:        t.HashKeyType,
+		HashKeyName:        t.HashKeyName,
+		HashReplicas:       t.HashReplicas,
+		HashBalanceFactor:  t.HashBalanceFactor,
+		ForbidList:         t.ForbidList,
+		DrainList:          t.DrainList,
+		DiscoveryType:      t.DiscoveryType,
+		DiscoveryTarget:    t.DiscoveryTarget,
+		DiscoveryInterval:  t.DiscoveryInterval,
+		SlowStart:          t.SlowStart,
+		StickySession:      t.StickySession,
+		StickyCookieName:   t.StickyCookieName,
+		StickyTTL:          t.StickyTTL,
+		RetryAttempts:      t.RetryAttempts,
+		RetryOn:            t.RetryOn,
+		RetryNonIdempotent: t.RetryNonIdempotent,
+		RetryTryTimeout:    t.RetryTryTimeout,
+		CircuitErrorRate:   t.CircuitErrorRate,
+		CircuitSlowRate:    t.CircuitSlowRate,
+		CircuitSlowTime:    t.CircuitSlowTime,
+		CircuitMinRequest:  t.CircuitMinRequest,
+		CircuitOpenTime:    t.CircuitOpenTime,
+		CaptureBody:        t.CaptureBody,
+	}
+}
+
+// GetCheckSetting 服务的主动探活设置，未配置的项使用默认值
+func (t *LoadBalance) GetCheckSetting() loadbalance.CheckSetting {
+	return loadbalance.CheckSetting{
+		Method:   t.CheckMethod,
+		Timeout:  t.CheckTimeout,
+		Interval: t.CheckInterval,
+		Path:     t.CheckPath,
+		Status:   t.CheckStatus,
+		Body:     t.CheckBody,
+		FailNum:  t.CheckFailNum,
+		SuccNum:  t.CheckSuccNum,
+	}
+}
+
+// GetLoadBalanceOption 服务的负载均衡设置，如哈希key、虚拟节点数、慢启动时长
+func (t *LoadBalance) GetLoadBalanceOption() loadbalance.LoadBalanceOption {
+	return loadbalance.LoadBalanceOption{
+		HashKeyType:       t.HashKeyType,
+		HashKeyName:       t.HashKeyName,
+		HashReplicas:      t.HashReplicas,
+		HashBalanceFactor: t.HashBalanceFactor,
+		SlowStart:         t.SlowStart,
+	}
+}
+
+// GetDiscovery 服务的节点来源，使用ip_list时返回nil
+func (t *LoadBalance) GetDiscovery() (loadbalance.Discovery, error) {
+	return loadbalance.NewDiscovery(t.DiscoveryType, t.DiscoveryTarget, t.DiscoveryInterval)
+}
+
+var stickySecretWarn sync.Once
+
+// GetStickySetting HTTP服务的会话保持设置，未开启时返回nil
+// 签名密钥读取环境变量GATEWAY_STICKY_SECRET或base.cluster.sticky_secret，都未配置时不开启会话保持
+func (t *LoadBalance) GetStickySetting() *loadbalance.StickySetting {
+	if t.StickySession != 1 {
+		return nil
+	}
+	secret := common.GetSecretConf("base.cluster.sticky_secret", "GATEWAY_STICKY_SECRET")
+	if secret == "" {
+		stickySecretWarn.Do(func() {
+			log.Printf(" [ERROR] sticky session disabled: set base.cluster.sticky_secret or GATEWAY_STICKY_SECRET\n")
+		})
+		return nil
+	}
+	return &loadbalance.StickySetting{
+		CookieName: t.StickyCookieName,
+		TTL:        t.StickyTTL,
+		Secret:     []byte(secret),
+	}
+}
+
+// GetRetryPolicy HTTP服务的重试策略，未开启时返回nil
+func (t *LoadBalance) GetRetryPolicy() (*loadbalance.RetryPolicy, error) {
+	return loadbalance.NewRetryPolicy(t.RetryAttempts, t.RetryOn, t.RetryNonIdempotent == 1, t.RetryTryTimeout)
+}
+
+// GetCircuitSetting 服务的熔断设置，失败率与慢请求占比都为0时不启用
+func (t *LoadBalance) GetCircuitSetting() loadbalance.CircuitSetting {
+	return loadbalance.CircuitSetting{
+		ErrorPercent: t.CircuitErrorRate,
+		SlowPercent:  t.CircuitSlowRate,
+		SlowTime:     time.Duration(t.CircuitSlowTime) * time.Millisecond,
+		MinRequests:  t.CircuitMinRequest,
+		OpenTime:     time.Duration(t.CircuitOpenTime) * time.Second,
+	}
+}
+
 func (t *LoadBalance) GetIPListByModel() []string {
 	return strings.Split(t.IpList, ",")
 }
@@ -56,37 +235,137 @@ func (t *LoadBalance) GetWeightListByModel() []string {
 	return strings.Split(t.WeightList, ",")
 }
 
+func (t *LoadBalance) GetForbidListByModel() []string {
+	return splitList(t.ForbidList)
+}
+
+func (t *LoadBalance) GetDrainListByModel() []string {
+	return splitList(t.DrainList)
+}
+
+func splitList(str string) []string {
+	list := []string{}
+	for _, item := range strings.Split(str, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 var LoadBalancerHandler *LoadBalancer
 
+// LoadBalancer 服务名到负载均衡器的映射为只读快照，请求路径无锁读取，
+// 创建与移除时在Locker保护下复制快照后整体替换
 type LoadBalancer struct {
-	LoadBanlanceMap   map[string]*LoadBalancerItem
-	LoadBanlanceSlice []*LoadBalancerItem
-	Locker            sync.RWMutex
+	LoadBanlanceMap atomic.Value // map[string]*LoadBalancerItem
+	Locker          sync.Mutex
+	// 各服务节点的连接数统计，负载均衡器重建时保留，由Locker保护
+	ConnStatMap map[string]*loadbalance.NodeConnStat
+	// 创建中的负载均衡器，同一服务只由一个请求创建，其余请求等待结果，由Locker保护
+	pending map[string]*loadBalancerCall
+}
+
+// loadBalancerCall 一次负载均衡器的创建，创建过程不持有Locker，服务发现首次拉取较慢时不影响其他服务
+type loadBalancerCall struct {
+	done chan struct{}
+	lb   loadbalance.LoadBalance
+	err  error
+	// 创建所用的服务详情，等待的请求只复用相同快照的创建结果
+	service *ServiceDetail
+	// 创建期间服务被移除，创建结果只返回给等待的请求，不写入快照
+	removed bool
 }
 
 type LoadBalancerItem struct {
 	LoadBanlance loadbalance.LoadBalance
 	ServiceName  string
+	// 创建所用的服务详情，与当前快照不一致时不再返回
+	Service *ServiceDetail
+	// 负载均衡器观察的配置主体，失效时需停止其探活协程
+	LoadBanlanceConf *loadbalance.LoadBalanceCheckConf
 }
 
 func NewLoadBalancer() *LoadBalancer {
-	return &LoadBalancer{
-		LoadBanlanceMap:   map[string]*LoadBalancerItem{},
-		LoadBanlanceSlice: []*LoadBalancerItem{},
-		Locker:            sync.RWMutex{},
+	lbr := &LoadBalancer{
+		ConnStatMap: map[string]*loadbalance.NodeConnStat{},
+		pending:     map[string]*loadBalancerCall{},
 	}
+	lbr.LoadBanlanceMap.Store(map[string]*LoadBalancerItem{})
+	return lbr
 }
 
 func init() {
 	LoadBalancerHandler = NewLoadBalancer()
 }
 
+func (lbr *LoadBalancer) items() map[string]*LoadBalancerItem {
+	items, _ := lbr.LoadBanlanceMap.Load().(map[string]*LoadBalancerItem)
+	return items
+}
+
 func (lbr *LoadBalancer) GetLoadBalancer(service *ServiceDetail) (loadbalance.LoadBalance, error) {
-	for _, lbrItem := range lbr.LoadBanlanceSlice {
-		if lbrItem.ServiceName == service.Info.ServiceName {
-			return lbrItem.LoadBanlance, nil
+	name := service.Info.ServiceName
+	if lbrItem, ok := lbr.items()[name]; ok && lbrItem.Service == service {
+		return lbrItem.LoadBanlance, nil
+	}
+	// 请求可能匹配的是reload前的旧快照，统一按当前快照获取，避免旧配置在Remove之后被重新缓存
+	service = ServiceManagerHandler.GetServiceDetail(name)
+	if service == nil {
+		return nil, errors.New("service not found")
+	}
+	// 加锁后再次检查，避免并发请求重复创建而泄漏探活协程
+	lbr.Locker.Lock()
+	if lbrItem, ok := lbr.items()[name]; ok && lbrItem.Service == service {
+		lbr.Locker.Unlock()
+		return lbrItem.LoadBanlance, nil
+	}
+	if call, ok := lbr.pending[name]; ok && call.service == service {
+		lbr.Locker.Unlock()
+		<-call.done
+		return call.lb, call.err
+	} else if ok {
+		call.removed = true
+	}
+	call := &loadBalancerCall{done: make(chan struct{}), service: service}
+	lbr.pending[name] = call
+	stat := lbr.connStat(name)
+	lbr.Locker.Unlock()
+
+	lb, mConf, err := newLoadBalancer(service, stat)
+	call.lb, call.err = lb, err
+
+	lbr.Locker.Lock()
+	if lbr.pending[name] == call {
+		delete(lbr.pending, name)
+	}
+	// 创建期间快照已替换时，结果只返回给本次及等待的请求
+	if err == nil && (call.removed || ServiceManagerHandler.GetServiceDetail(name) != service) {
+		mConf.CloseWatch()
+	} else if err == nil {
+		items := map[string]*LoadBalancerItem{}
+		for itemName, item := range lbr.items() {
+			if itemName == name {
+				item.LoadBanlanceConf.CloseWatch()
+				continue
+			}
+			items[itemName] = item
+		}
+		items[name] = &LoadBalancerItem{
+			LoadBanlance:     lb,
+			ServiceName:      name,
+			Service:          service,
+			LoadBanlanceConf: mConf,
 		}
+		lbr.LoadBanlanceMap.Store(items)
 	}
+	lbr.Locker.Unlock()
+	close(call.done)
+	return lb, err
+}
+
+// newLoadBalancer 按服务配置创建负载均衡器及其配置主体，服务发现的首次拉取可能耗时数秒
+func newLoadBalancer(service *ServiceDetail, stat *loadbalance.NodeConnStat) (loadbalance.LoadBalance, *loadbalance.LoadBalanceCheckConf, error) {
 	schema := "http://"
 	if service.HTTPRule.NeedHttps == 1 {
 		schema = "https://"
@@ -101,91 +380,186 @@ func (lbr *LoadBalancer) GetLoadBalancer(service *ServiceDetail) (loadbalance.Lo
 		ipConf[ipItem] = weightList[ipIndex]
 	}
 	// 主动探测
-	mConf, err := loadbalance.NewLoadBalanceCheckConf(fmt.Sprintf("%s%s", schema, "%s"), ipConf)
+	mConf, err := loadbalance.NewLoadBalanceCheckConfWithSetting(fmt.Sprintf("%s%s", schema, "%s"), ipConf, service.LoadBalance.GetCheckSetting())
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	lb := loadbalance.LoadBanlanceFactorWithConf(loadbalance.LbType(service.LoadBalance.RoundType), mConf)
+	// 禁用及排空中的节点不分配新请求
+	mConf.SetForbidList(service.LoadBalance.GetForbidListByModel())
+	mConf.SetDrainList(service.LoadBalance.GetDrainListByModel())
+	mConf.SetConnStat(stat)
+	if setting := service.LoadBalance.GetCircuitSetting(); setting.Enabled() {
+		mConf.SetCircuitBreakers(loadbalance.NewCircuitBreakers(setting))
+	}
+	// 服务发现获取到节点后替换ip_list，获取失败时继续使用ip_list
+	discovery, err := service.LoadBalance.GetDiscovery()
+	if err != nil {
+		log.Printf(" [ERROR] service %s discovery err:%v\n", service.Info.ServiceName, err)
+	} else if discovery != nil {
+		if err := mConf.WatchDiscovery(discovery); err != nil {
+			log.Printf(" [ERROR] service %s discovery err:%v\n", service.Info.ServiceName, err)
+		}
+	}
+	lb := loadbalance.LoadBanlanceFactorWithOption(loadbalance.LbType(service.LoadBalance.RoundType), mConf, service.LoadBalance.GetLoadBalanceOption())
+
+	return lb, mConf, nil
+}
 
-	// save to map and slice
-	lbItem := &LoadBalancerItem{
-		LoadBanlance: lb,
-		ServiceName:  service.Info.ServiceName,
+// connStat 调用方需持有Locker
+func (lbr *LoadBalancer) connStat(serviceName string) *loadbalance.NodeConnStat {
+	stat, ok := lbr.ConnStatMap[serviceName]
+	if !ok {
+		stat = loadbalance.NewNodeConnStat()
+		lbr.ConnStatMap[serviceName] = stat
 	}
-	lbr.LoadBanlanceSlice = append(lbr.LoadBanlanceSlice, lbItem)
+	return stat
+}
 
+// NodeStates 已创建负载均衡器的服务的节点状态，key为服务名
+func (lbr *LoadBalancer) NodeStates() map[string][]*loadbalance.NodeState {
+	states := map[string][]*loadbalance.NodeState{}
+	for name, lbrItem := range lbr.items() {
+		states[name] = lbrItem.LoadBanlanceConf.NodeStates()
+	}
+	return states
+}
+
+// Remove 服务变更后移除缓存的负载均衡器，下次请求时按新配置重建
+func (lbr *LoadBalancer) Remove(serviceName string) {
 	lbr.Locker.Lock()
 	defer lbr.Locker.Unlock()
-	lbr.LoadBanlanceMap[service.Info.ServiceName] = lbItem
-	return lb, nil
+	if call, ok := lbr.pending[serviceName]; ok {
+		call.removed = true
+	}
+	items := map[string]*LoadBalancerItem{}
+	for name, lbrItem := range lbr.items() {
+		if name == serviceName {
+			lbrItem.LoadBanlanceConf.CloseWatch()
+			continue
+		}
+		items[name] = lbrItem
+	}
+	lbr.LoadBanlanceMap.Store(items)
+}
+
+// Delete 服务已删除时移除负载均衡器及其节点连接数统计
+func (lbr *LoadBalancer) Delete(serviceName string) {
+	lbr.Remove(serviceName)
+	lbr.Locker.Lock()
+	delete(lbr.ConnStatMap, serviceName)
+	lbr.Locker.Unlock()
 }
 
 var TransportorHandler *Transportor
 
+// Transportor 服务名到连接池的映射为只读快照，与LoadBalancer相同
 type Transportor struct {
-	TransportMap   map[string]*TransportItem
-	TransportSlice []*TransportItem
-	Locker         sync.RWMutex
+	TransportMap atomic.Value // map[string]*TransportItem
+	Locker       sync.Mutex
 }
 
 type TransportItem struct {
 	Trans       *http.Transport
 	ServiceName string
+	// 创建所用的服务详情，与当前快照不一致时不再返回
+	Service *ServiceDetail
 }
 
 func NewTransportor() *Transportor {
-	return &Transportor{
-		TransportMap:   map[string]*TransportItem{},
-		TransportSlice: []*TransportItem{},
-		Locker:         sync.RWMutex{},
-	}
+	t := &Transportor{}
+	t.TransportMap.Store(map[string]*TransportItem{})
+	return t
 }
 
 func init() {
 	TransportorHandler = NewTransportor()
 }
 
+func (t *Transportor) items() map[string]*TransportItem {
+	items, _ := t.TransportMap.Load().(map[string]*TransportItem)
+	return items
+}
+
 func (t *Transportor) GetTrans(service *ServiceDetail) (*http.Transport, error) {
-	for _, transItem := range t.TransportSlice {
-		if transItem.ServiceName == service.Info.ServiceName {
-			return transItem.Trans, nil
-		}
+	if transItem, ok := t.items()[service.Info.ServiceName]; ok && transItem.Service == service {
+		return transItem.Trans, nil
+	}
+	// 与LoadBalancer相同，按当前快照获取
+	service = ServiceManagerHandler.GetServiceDetail(service.Info.ServiceName)
+	if service == nil {
+		return nil, errors.New("service not found")
+	}
+	t.Locker.Lock()
+	defer t.Locker.Unlock()
+	if transItem, ok := t.items()[service.Info.ServiceName]; ok && transItem.Service == service {
+		return transItem.Trans, nil
 	}
 
-	if service.LoadBalance.UpstreamConnectTimeout == 0 {
-		service.LoadBalance.UpstreamConnectTimeout = 30
+	// 服务配置为多个请求共享，默认值只在本地计算
+	connectTimeout := service.LoadBalance.UpstreamConnectTimeout
+	if connectTimeout == 0 {
+		connectTimeout = 30
 	}
-	if service.LoadBalance.UpstreamMaxIdle == 0 {
-		service.LoadBalance.UpstreamMaxIdle = 100
+	maxIdle := service.LoadBalance.UpstreamMaxIdle
+	if maxIdle == 0 {
+		maxIdle = 100
 	}
-	if service.LoadBalance.UpstreamIdleTimeout == 0 {
-		service.LoadBalance.UpstreamIdleTimeout = 90
+	idleTimeout := service.LoadBalance.UpstreamIdleTimeout
+	if idleTimeout == 0 {
+		idleTimeout = 90
 	}
-	if service.LoadBalance.UpstreamHeaderTimeout == 0 {
-		service.LoadBalance.UpstreamHeaderTimeout = 30
+	headerTimeout := service.LoadBalance.UpstreamHeaderTimeout
+	if headerTimeout == 0 {
+		headerTimeout = 30
 	}
 	trans := &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
 		DialContext: (&net.Dialer{
-			Timeout:   time.Duration(service.LoadBalance.UpstreamConnectTimeout) * time.Second,
+			Timeout:   time.Duration(connectTimeout) * time.Second,
 			KeepAlive: 30 * time.Second,
 			DualStack: true,
 		}).DialContext,
 		ForceAttemptHTTP2:     true,
-		MaxIdleConns:          service.LoadBalance.UpstreamMaxIdle,
-		IdleConnTimeout:       time.Duration(service.LoadBalance.UpstreamIdleTimeout) * time.Second,
+		MaxIdleConns:          maxIdle,
+		IdleConnTimeout:       time.Duration(idleTimeout) * time.Second,
 		TLSHandshakeTimeout:   10 * time.Second,
-		ResponseHeaderTimeout: time.Duration(service.LoadBalance.UpstreamHeaderTimeout) * time.Second,
+		ResponseHeaderTimeout: time.Duration(headerTimeout) * time.Second,
 	}
 
-	//save to map and slice
+	// 快照已替换时不缓存
+	if ServiceManagerHandler.GetServiceDetail(service.Info.ServiceName) != service {
+		return trans, nil
+	}
+	//save to map
 	transItem := &TransportItem{
 		Trans:       trans,
 		ServiceName: service.Info.ServiceName,
+		Service:     service,
 	}
-	t.TransportSlice = append(t.TransportSlice, transItem)
+	items := map[string]*TransportItem{}
+	for name, item := range t.items() {
+		if name == service.Info.ServiceName {
+			item.Trans.CloseIdleConnections()
+			continue
+		}
+		items[name] = item
+	}
+	items[service.Info.ServiceName] = transItem
+	t.TransportMap.Store(items)
+	return trans, nil
+}
+
+// Remove 服务变更后移除缓存的连接池，只关闭空闲连接，正在进行的请求继续使用旧连接完成
+func (t *Transportor) Remove(serviceName string) {
 	t.Locker.Lock()
 	defer t.Locker.Unlock()
-	t.TransportMap[service.Info.ServiceName] = transItem
-	return trans, nil
+	items := map[string]*TransportItem{}
+	for name, transItem := range t.items() {
+		if name == serviceName {
+			transItem.Trans.CloseIdleConnections()
+			continue
+		}
+		items[name] = transItem
+	}
+	t.TransportMap.Store(items)
 }
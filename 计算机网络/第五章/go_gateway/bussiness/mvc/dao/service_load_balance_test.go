@@ -0,0 +1,232 @@
+package dao
+
+import (
+	"fmt"
+	"go_gateway/gateway/loadbalance"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// benchServices 直接写入快照的服务，避免创建探活协程
+func benchServices(services int) ([]*ServiceDetail, *LoadBalancer, *Transportor) {
+	lbr := NewLoadBalancer()
+	trans := NewTransportor()
+	details := make([]*ServiceDetail, services)
+	lbItems := map[string]*LoadBalancerItem{}
+	transItems := map[string]*TransportItem{}
+	for i := range details {
+		name := fmt.Sprintf("service_%d", i)
+		details[i] = &ServiceDetail{Info: &ServiceInfo{ServiceName: name}}
+		lbItems[name] = &LoadBalancerItem{ServiceName: name, Service: details[i], LoadBanlance: &loadbalance.RoundRobinBalance{}}
+		transItems[name] = &TransportItem{ServiceName: name, Service: details[i], Trans: &http.Transport{}}
+	}
+	lbr.LoadBanlanceMap.Store(lbItems)
+	trans.TransportMap.Store(transItems)
+	return details, lbr, trans
+}
+
+// 单次获取的开销不随服务数量增长
+func BenchmarkGetLoadBalancer(b *testing.B) {
+	for _, services := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("services_%d", services), func(b *testing.B) {
+			details, lbr, _ := benchServices(services)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					lbr.GetLoadBalancer(details[i%services])
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkGetTrans(b *testing.B) {
+	for _, services := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("services_%d", services), func(b *testing.B) {
+			details, _, trans := benchServices(services)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					trans.GetTrans(details[i%services])
+					i++
+				}
+			})
+		})
+	}
+}
+
+// testCurrentServices 将服务写入当前快照，测试结束后恢复
+func testCurrentServices(t *testing.T, services ...*ServiceDetail) {
+	serviceMap := map[string]*ServiceDetail{}
+	for _, service := range services {
+		serviceMap[service.Info.ServiceName] = service
+	}
+	ServiceManagerHandler.Locker.Lock()
+	old := ServiceManagerHandler.ServiceMap
+	ServiceManagerHandler.ServiceMap = serviceMap
+	ServiceManagerHandler.Locker.Unlock()
+	t.Cleanup(func() {
+		ServiceManagerHandler.Locker.Lock()
+		ServiceManagerHandler.ServiceMap = old
+		ServiceManagerHandler.Locker.Unlock()
+	})
+}
+
+func TestTransportorRemove(t *testing.T) {
+	trans := NewTransportor()
+	service := &ServiceDetail{Info: &ServiceInfo{ServiceName: "service_a"}, LoadBalance: &LoadBalance{}}
+	testCurrentServices(t, service)
+	first, _ := trans.GetTrans(service)
+	if again, _ := trans.GetTrans(service); again != first {
+		t.Fatal("expect transport reused")
+	}
+	// 默认值不回写到共享的服务配置
+	if service.LoadBalance.UpstreamConnectTimeout != 0 || first.MaxIdleConns != 100 {
+		t.Fatal("unexpected transport defaults")
+	}
+	trans.Remove("service_a")
+	if again, _ := trans.GetTrans(service); again == first {
+		t.Fatal("expect transport rebuilt")
+	}
+}
+
+func TestGetStickySettingSecret(t *testing.T) {
+	lb := &LoadBalance{StickySession: 1}
+	t.Setenv("GATEWAY_STICKY_SECRET", "")
+	// 未配置密钥时不开启，不使用jwt签名密钥
+	if setting := lb.GetStickySetting(); setting != nil {
+		t.Fatalf("expect sticky disabled without secret, got %+v", setting)
+	}
+	t.Setenv("GATEWAY_STICKY_SECRET", "sticky_secret")
+	if setting := lb.GetStickySetting(); setting == nil || string(setting.Secret) != "sticky_secret" {
+		t.Fatalf("expect secret from env, got %+v", setting)
+	}
+}
+
+func testLoadBalanceService(name string, lb *LoadBalance) *ServiceDetail {
+	if lb.IpList == "" {
+		lb.IpList, lb.WeightList = "127.0.0.1:80", "50"
+	}
+	return &ServiceDetail{Info: &ServiceInfo{ServiceName: name}, HTTPRule: &HttpRule{}, LoadBalance: lb}
+}
+
+// 服务发现首次拉取阻塞时，不影响其他服务创建负载均衡器
+func TestGetLoadBalancerSlowDiscovery(t *testing.T) {
+	release := make(chan struct{})
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`[{"addr":"127.0.0.1:81","weight":50}]`))
+	}))
+	defer registry.Close()
+	defer close(release)
+
+	lbr := NewLoadBalancer()
+	slow := testLoadBalanceService("slow_service", &LoadBalance{DiscoveryType: loadbalance.DiscoveryHTTP, DiscoveryTarget: registry.URL})
+	fastService := testLoadBalanceService("fast_service", &LoadBalance{})
+	testCurrentServices(t, slow, fastService)
+	done := make(chan loadbalance.LoadBalance, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			lb, _ := lbr.GetLoadBalancer(slow)
+			done <- lb
+		}()
+	}
+	for {
+		lbr.Locker.Lock()
+		_, ok := lbr.pending["slow_service"]
+		lbr.Locker.Unlock()
+		if ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	fast := make(chan error, 1)
+	go func() {
+		_, err := lbr.GetLoadBalancer(fastService)
+		fast <- err
+	}()
+	select {
+	case err := <-fast:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect other service not blocked by slow discovery")
+	}
+	lbr.Remove("fast_service")
+
+	// 同一服务只创建一次，等待的请求得到同一个负载均衡器
+	release <- struct{}{}
+	if first, second := <-done, <-done; first == nil || first != second {
+		t.Fatalf("expect shared load balancer, got %v %v", first, second)
+	}
+	lbr.Remove("slow_service")
+}
+
+func TestLoadBalancerDelete(t *testing.T) {
+	lbr := NewLoadBalancer()
+	service := testLoadBalanceService("deleted_service", &LoadBalance{})
+	testCurrentServices(t, service)
+	if _, err := lbr.GetLoadBalancer(service); err != nil {
+		t.Fatal(err)
+	}
+	// 配置变更时保留连接数统计，服务删除时一并清理
+	lbr.Remove("deleted_service")
+	if _, ok := lbr.ConnStatMap["deleted_service"]; !ok {
+		t.Fatal("expect conn stat kept after remove")
+	}
+	lbr.Delete("deleted_service")
+	if _, ok := lbr.ConnStatMap["deleted_service"]; ok {
+		t.Fatal("expect conn stat deleted with service")
+	}
+}
+
+// 匹配到旧快照的请求在Remove之后到达，不能把旧配置重新缓存
+func TestGetLoadBalancerStaleService(t *testing.T) {
+	lbr := NewLoadBalancer()
+	trans := NewTransportor()
+	old := testLoadBalanceService("stale_service", &LoadBalance{})
+	testCurrentServices(t, old)
+	oldLb, _ := lbr.GetLoadBalancer(old)
+	oldTrans, _ := trans.GetTrans(old)
+
+	current := testLoadBalanceService("stale_service", &LoadBalance{IpList: "127.0.0.1:81", WeightList: "50"})
+	testCurrentServices(t, current)
+	lbr.Remove("stale_service")
+	trans.Remove("stale_service")
+	defer lbr.Remove("stale_service")
+
+	lb, err := lbr.GetLoadBalancer(old)
+	if err != nil || lb == oldLb {
+		t.Fatalf("expect load balancer rebuilt, got %v %v", lb, err)
+	}
+	if item := lbr.items()["stale_service"]; item == nil || item.Service != current {
+		t.Fatal("expect load balancer cached for current service")
+	}
+	if again, _ := lbr.GetLoadBalancer(current); again != lb {
+		t.Fatal("expect current service reuse load balancer")
+	}
+	if addr, _ := lb.Get(""); addr != "http://127.0.0.1:81" {
+		t.Fatalf("expect current ip_list, got %s", addr)
+	}
+
+	tr, _ := trans.GetTrans(old)
+	if tr == oldTrans {
+		t.Fatal("expect transport rebuilt")
+	}
+	if item := trans.items()["stale_service"]; item == nil || item.Service != current {
+		t.Fatal("expect transport cached for current service")
+	}
+
+	// 服务已删除
+	testCurrentServices(t)
+	if _, err := lbr.GetLoadBalancer(old); err == nil {
+		t.Fatal("expect error for deleted service")
+	}
+}
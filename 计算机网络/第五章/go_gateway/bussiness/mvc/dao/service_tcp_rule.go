@@ -45,3 +45,17 @@ func (t *TcpRule) ListByServiceID(c *gin.Context, tx *gorm.DB, serviceID int64)
 	}
 	return list, count, nil
 }
+
+// ListByPort 查询占用指定端口的未删除服务规则
+func (t *TcpRule) ListByPort(c *gin.Context, tx *gorm.DB, port int) ([]TcpRule, error) {
+	var list []TcpRule
+	infoTable := (&ServiceInfo{}).TableName()
+	query := tx.SetCtx(util.GetGinTraceContext(c))
+	query = query.Table(t.TableName()).Select(t.TableName() + ".*")
+	query = query.Joins("join " + infoTable + " on " + infoTable + ".id=" + t.TableName() + ".service_id")
+	query = query.Where(t.TableName()+".port=? and "+infoTable+".is_delete=0", port)
+	if err := query.Find(&list).Error; err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	return list, nil
+}
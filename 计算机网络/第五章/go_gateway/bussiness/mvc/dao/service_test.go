@@ -0,0 +1,25 @@
+package dao
+
+import (
+	"go_gateway/common"
+	"testing"
+)
+
+func TestServiceDetailHasRule(t *testing.T) {
+	cases := []struct {
+		detail *ServiceDetail
+		ok     bool
+	}{
+		{&ServiceDetail{Info: &ServiceInfo{LoadType: common.LoadTypeHTTP}, HTTPRule: &HttpRule{}}, true},
+		{&ServiceDetail{Info: &ServiceInfo{LoadType: common.LoadTypeTCP}, TCPRule: &TcpRule{}}, true},
+		// 快照缺少对应类型的规则
+		{&ServiceDetail{Info: &ServiceInfo{LoadType: common.LoadTypeGRPC}, TCPRule: &TcpRule{}}, false},
+		{&ServiceDetail{Info: &ServiceInfo{LoadType: common.LoadTypeHTTP}}, false},
+		{&ServiceDetail{}, false},
+	}
+	for i, item := range cases {
+		if ok := item.detail.HasRule(); ok != item.ok {
+			t.Fatalf("case %d expect %v, got %v", i, item.ok, ok)
+		}
+	}
+}
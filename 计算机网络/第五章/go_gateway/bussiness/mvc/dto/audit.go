@@ -0,0 +1,44 @@
+package dto
+
+import (
+	"github.com/gin-gonic/gin"
+	"go_gateway/bussiness/util"
+	"time"
+)
+
+type ConfHistoryInput struct {
+	ID       int64 `json:"id" form:"id" comment:"服务或租户ID" example:"56" validate:"required"`                  //服务或租户ID
+	PageNo   int   `json:"page_no" form:"page_no" comment:"页数" example:"1" validate:"required,min=1"`        //页数
+	PageSize int   `json:"page_size" form:"page_size" comment:"每页条数" example:"20" validate:"required,min=1"` //每页条数
+}
+
+func (param *ConfHistoryInput) BindValidParam(c *gin.Context) error {
+	return util.DefaultGetValidParams(c, param)
+}
+
+type ConfRollbackInput struct {
+	ID      int64 `json:"id" form:"id" comment:"服务或租户ID" example:"56" validate:"required"`               //服务或租户ID
+	Version int   `json:"version" form:"version" comment:"回滚到的版本" example:"1" validate:"required,min=1"` //回滚到的版本
+}
+
+func (param *ConfRollbackInput) BindValidParam(c *gin.Context) error {
+	return util.DefaultGetValidParams(c, param)
+}
+
+type ConfHistoryItemOutput struct {
+	ID         int64     `json:"id"`
+	TargetType string    `json:"target_type"`
+	TargetID   int64     `json:"target_id"`
+	TargetName string    `json:"target_name"`
+	Version    int       `json:"version"`
+	Action     string    `json:"action"`
+	BeforeData string    `json:"before_data"`
+	AfterData  string    `json:"after_data"`
+	AdminUser  string    `json:"admin_user"`
+	CreatedAt  time.Time `json:"create_at"`
+}
+
+type ConfHistoryOutput struct {
+	Total int64                   `json:"total" form:"total" comment:"总数"`
+	List  []ConfHistoryItemOutput `json:"list" form:"list" comment:"变更记录"`
+}
@@ -0,0 +1,38 @@
+package dto
+
+import (
+	"github.com/gin-gonic/gin"
+	"go_gateway/bussiness/util"
+)
+
+type ConfExportInput struct {
+	Format   string `json:"format" form:"format" comment:"文档格式" example:"yaml" validate:"required,oneof=yaml toml json"`                //文档格式
+	Scope    string `json:"scope" form:"scope" comment:"导出范围 all/service/app" example:"all" validate:"omitempty,oneof=all service app"` //导出范围
+	Services string `json:"services" form:"services" comment:"服务名，逗号间隔，为空导出全部" example:""`                                              //服务名
+	Apps     string `json:"apps" form:"apps" comment:"租户id，逗号间隔，为空导出全部" example:""`                                                     //租户id
+}
+
+func (param *ConfExportInput) BindValidParam(c *gin.Context) error {
+	return util.DefaultGetValidParams(c, param)
+}
+
+type ConfExportOutput struct {
+	Format  string `json:"format" form:"format" comment:"文档格式"`
+	Content string `json:"content" form:"content" comment:"文档内容"`
+}
+
+type ConfImportInput struct {
+	Format  string `json:"format" form:"format" comment:"文档格式" example:"yaml" validate:"required,oneof=yaml toml json"` //文档格式
+	Content string `json:"content" form:"content" comment:"文档内容" example:"" validate:"required"`                        //文档内容
+	DryRun  int    `json:"dry_run" form:"dry_run" comment:"仅输出执行计划" example:"1" validate:"max=1,min=0"`                 //仅输出执行计划
+	Prune   int    `json:"prune" form:"prune" comment:"删除文档中没有的服务、租户" example:"0" validate:"max=1,min=0"`               //删除文档中没有的服务、租户
+}
+
+func (param *ConfImportInput) BindValidParam(c *gin.Context) error {
+	return util.DefaultGetValidParams(c, param)
+}
+
+type ConfImportOutput struct {
+	DryRun int      `json:"dry_run" form:"dry_run" comment:"是否仅输出执行计划"`
+	Plan   []string `json:"plan" form:"plan" comment:"执行计划"`
+}
@@ -22,19 +22,23 @@ type ServiceUpdateHTTPInput struct {
 	UrlRewrite     string `json:"url_rewrite" form:"url_rewrite" comment:"url重写功能" example:"" validate:"valid_url_rewrite"`                //url重写功能
 	HeaderTransfor string `json:"header_transfor" form:"header_transfor" comment:"header转换" example:"" validate:"valid_header_transfor"`   //header转换
 
+	WebsocketIdleTimeout int `json:"websocket_idle_timeout" form:"websocket_idle_timeout" comment:"websocket空闲超时, 单位s, 0=默认60" example:"" validate:"min=0"` //websocket空闲超时, 单位s, 0=默认60
+
 	OpenAuth          int    `json:"open_auth" form:"open_auth" comment:"是否开启权限" example:"" validate:"max=1,min=0"`                  //关键词
 	BlackList         string `json:"black_list" form:"black_list" comment:"黑名单ip" example:"" validate:""`                            //黑名单ip
 	WhiteList         string `json:"white_list" form:"white_list" comment:"白名单ip" example:"" validate:""`                            //白名单ip
 	ClientipFlowLimit int    `json:"clientip_flow_limit" form:"clientip_flow_limit" comment:"客户端ip限流	" example:"" validate:"min=0"` //客户端ip限流
 	ServiceFlowLimit  int    `json:"service_flow_limit" form:"service_flow_limit" comment:"服务端限流" example:"" validate:"min=0"`       //服务端限流
 
-	RoundType              int    `json:"round_type" form:"round_type" comment:"轮询方式" example:"" validate:"max=3,min=0"`                                //轮询方式
+	RoundType              int    `json:"round_type" form:"round_type" comment:"轮询方式" example:"" validate:"valid_round_type"`                           //轮询方式
 	IpList                 string `json:"ip_list" form:"ip_list" comment:"ip列表" example:"127.0.0.1:80" validate:"required,valid_ipportlist"`            //ip列表
 	WeightList             string `json:"weight_list" form:"weight_list" comment:"权重列表" example:"50" validate:"required,valid_weightlist"`             //权重列表
 	UpstreamConnectTimeout int    `json:"upstream_connect_timeout" form:"upstream_connect_timeout" comment:"建立连接超时, 单位s" example:"" validate:"min=0"`   //建立连接超时, 单位s
 	UpstreamHeaderTimeout  int    `json:"upstream_header_timeout" form:"upstream_header_timeout" comment:"获取header超时, 单位s" example:"" validate:"min=0"` //获取header超时, 单位s
 	UpstreamIdleTimeout    int    `json:"upstream_idle_timeout" form:"upstream_idle_timeout" comment:"链接最大空闲时间, 单位s" example:"" validate:"min=0"`       //链接最大空闲时间, 单位s
 	UpstreamMaxIdle        int    `json:"upstream_max_idle" form:"upstream_max_idle" comment:"最大空闲链接数" example:"" validate:"min=0"`                     //最大空闲链接数
+
+	LoadBalanceSettingInput
 }
 
 func (param *ServiceAddHTTPInput) BindValidParam(c *gin.Context) error {
@@ -53,19 +57,23 @@ type ServiceAddHTTPInput struct {
 	UrlRewrite     string `json:"url_rewrite" form:"url_rewrite" comment:"url重写功能" example:"" validate:"valid_url_rewrite"`              //url重写功能
 	HeaderTransfor string `json:"header_transfor" form:"header_transfor" comment:"header转换" example:"" validate:"valid_header_transfor"` //header转换
 
+	WebsocketIdleTimeout int `json:"websocket_idle_timeout" form:"websocket_idle_timeout" comment:"websocket空闲超时, 单位s, 0=默认60" example:"" validate:"min=0"` //websocket空闲超时, 单位s, 0=默认60
+
 	OpenAuth          int    `json:"open_auth" form:"open_auth" comment:"是否开启权限" example:"" validate:"max=1,min=0"`                  //关键词
 	BlackList         string `json:"black_list" form:"black_list" comment:"黑名单ip" example:"" validate:""`                            //黑名单ip
 	WhiteList         string `json:"white_list" form:"white_list" comment:"白名单ip" example:"" validate:""`                            //白名单ip
 	ClientipFlowLimit int    `json:"clientip_flow_limit" form:"clientip_flow_limit" comment:"客户端ip限流	" example:"" validate:"min=0"` //客户端ip限流
 	ServiceFlowLimit  int    `json:"service_flow_limit" form:"service_flow_limit" comment:"服务端限流" example:"" validate:"min=0"`       //服务端限流
 
-	RoundType              int    `json:"round_type" form:"round_type" comment:"轮询方式" example:"" validate:"max=3,min=0"`                                //轮询方式
+	RoundType              int    `json:"round_type" form:"round_type" comment:"轮询方式" example:"" validate:"valid_round_type"`                           //轮询方式
 	IpList                 string `json:"ip_list" form:"ip_list" comment:"ip列表" example:"" validate:"required,valid_ipportlist"`                        //ip列表
 	WeightList             string `json:"weight_list" form:"weight_list" comment:"权重列表" example:"" validate:"required,valid_weightlist"`               //权重列表
 	UpstreamConnectTimeout int    `json:"upstream_connect_timeout" form:"upstream_connect_timeout" comment:"建立连接超时, 单位s" example:"" validate:"min=0"`   //建立连接超时, 单位s
 	UpstreamHeaderTimeout  int    `json:"upstream_header_timeout" form:"upstream_header_timeout" comment:"获取header超时, 单位s" example:"" validate:"min=0"` //获取header超时, 单位s
 	UpstreamIdleTimeout    int    `json:"upstream_idle_timeout" form:"upstream_idle_timeout" comment:"链接最大空闲时间, 单位s" example:"" validate:"min=0"`       //链接最大空闲时间, 单位s
 	UpstreamMaxIdle        int    `json:"upstream_max_idle" form:"upstream_max_idle" comment:"最大空闲链接数" example:"" validate:"min=0"`                     //最大空闲链接数
+
+	LoadBalanceSettingInput
 }
 
 type ServiceDeleteInput struct {
@@ -76,6 +84,14 @@ func (param *ServiceDeleteInput) BindValidParam(c *gin.Context) error {
 	return util.DefaultGetValidParams(c, param)
 }
 
+type ServiceDetailInput struct {
+	ID int64 `json:"id" form:"id" comment:"服务ID" example:"56" validate:"required"` //服务ID
+}
+
+func (param *ServiceDetailInput) BindValidParam(c *gin.Context) error {
+	return util.DefaultGetValidParams(c, param)
+}
+
 type ServiceListInput struct {
 	Info     string `json:"info" form:"info" comment:"关键词" example:"" validate:""`                      //关键词
 	PageNo   int    `json:"page_no" form:"page_no" comment:"页数" example:"1" validate:"required"`        //页数
@@ -107,6 +123,20 @@ type ServiceStatOutput struct {
 	Yesterday []int64 `json:"yesterday" form:"yesterday" comment:"昨日流量" example:"" validate:""` //列表
 }
 
+type ServiceNodeItemOutput struct {
+	Addr        string `json:"addr" form:"addr"`                 //节点地址
+	Weight      string `json:"weight" form:"weight"`             //权重
+	State       string `json:"state" form:"state"`               //配置状态 active forbidden draining
+	Health      string `json:"health" form:"health"`             //代理探活状态 active unhealthy ejected
+	ActiveConns int64  `json:"active_conns" form:"active_conns"` //全部代理进行中的请求、连接数
+	Drained     bool   `json:"drained" form:"drained"`           //排空中且连接数已归零，可以安全下线
+}
+
+type ServiceNodesOutput struct {
+	Instances int                     `json:"instances" form:"instances"` //上报状态的代理数
+	List      []ServiceNodeItemOutput `json:"list" form:"list"`           //节点列表
+}
+
 type ServiceAddGrpcInput struct {
 	ServiceName       string `json:"service_name" form:"service_name" comment:"服务名称" validate:"required,valid_service_name"`
 	ServiceDesc       string `json:"service_desc" form:"service_desc" comment:"服务描述" validate:"required"`
@@ -118,10 +148,11 @@ type ServiceAddGrpcInput struct {
 	WhiteHostName     string `json:"white_host_name" form:"white_host_name" comment:"白名单主机，以逗号间隔" validate:"valid_iplist"`
 	ClientIPFlowLimit int    `json:"clientip_flow_limit" form:"clientip_flow_limit" comment:"客户端IP限流" validate:""`
 	ServiceFlowLimit  int    `json:"service_flow_limit" form:"service_flow_limit" comment:"服务端限流" validate:""`
-	RoundType         int    `json:"round_type" form:"round_type" comment:"轮询策略" validate:""`
+	RoundType         int    `json:"round_type" form:"round_type" comment:"轮询策略" validate:"valid_round_type"`
 	IpList            string `json:"ip_list" form:"ip_list" comment:"IP列表" validate:"required,valid_ipportlist"`
 	WeightList        string `json:"weight_list" form:"weight_list" comment:"权重列表" validate:"required,valid_weightlist"`
-	ForbidList        string `json:"forbid_list" form:"forbid_list" comment:"禁用IP列表" validate:"valid_iplist"`
+
+	LoadBalanceSettingInput
 }
 
 func (params *ServiceAddGrpcInput) GetValidParams(c *gin.Context) error {
@@ -140,10 +171,11 @@ type ServiceUpdateGrpcInput struct {
 	WhiteHostName     string `json:"white_host_name" form:"white_host_name" comment:"白名单主机，以逗号间隔" validate:"valid_iplist"`
 	ClientIPFlowLimit int    `json:"clientip_flow_limit" form:"clientip_flow_limit" comment:"客户端IP限流" validate:""`
 	ServiceFlowLimit  int    `json:"service_flow_limit" form:"service_flow_limit" comment:"服务端限流" validate:""`
-	RoundType         int    `json:"round_type" form:"round_type" comment:"轮询策略" validate:""`
+	RoundType         int    `json:"round_type" form:"round_type" comment:"轮询策略" validate:"valid_round_type"`
 	IpList            string `json:"ip_list" form:"ip_list" comment:"IP列表" validate:"required,valid_ipportlist"`
 	WeightList        string `json:"weight_list" form:"weight_list" comment:"权重列表" validate:"required,valid_weightlist"`
-	ForbidList        string `json:"forbid_list" form:"forbid_list" comment:"禁用IP列表" validate:"valid_iplist"`
+
+	LoadBalanceSettingInput
 }
 
 func (params *ServiceUpdateGrpcInput) GetValidParams(c *gin.Context) error {
@@ -151,21 +183,21 @@ func (params *ServiceUpdateGrpcInput) GetValidParams(c *gin.Context) error {
 }
 
 type ServiceAddTcpInput struct {
-	ServiceName    string `json:"service_name" form:"service_name" comment:"服务名称" validate:"required,valid_service_name"`
-	ServiceDesc    string `json:"service_desc" form:"service_desc" comment:"服务描述" validate:"required"`
-	Port           int    `json:"port" form:"port" comment:"端口，需要设置8001-8999范围内" validate:"required,min=8001,max=8999"`
-	HeaderTransfor string `json:"header_transfor" form:"header_transfor" comment:"header头转换" validate:"
-"`
+	ServiceName       string `json:"service_name" form:"service_name" comment:"服务名称" validate:"required,valid_service_name"`
+	ServiceDesc       string `json:"service_desc" form:"service_desc" comment:"服务描述" validate:"required"`
+	Port              int    `json:"port" form:"port" comment:"端口，需要设置8001-8999范围内" validate:"required,min=8001,max=8999"`
+	HeaderTransfor    string `json:"header_transfor" form:"header_transfor" comment:"header头转换" validate:""`
 	OpenAuth          int    `json:"open_auth" form:"open_auth" comment:"是否开启权限验证" validate:""`
 	BlackList         string `json:"black_list" form:"black_list" comment:"黑名单IP，以逗号间隔，白名单优先级高于黑名单" validate:"valid_iplist"`
 	WhiteList         string `json:"white_list" form:"white_list" comment:"白名单IP，以逗号间隔，白名单优先级高于黑名单" validate:"valid_iplist"`
 	WhiteHostName     string `json:"white_host_name" form:"white_host_name" comment:"白名单主机，以逗号间隔" validate:"valid_iplist"`
 	ClientIPFlowLimit int    `json:"clientip_flow_limit" form:"clientip_flow_limit" comment:"客户端IP限流" validate:""`
 	ServiceFlowLimit  int    `json:"service_flow_limit" form:"service_flow_limit" comment:"服务端限流" validate:""`
-	RoundType         int    `json:"round_type" form:"round_type" comment:"轮询策略" validate:""`
+	RoundType         int    `json:"round_type" form:"round_type" comment:"轮询策略" validate:"valid_round_type"`
 	IpList            string `json:"ip_list" form:"ip_list" comment:"IP列表" validate:"required,valid_ipportlist"`
 	WeightList        string `json:"weight_list" form:"weight_list" comment:"权重列表" validate:"required,valid_weightlist"`
-	ForbidList        string `json:"forbid_list" form:"forbid_list" comment:"禁用IP列表" validate:"valid_iplist"`
+
+	LoadBalanceSettingInput
 }
 
 func (params *ServiceAddTcpInput) GetValidParams(c *gin.Context) error {
@@ -183,12 +215,56 @@ type ServiceUpdateTcpInput struct {
 	WhiteHostName     string `json:"white_host_name" form:"white_host_name" comment:"白名单主机，以逗号间隔" validate:"valid_iplist"`
 	ClientIPFlowLimit int    `json:"clientip_flow_limit" form:"clientip_flow_limit" comment:"客户端IP限流" validate:""`
 	ServiceFlowLimit  int    `json:"service_flow_limit" form:"service_flow_limit" comment:"服务端限流" validate:""`
-	RoundType         int    `json:"round_type" form:"round_type" comment:"轮询策略" validate:""`
+	RoundType         int    `json:"round_type" form:"round_type" comment:"轮询策略" validate:"valid_round_type"`
 	IpList            string `json:"ip_list" form:"ip_list" comment:"IP列表" validate:"required,valid_ipportlist"`
 	WeightList        string `json:"weight_list" form:"weight_list" comment:"权重列表" validate:"required,valid_weightlist"`
-	ForbidList        string `json:"forbid_list" form:"forbid_list" comment:"禁用IP列表" validate:"valid_iplist"`
+
+	LoadBalanceSettingInput
 }
 
 func (params *ServiceUpdateTcpInput) GetValidParams(c *gin.Context) error {
 	return util.DefaultGetValidParams(c, params)
 }
+
+// LoadBalanceSettingInput 服务的负载均衡设置，嵌入新增、更新服务的参数中，会话保持、重试等只对http服务生效
+type LoadBalanceSettingInput struct {
+	CheckMethod   int    `json:"check_method" form:"check_method" comment:"探活方式 0=tcp 1=http 2=grpc" example:"" validate:"valid_check_method"` //探活方式
+	CheckTimeout  int    `json:"check_timeout" form:"check_timeout" comment:"探活超时, 单位s" example:"" validate:"min=0"`                           //探活超时, 单位s
+	CheckInterval int    `json:"check_interval" form:"check_interval" comment:"探活间隔, 单位s" example:"" validate:"min=0"`                         //探活间隔, 单位s
+	CheckPath     string `json:"check_path" form:"check_path" comment:"http探活路径, grpc探活时为服务名" example:"" validate:"max=255"`                   //http探活路径, grpc探活时为服务名
+	CheckStatus   string `json:"check_status" form:"check_status" comment:"http探活期望状态码" example:"200,204" validate:"valid_check_status"`       //http探活期望状态码
+	CheckBody     string `json:"check_body" form:"check_body" comment:"http探活响应体需包含的内容" example:"" validate:"max=255"`                         //http探活响应体需包含的内容
+	CheckFailNum  int    `json:"check_fail_num" form:"check_fail_num" comment:"连续失败次数达到该值时摘除" example:"" validate:"min=0"`                     //连续失败次数达到该值时摘除
+	CheckSuccNum  int    `json:"check_succ_num" form:"check_succ_num" comment:"连续成功次数达到该值时恢复" example:"" validate:"min=0"`                     //连续成功次数达到该值时恢复
+
+	HashKeyType       int    `json:"hash_key_type" form:"hash_key_type" comment:"哈希key 0=默认 1=客户端ip 2=header 3=cookie 4=query 5=jwt_claim" example:"" validate:"valid_hash_key_type"` //哈希key 0=默认 1=客户端ip 2=header 3=cookie 4=query 5=jwt_claim
+	HashKeyName       string `json:"hash_key_name" form:"hash_key_name" comment:"header、cookie、query参数或jwt claim名称" example:"" validate:"max=255"`                                    //header、cookie、query参数或jwt claim名称
+	HashReplicas      int    `json:"hash_replicas" form:"hash_replicas" comment:"一致性哈希虚拟节点数" example:"" validate:"min=0,max=10000"`                                                   //一致性哈希虚拟节点数
+	HashBalanceFactor int    `json:"hash_balance_factor" form:"hash_balance_factor" comment:"有界负载系数, 百分比, 0=不限制" example:"" validate:"omitempty,min=100,max=1000"`                    //有界负载系数, 百分比, 0=不限制
+
+	ForbidList string `json:"forbid_list" form:"forbid_list" comment:"禁用ip列表" example:"" validate:"valid_node_list,valid_node_remain"` //禁用ip列表
+	DrainList  string `json:"drain_list" form:"drain_list" comment:"排空中的ip列表" example:"" validate:"valid_node_list"`                   //排空中的ip列表
+
+	DiscoveryType     int    `json:"discovery_type" form:"discovery_type" comment:"服务发现 0=ip_list 1=dns 2=file 3=http" example:"" validate:"valid_discovery_type"` //服务发现 0=ip_list 1=dns 2=file 3=http
+	DiscoveryTarget   string `json:"discovery_target" form:"discovery_target" comment:"域名、SRV记录、文件路径或注册中心地址" example:"" validate:"max=255"`                        //域名、SRV记录、文件路径或注册中心地址
+	DiscoveryInterval int    `json:"discovery_interval" form:"discovery_interval" comment:"http轮询间隔, dns重新解析间隔上限, 单位s" example:"" validate:"min=0"`                //http轮询间隔, dns重新解析间隔上限, 单位s
+
+	SlowStart int `json:"slow_start" form:"slow_start" comment:"加权轮询慢启动时长, 单位s, 0=不启用" example:"" validate:"min=0"` //加权轮询慢启动时长, 单位s, 0=不启用
+
+	StickySession    int    `json:"sticky_session" form:"sticky_session" comment:"会话保持 0=关闭 1=开启" example:"" validate:"max=1,min=0"`                     //会话保持 0=关闭 1=开启
+	StickyCookieName string `json:"sticky_cookie_name" form:"sticky_cookie_name" comment:"会话保持cookie名称" example:"" validate:"max=255,valid_cookie_name"` //会话保持cookie名称
+	StickyTTL        int    `json:"sticky_ttl" form:"sticky_ttl" comment:"会话保持cookie有效期, 单位s, 0=浏览器会话" example:"" validate:"min=0"`                      //会话保持cookie有效期, 单位s, 0=浏览器会话
+
+	RetryAttempts      int    `json:"retry_attempts" form:"retry_attempts" comment:"最多尝试次数, 含首次请求, 0或1=不重试" example:"" validate:"min=0,max=10"`                            //最多尝试次数, 含首次请求, 0或1=不重试
+	RetryOn            string `json:"retry_on" form:"retry_on" comment:"重试条件 connect_error,timeout或状态码" example:"connect_error,timeout,502,503" validate:"valid_retry_on"` //重试条件 connect_error,timeout或状态码
+	RetryNonIdempotent int    `json:"retry_non_idempotent" form:"retry_non_idempotent" comment:"非幂等方法是否重试 0=否 1=是" example:"" validate:"max=1,min=0"`                      //非幂等方法是否重试 0=否 1=是
+	RetryTryTimeout    int    `json:"retry_try_timeout" form:"retry_try_timeout" comment:"单次尝试等待响应头超时, 单位s, 0=不限制" example:"" validate:"min=0"`                            //单次尝试等待响应头超时, 单位s, 0=不限制
+
+	CircuitErrorRate  int `json:"circuit_error_rate" form:"circuit_error_rate" comment:"熔断失败率, 百分比, 0=不按失败率熔断" example:"" validate:"min=0,max=100"` //熔断失败率, 百分比, 0=不按失败率熔断
+	CircuitSlowRate   int `json:"circuit_slow_rate" form:"circuit_slow_rate" comment:"熔断慢请求占比, 百分比, 0=不按耗时熔断" example:"" validate:"min=0,max=100"`  //熔断慢请求占比, 百分比, 0=不按耗时熔断
+	CircuitSlowTime   int `json:"circuit_slow_time" form:"circuit_slow_time" comment:"慢请求耗时阈值, 单位ms" example:"" validate:"min=0"`                   //慢请求耗时阈值, 单位ms
+	CircuitMinRequest int `json:"circuit_min_request" form:"circuit_min_request" comment:"统计窗口内最少请求数, 0=默认20" example:"" validate:"min=0"`          //统计窗口内最少请求数, 0=默认20
+	CircuitOpenTime   int `json:"circuit_open_time" form:"circuit_open_time" comment:"熔断持续时间, 单位s, 0=默认30" example:"" validate:"min=0"`             //熔断持续时间, 单位s, 0=默认30
+
+	CaptureBody int `json:"capture_body" form:"capture_body" comment:"记录到请求日志的响应体前缀长度, 单位byte, 0=不记录" example:"" validate:"min=0,max=65536"` //记录到请求日志的响应体前缀长度, 单位byte, 0=不记录
+}
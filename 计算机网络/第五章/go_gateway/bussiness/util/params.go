@@ -13,6 +13,11 @@ func DefaultGetValidParams(c *gin.Context, params interface{}) error {
 	if err := c.ShouldBind(params); err != nil {
 		return err
 	}
+	return ValidParams(c, params)
+}
+
+// ValidParams 校验已填充的参数结构体，导入配置等非请求参数场景复用dto的校验规则
+func ValidParams(c *gin.Context, params interface{}) error {
 	//获取验证器
 	valid, err := GetValidator(c)
 	if err != nil {
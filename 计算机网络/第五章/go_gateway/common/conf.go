@@ -187,6 +187,14 @@ func GetStringConf(key string) string {
 	return confString
 }
 
+// GetSecretConf 获取密钥配置，环境变量env优先，未设置时读取配置文件中的key
+func GetSecretConf(key string, env string) string {
+	if secret := os.Getenv(env); secret != "" {
+		return secret
+	}
+	return GetStringConf(key)
+}
+
 // GetStringMapConf 获取get配置信息
 func GetStringMapConf(key string) map[string]interface{} {
 	keys := strings.Split(key, ".")
@@ -0,0 +1,143 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v2"
+	"strings"
+)
+
+// 声明式配置文档编解码
+//
+// 文档字段与结构体的json tag保持一致，yaml、toml先转为通用map再经json与结构体互转，
+// 这样服务、租户模型无需额外声明yaml、toml tag
+
+const (
+	ConfDocFormatYAML = "yaml"
+	ConfDocFormatTOML = "toml"
+	ConfDocFormatJSON = "json"
+)
+
+// ConfDocFormatByExt 根据文件后缀获取文档格式，不支持时返回空
+func ConfDocFormatByExt(fileName string) string {
+	switch {
+	case strings.HasSuffix(fileName, ".yaml"), strings.HasSuffix(fileName, ".yml"):
+		return ConfDocFormatYAML
+	case strings.HasSuffix(fileName, ".toml"):
+		return ConfDocFormatTOML
+	case strings.HasSuffix(fileName, ".json"):
+		return ConfDocFormatJSON
+	}
+	return ""
+}
+
+// DecodeConfDoc 解析yaml、toml、json文档到out
+func DecodeConfDoc(data []byte, format string, out interface{}) error {
+	var raw interface{}
+	switch format {
+	case ConfDocFormatYAML:
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+		raw = normalizeYAML(raw)
+	case ConfDocFormatTOML:
+		tree, err := toml.LoadBytes(data)
+		if err != nil {
+			return err
+		}
+		raw = tree.ToMap()
+	case ConfDocFormatJSON:
+		return json.Unmarshal(data, out)
+	default:
+		return fmt.Errorf("unsupported conf doc format %s", format)
+	}
+	bts, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bts, out)
+}
+
+// EncodeConfDoc 将in编码为yaml、toml、json文档，omitKeys中的字段(如id、时间戳)在任意层级都会被去掉
+func EncodeConfDoc(in interface{}, format string, omitKeys ...string) ([]byte, error) {
+	bts, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(bts))
+	decoder.UseNumber()
+	var raw interface{}
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+	omit := map[string]bool{}
+	for _, key := range omitKeys {
+		omit[key] = true
+	}
+	raw = normalizeJSON(raw, omit)
+
+	switch format {
+	case ConfDocFormatYAML:
+		return yaml.Marshal(raw)
+	case ConfDocFormatTOML:
+		rawMap, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("toml conf doc must be a table")
+		}
+		tree, err := toml.TreeFromMap(rawMap)
+		if err != nil {
+			return nil, err
+		}
+		return tree.Marshal()
+	case ConfDocFormatJSON:
+		return json.MarshalIndent(raw, "", "  ")
+	}
+	return nil, fmt.Errorf("unsupported conf doc format %s", format)
+}
+
+// normalizeYAML yaml.v2解析出的map key为interface{}，转为string以便json编码
+func normalizeYAML(raw interface{}) interface{} {
+	switch v := raw.(type) {
+	case map[interface{}]interface{}:
+		out := map[string]interface{}{}
+		for key, value := range v {
+			out[fmt.Sprint(key)] = normalizeYAML(value)
+		}
+		return out
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeYAML(item)
+		}
+		return v
+	}
+	return raw
+}
+
+// normalizeJSON 去掉omit字段及null值(toml不支持null)，json.Number转为整数或浮点数，避免toml中整数被写为浮点
+func normalizeJSON(raw interface{}, omit map[string]bool) interface{} {
+	switch v := raw.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if omit[key] || value == nil {
+				delete(v, key)
+				continue
+			}
+			v[key] = normalizeJSON(value, omit)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeJSON(item, omit)
+		}
+		return v
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return raw
+}
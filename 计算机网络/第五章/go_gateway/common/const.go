@@ -15,6 +15,10 @@ const (
 	RedisFlowDayKey  = "flow_day_count"
 	RedisFlowHourKey = "flow_hour_count"
 
+	RedisConfVersionKey    = "gateway_conf_version"
+	RedisConfChangeChannel = "gateway_conf_change"
+	RedisNodeStatePrefix   = "gateway_node_state_"
+
 	FlowTotal         = "flow_total"
 	FlowServicePrefix = "flow_service_"
 	FlowAppPrefix     = "flow_app_"
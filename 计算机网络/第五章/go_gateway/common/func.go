@@ -25,6 +25,11 @@ func InitModule(configPath string) error {
 	return initModule(configPath, []string{"base", "mysql", "redis"})
 }
 
+// InitStandaloneModule 单机模式不依赖mysql，服务与租户从本地配置目录读取
+func InitStandaloneModule(configPath string) error {
+	return initModule(configPath, []string{"base", "redis"})
+}
+
 func initModule(configPath string, modules []string) error {
 	if configPath == "" {
 		fmt.Println("input config file like ./conf/dev/")
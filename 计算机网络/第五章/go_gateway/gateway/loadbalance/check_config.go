@@ -2,9 +2,10 @@ package loadbalance
 
 import (
 	"fmt"
-	"net"
-	"reflect"
+	"log"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -14,6 +15,12 @@ const (
 	DefaultCheckTimeout   = 5
 	DefaultCheckMaxErrNum = 2
 	DefaultCheckInterval  = 5
+
+	//default outlier setting
+	DefaultOutlierMaxErrNum       = 5   // 连续失败次数达到该值时摘除节点
+	DefaultOutlierBaseEjectTime   = 30  // 摘除时长，单位s，连续被摘除时按次数倍增
+	DefaultOutlierMaxEjectTime    = 300 // 最长摘除时长，单位s
+	DefaultOutlierMaxEjectPercent = 50  // 最多摘除的节点比例，避免全部节点被摘除
 )
 
 type LoadBalanceCheckConf struct {
@@ -21,6 +28,34 @@ type LoadBalanceCheckConf struct {
 	confIpWeight map[string]string
 	activeList   []string
 	format       string
+	closeChan    chan bool
+	closeOnce    sync.Once
+	// 负载均衡器返回的地址到配置中ip:port的映射
+	addrIp map[string]string
+	// 主动探活设置及对应的探活方式
+	setting CheckSetting
+	checker HealthChecker
+
+	// mux 保护以下被动健康检查状态及activeList
+	mux sync.Mutex
+	// updateMux 保证计算可用列表与通知监听者的顺序一致
+	updateMux sync.Mutex
+	// 主动探活失败的节点
+	downList map[string]bool
+	// 节点连续失败次数
+	outlierErrNum map[string]int
+	// 被摘除的节点及恢复时间
+	ejectedUntil map[string]time.Time
+	// 节点连续被摘除的次数，摘除后恢复期间有成功请求时清零
+	ejectTimes map[string]int
+	// 禁用的节点，不参与负载均衡及探活
+	forbidList map[string]bool
+	// 排空中的节点，不再分配新请求
+	drainList map[string]bool
+	// 节点进行中的请求、连接数
+	connStat *NodeConnStat
+	// 节点熔断器，未启用熔断时为nil
+	circuit *CircuitBreakers
 }
 
 func (s *LoadBalanceCheckConf) Attach(o Observer) {
@@ -34,9 +69,13 @@ func (s *LoadBalanceCheckConf) NotifyAllObservers() {
 }
 
 func (s *LoadBalanceCheckConf) GetConf() []string {
+	s.mux.Lock()
+	activeList := s.activeList
+	confIpWeight := s.confIpWeight
+	s.mux.Unlock()
 	confList := []string{}
-	for _, ip := range s.activeList {
-		weight, ok := s.confIpWeight[ip]
+	for _, ip := range activeList {
+		weight, ok := confIpWeight[ip]
 		if !ok {
 			weight = "50" //默认weight
 		}
@@ -45,58 +84,450 @@ func (s *LoadBalanceCheckConf) GetConf() []string {
 	return confList
 }
 
-// 更新配置时，通知监听者也更新
+// WatchConf 按服务的探活设置定期检查全部节点，可用节点变化时通知监听者
+// 节点连续失败FailNum次后摘除，摘除后连续成功SuccNum次恢复
 func (s *LoadBalanceCheckConf) WatchConf() {
+	setting := s.setting.withDefault()
+	interval := time.Duration(setting.Interval) * time.Second
 	go func() {
-		confIpErrNum := map[string]int{}
-		// 间隔 5秒轮询一次
+		counter := newCheckCounter(setting)
 		for {
-			changedList := []string{}
-			for item, _ := range s.confIpWeight {
-				conn, err := net.DialTimeout("tcp", item, time.Duration(DefaultCheckTimeout)*time.Second)
-				// todo http statuscode
-				if err == nil {
-					conn.Close()
-					if _, ok := confIpErrNum[item]; ok {
-						confIpErrNum[item] = 0
-					}
-				}
-				if err != nil {
-					if _, ok := confIpErrNum[item]; ok {
-						confIpErrNum[item] += 1
-					} else {
-						confIpErrNum[item] = 1
-					}
-				}
-				if confIpErrNum[item] < DefaultCheckMaxErrNum {
-					changedList = append(changedList, item)
-				}
-			}
-			sort.Strings(changedList)
-			sort.Strings(s.activeList)
-			if !reflect.DeepEqual(changedList, s.activeList) {
-				s.UpdateConf(changedList)
+			s.checkOnce(counter)
+			select {
+			case <-s.closeChan:
+				return
+			case <-time.After(interval):
 			}
-			time.Sleep(time.Duration(DefaultCheckInterval) * time.Second)
 		}
 	}()
 }
 
+// checkOnce 探测一轮并按结果更新摘除的节点
+func (s *LoadBalanceCheckConf) checkOnce(counter *checkCounter) {
+	downList := counter.update(s.probe())
+	s.mux.Lock()
+	s.downList = downList
+	s.mux.Unlock()
+	s.refresh()
+}
+
+// checkCounter 各节点连续探测成功、失败的次数，只由探活协程访问
+type checkCounter struct {
+	setting CheckSetting
+	errNum  map[string]int
+	succNum map[string]int
+	down    map[string]bool
+}
+
+func newCheckCounter(setting CheckSetting) *checkCounter {
+	return &checkCounter{
+		setting: setting,
+		errNum:  map[string]int{},
+		succNum: map[string]int{},
+		down:    map[string]bool{},
+	}
+}
+
+// update 记录一轮探测结果，返回摘除中的节点
+func (c *checkCounter) update(results map[string]error) map[string]bool {
+	downList := map[string]bool{}
+	for item, err := range results {
+		if err == nil {
+			c.errNum[item] = 0
+			c.succNum[item]++
+			if c.down[item] && c.succNum[item] >= c.setting.SuccNum {
+				c.down[item] = false
+			}
+		} else {
+			c.succNum[item] = 0
+			c.errNum[item]++
+			if c.errNum[item] >= c.setting.FailNum {
+				c.down[item] = true
+			}
+		}
+		if c.down[item] {
+			downList[item] = true
+		}
+	}
+	return downList
+}
+
+// probe 并发探测全部节点，避免个别节点超时拖慢整轮探活，禁用的节点不探测
+func (s *LoadBalanceCheckConf) probe() map[string]error {
+	results := map[string]error{}
+	resultMux := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	s.mux.Lock()
+	forbidList := s.forbidList
+	confIpWeight := s.confIpWeight
+	s.mux.Unlock()
+	for item := range confIpWeight {
+		if forbidList[item] {
+			continue
+		}
+		wg.Add(1)
+		go func(item string) {
+			defer wg.Done()
+			err := s.checker.Check(item)
+			resultMux.Lock()
+			results[item] = err
+			resultMux.Unlock()
+		}(item)
+	}
+	wg.Wait()
+	return results
+}
+
+// CloseWatch 停止探活协程，配置主体被丢弃时调用
+func (s *LoadBalanceCheckConf) CloseWatch() {
+	s.closeOnce.Do(func() {
+		close(s.closeChan)
+	})
+}
+
 // 更新配置时，通知监听者也更新
 func (s *LoadBalanceCheckConf) UpdateConf(conf []string) {
+	s.mux.Lock()
 	s.activeList = conf
+	s.mux.Unlock()
 	for _, obs := range s.observers {
 		obs.Update()
 	}
 }
 
+// ReportResult 被动健康检查，代理上报真实请求的结果
+// 节点连续失败DefaultOutlierMaxErrNum次后摘除一段时间，摘除与恢复都经由UpdateConf通知负载均衡器
+// addr为负载均衡器返回的地址，可以带有format前缀，如http://127.0.0.1:2003
+func (s *LoadBalanceCheckConf) ReportResult(addr string, success bool) {
+	ip, ok := s.confIp(addr)
+	if !ok {
+		return
+	}
+	if circuit := s.circuitBreakers(); circuit != nil {
+		circuit.ReportResult(ip, success)
+	}
+	s.mux.Lock()
+	if s.outlierErrNum == nil {
+		s.outlierErrNum = map[string]int{}
+		s.ejectedUntil = map[string]time.Time{}
+		s.ejectTimes = map[string]int{}
+	}
+	if success {
+		s.outlierErrNum[ip] = 0
+		if _, ejected := s.ejectedUntil[ip]; !ejected {
+			s.ejectTimes[ip] = 0
+		}
+		s.mux.Unlock()
+		return
+	}
+	s.outlierErrNum[ip]++
+	if s.outlierErrNum[ip] < DefaultOutlierMaxErrNum || !s.ejectable(ip) {
+		s.mux.Unlock()
+		return
+	}
+	s.outlierErrNum[ip] = 0
+	s.ejectTimes[ip]++
+	ejectTime := time.Duration(DefaultOutlierBaseEjectTime*s.ejectTimes[ip]) * time.Second
+	if maxEjectTime := time.Duration(DefaultOutlierMaxEjectTime) * time.Second; ejectTime > maxEjectTime {
+		ejectTime = maxEjectTime
+	}
+	s.ejectedUntil[ip] = time.Now().Add(ejectTime)
+	s.mux.Unlock()
+
+	log.Printf(" [INFO] LoadBalanceCheckConf eject %s for %v\n", ip, ejectTime)
+	time.AfterFunc(ejectTime, func() {
+		s.uneject(ip)
+	})
+	s.refresh()
+}
+
+// ejectable 是否还可以摘除节点，摘除后被摘除的节点数不超过DefaultOutlierMaxEjectPercent，调用方需持有mux
+func (s *LoadBalanceCheckConf) ejectable(ip string) bool {
+	if _, ejected := s.ejectedUntil[ip]; ejected {
+		return false
+	}
+	total := 0
+	for ip := range s.confIpWeight {
+		if !s.downList[ip] {
+			total++
+		}
+	}
+	return (len(s.ejectedUntil)+1)*100 <= total*DefaultOutlierMaxEjectPercent
+}
+
+// IsActive 节点当前是否参与负载均衡，即未被探活摘除、被动摘除、禁用或排空
+// addr为负载均衡器返回的地址
+func (s *LoadBalanceCheckConf) IsActive(addr string) bool {
+	ip, ok := s.confIp(addr)
+	if !ok {
+		return false
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, item := range s.activeList {
+		if item == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// IsEjected 节点当前是否被被动健康检查摘除
+func (s *LoadBalanceCheckConf) IsEjected(ip string) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	_, ejected := s.ejectedUntil[ip]
+	return ejected
+}
+
+func (s *LoadBalanceCheckConf) uneject(ip string) {
+	s.mux.Lock()
+	until, ok := s.ejectedUntil[ip]
+	if !ok || time.Now().Before(until) {
+		s.mux.Unlock()
+		return
+	}
+	delete(s.ejectedUntil, ip)
+	s.outlierErrNum[ip] = 0
+	s.mux.Unlock()
+
+	select {
+	case <-s.closeChan:
+		return
+	default:
+	}
+	log.Printf(" [INFO] LoadBalanceCheckConf uneject %s\n", ip)
+	s.refresh()
+}
+
+// SetNodes 替换全部节点，ip:port -> weight，服务发现获取到新的节点列表时调用
+// 新节点在下一轮探活前视为可用，移除节点的被动健康检查状态一并清除
+func (s *LoadBalanceCheckConf) SetNodes(conf map[string]string) {
+	addrIp := map[string]string{}
+	for item := range conf {
+		addrIp[fmt.Sprintf(s.format, item)] = item
+	}
+	s.mux.Lock()
+	if equalWeights(s.confIpWeight, conf) {
+		s.mux.Unlock()
+		return
+	}
+	s.confIpWeight = conf
+	s.addrIp = addrIp
+	for ip := range s.ejectedUntil {
+		if _, ok := conf[ip]; !ok {
+			delete(s.ejectedUntil, ip)
+			delete(s.ejectTimes, ip)
+			delete(s.outlierErrNum, ip)
+		}
+	}
+	s.mux.Unlock()
+	// 节点不变而权重变化时也需通知监听者
+	s.refreshNodes(true)
+}
+
+// WatchDiscovery 由服务发现来源维护节点列表，CloseWatch时一并停止
+// 首次获取失败时返回错误，继续使用静态配置的节点并在后台重试
+func (s *LoadBalanceCheckConf) WatchDiscovery(discovery Discovery) error {
+	return discovery.Watch(s.SetNodes, s.closeChan)
+}
+
+// SetForbidList 设置禁用的节点，立即从负载均衡器中移除
+func (s *LoadBalanceCheckConf) SetForbidList(ips []string) {
+	s.mux.Lock()
+	s.forbidList = stringSet(ips)
+	s.mux.Unlock()
+	s.refresh()
+}
+
+// SetDrainList 设置排空中的节点，不再分配新请求，已建立的连接不受影响
+func (s *LoadBalanceCheckConf) SetDrainList(ips []string) {
+	s.mux.Lock()
+	s.drainList = stringSet(ips)
+	s.mux.Unlock()
+	s.refresh()
+}
+
+// SetConnStat 设置节点连接数统计，由代理经TrackConn上报
+func (s *LoadBalanceCheckConf) SetConnStat(stat *NodeConnStat) {
+	s.mux.Lock()
+	s.connStat = stat
+	s.mux.Unlock()
+}
+
+// Acquire 代理选中节点并开始请求时调用
+func (s *LoadBalanceCheckConf) Acquire(addr string) {
+	if stat, ip, ok := s.statIp(addr); ok {
+		stat.Acquire(ip)
+	}
+}
+
+// Release 请求结束时调用
+func (s *LoadBalanceCheckConf) Release(addr string) {
+	if stat, ip, ok := s.statIp(addr); ok {
+		stat.Release(ip)
+	}
+}
+
+// SetCircuitBreakers 设置节点熔断器，由代理经ReportResult、ObserveLatency上报结果及耗时
+func (s *LoadBalanceCheckConf) SetCircuitBreakers(circuit *CircuitBreakers) {
+	s.mux.Lock()
+	s.circuit = circuit
+	s.mux.Unlock()
+}
+
+func (s *LoadBalanceCheckConf) circuitBreakers() *CircuitBreakers {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.circuit
+}
+
+// Allow 节点未熔断时返回true，addr为负载均衡器返回的地址
+func (s *LoadBalanceCheckConf) Allow(addr string) bool {
+	circuit := s.circuitBreakers()
+	if circuit == nil {
+		return true
+	}
+	ip, ok := s.confIp(addr)
+	if !ok {
+		return true
+	}
+	return circuit.Allow(ip)
+}
+
+// Observe 请求耗时上报给熔断器
+func (s *LoadBalanceCheckConf) Observe(addr string, rtt time.Duration) {
+	circuit := s.circuitBreakers()
+	if circuit == nil {
+		return
+	}
+	if ip, ok := s.confIp(addr); ok {
+		circuit.Observe(ip, rtt)
+	}
+}
+
+func (s *LoadBalanceCheckConf) statIp(addr string) (*NodeConnStat, string, bool) {
+	s.mux.Lock()
+	stat := s.connStat
+	s.mux.Unlock()
+	if stat == nil {
+		return nil, "", false
+	}
+	ip, ok := s.confIp(addr)
+	return stat, ip, ok
+}
+
+// refresh 可用节点 = 探活通过的节点 - 被摘除的节点 - 禁用及排空中的节点，有变化时通知监听者
+func (s *LoadBalanceCheckConf) refresh() {
+	s.refreshNodes(false)
+}
+
+func (s *LoadBalanceCheckConf) refreshNodes(force bool) {
+	s.updateMux.Lock()
+	defer s.updateMux.Unlock()
+	s.mux.Lock()
+	changedList := []string{}
+	for ip := range s.confIpWeight {
+		if s.downList[ip] {
+			continue
+		}
+		if _, ejected := s.ejectedUntil[ip]; ejected {
+			continue
+		}
+		if s.forbidList[ip] || s.drainList[ip] {
+			continue
+		}
+		changedList = append(changedList, ip)
+	}
+	sort.Strings(changedList)
+	activeList := append([]string{}, s.activeList...)
+	sort.Strings(activeList)
+	s.mux.Unlock()
+	if !force && equalStrings(changedList, activeList) {
+		return
+	}
+	s.UpdateConf(changedList)
+}
+
+// confIp 将负载均衡器返回的地址还原为配置中的ip:port
+func (s *LoadBalanceCheckConf) confIp(addr string) (string, bool) {
+	s.mux.Lock()
+	confIpWeight, addrIp := s.confIpWeight, s.addrIp
+	s.mux.Unlock()
+	if _, ok := confIpWeight[addr]; ok {
+		return addr, true
+	}
+	if ip, ok := addrIp[addr]; ok {
+		return ip, true
+	}
+	for ip := range confIpWeight {
+		if fmt.Sprintf(s.format, ip) == addr {
+			return ip, true
+		}
+	}
+	return "", false
+}
+
+func stringSet(list []string) map[string]bool {
+	set := map[string]bool{}
+	for _, item := range list {
+		if item = strings.TrimSpace(item); item != "" {
+			set[item] = true
+		}
+	}
+	return set
+}
+
+func equalWeights(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for ip, weight := range a {
+		if other, ok := b[ip]; !ok || other != weight {
+			return false
+		}
+	}
+	return true
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func NewLoadBalanceCheckConf(format string, conf map[string]string) (*LoadBalanceCheckConf, error) {
+	return NewLoadBalanceCheckConfWithSetting(format, conf, CheckSetting{})
+}
+
+// NewLoadBalanceCheckConfWithSetting 按服务自身的探活方式、间隔、超时及阈值探活
+func NewLoadBalanceCheckConfWithSetting(format string, conf map[string]string, setting CheckSetting) (*LoadBalanceCheckConf, error) {
+	checker, err := NewHealthChecker(format, setting)
+	if err != nil {
+		return nil, err
+	}
 	aList := []string{}
+	addrIp := map[string]string{}
 	// 默认初始化
 	for item, _ := range conf {
 		aList = append(aList, item)
+		addrIp[fmt.Sprintf(format, item)] = item
+	}
+	mConf := &LoadBalanceCheckConf{
+		format:       format,
+		activeList:   aList,
+		confIpWeight: conf,
+		addrIp:       addrIp,
+		closeChan:    make(chan bool),
+		setting:      setting,
+		checker:      checker,
 	}
-	mConf := &LoadBalanceCheckConf{format: format, activeList: aList, confIpWeight: conf}
 	// 启动协程监听配置，并完成信息同步
 	mConf.WatchConf()
 	return mConf, nil
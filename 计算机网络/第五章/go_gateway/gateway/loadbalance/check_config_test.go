@@ -0,0 +1,79 @@
+package loadbalance
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestCheckConf(ips ...string) *LoadBalanceCheckConf {
+	conf := map[string]string{}
+	for _, ip := range ips {
+		conf[ip] = "50"
+	}
+	return &LoadBalanceCheckConf{
+		format:       "http://%s",
+		activeList:   append([]string{}, ips...),
+		confIpWeight: conf,
+		closeChan:    make(chan bool),
+	}
+}
+
+func TestCheckConfOutlierEject(t *testing.T) {
+	mConf := newTestCheckConf("127.0.0.1:2003", "127.0.0.1:2004", "127.0.0.1:2005", "127.0.0.1:2006")
+	rb := LoadBanlanceFactorWithConf(LbRoundRobin, mConf)
+
+	// 成功请求打断连续失败
+	for i := 0; i < DefaultOutlierMaxErrNum-1; i++ {
+		ReportResult(rb, "http://127.0.0.1:2003", false)
+	}
+	ReportResult(rb, "http://127.0.0.1:2003", true)
+	ReportResult(rb, "http://127.0.0.1:2003", false)
+	if mConf.IsEjected("127.0.0.1:2003") {
+		t.Fatal("expect not ejected")
+	}
+
+	for i := 0; i < DefaultOutlierMaxErrNum; i++ {
+		ReportResult(rb, "http://127.0.0.1:2003", false)
+	}
+	if !mConf.IsEjected("127.0.0.1:2003") {
+		t.Fatal("expect ejected")
+	}
+	for i := 0; i < 12; i++ {
+		if addr, _ := rb.Get(""); addr == "http://127.0.0.1:2003" {
+			t.Fatal("ejected node picked")
+		}
+	}
+}
+
+func TestCheckConfOutlierMaxEjectPercent(t *testing.T) {
+	mConf := newTestCheckConf("127.0.0.1:2003", "127.0.0.1:2004", "127.0.0.1:2005", "127.0.0.1:2006")
+	rb := LoadBanlanceFactorWithConf(LbRandom, mConf)
+	for _, addr := range []string{"127.0.0.1:2003", "127.0.0.1:2004", "127.0.0.1:2005"} {
+		for i := 0; i < DefaultOutlierMaxErrNum; i++ {
+			ReportResult(rb, "http://"+addr, false)
+		}
+	}
+	// 4个节点最多摘除50%
+	if mConf.IsEjected("127.0.0.1:2005") {
+		t.Fatal("expect eject capped")
+	}
+	if len(mConf.GetConf()) != 2 {
+		t.Fatalf("expect 2 active nodes, got %v", mConf.GetConf())
+	}
+}
+
+func TestCheckConfOutlierUneject(t *testing.T) {
+	mConf := newTestCheckConf("127.0.0.1:2003", "127.0.0.1:2004")
+	// 模拟摘除到期
+	mConf.outlierErrNum = map[string]int{}
+	mConf.ejectTimes = map[string]int{"127.0.0.1:2003": 1}
+	mConf.ejectedUntil = map[string]time.Time{"127.0.0.1:2003": time.Now().Add(-time.Second)}
+	mConf.refresh()
+	if len(mConf.GetConf()) != 1 {
+		t.Fatalf("expect 1 active node, got %v", mConf.GetConf())
+	}
+	mConf.uneject("127.0.0.1:2003")
+	if mConf.IsEjected("127.0.0.1:2003") || len(mConf.GetConf()) != 2 {
+		t.Fatalf("expect node restored, got %v", mConf.GetConf())
+	}
+}
@@ -0,0 +1,254 @@
+package loadbalance
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	CircuitClosed   = "closed"    // 正常放行，统计窗口内的失败率与慢请求占比
+	CircuitOpen     = "open"      // 熔断中，快速失败
+	CircuitHalfOpen = "half_open" // 熔断时间结束，放行少量探测请求
+
+	DefaultCircuitWindow           = 10 // 统计窗口，单位s
+	DefaultCircuitMinRequests      = 20
+	DefaultCircuitOpenTime         = 30 // 熔断持续时间，单位s
+	DefaultCircuitHalfOpenRequests = 3
+)
+
+// ErrCircuitOpen 可选节点都处于熔断中
+var ErrCircuitOpen = errors.New("upstream circuit breaker open")
+
+// CircuitSetting 服务的熔断设置，ErrorPercent与SlowPercent都为0时不启用
+type CircuitSetting struct {
+	// ErrorPercent 窗口内失败请求占比达到该百分比时熔断
+	ErrorPercent int
+	// SlowPercent 窗口内耗时超过SlowTime的请求占比达到该百分比时熔断
+	SlowPercent int
+	SlowTime    time.Duration
+	// MinRequests 窗口内请求数达到该值才判断是否熔断
+	MinRequests int
+	Window      time.Duration
+	// OpenTime 熔断持续时间，之后进入半开状态
+	OpenTime time.Duration
+	// HalfOpenRequests 半开状态放行的探测请求数，全部成功时恢复，任一失败时重新熔断
+	HalfOpenRequests int
+}
+
+func (s CircuitSetting) Enabled() bool {
+	return s.ErrorPercent > 0 || (s.SlowPercent > 0 && s.SlowTime > 0)
+}
+
+func (s CircuitSetting) withDefault() CircuitSetting {
+	if s.MinRequests <= 0 {
+		s.MinRequests = DefaultCircuitMinRequests
+	}
+	if s.Window <= 0 {
+		s.Window = DefaultCircuitWindow * time.Second
+	}
+	if s.OpenTime <= 0 {
+		s.OpenTime = DefaultCircuitOpenTime * time.Second
+	}
+	if s.HalfOpenRequests <= 0 {
+		s.HalfOpenRequests = DefaultCircuitHalfOpenRequests
+	}
+	return s
+}
+
+// CircuitBreaker 单个节点的熔断器
+type CircuitBreaker struct {
+	mux     sync.Mutex
+	setting CircuitSetting
+	state   string
+	// 进入熔断或半开状态的时间
+	changedAt time.Time
+	// 当前统计窗口的开始时间及计数
+	windowStart time.Time
+	total       int
+	failed      int
+	slow        int
+	// 半开状态已放行及已成功的探测请求数
+	probes   int
+	probeSuc int
+}
+
+func NewCircuitBreaker(setting CircuitSetting) *CircuitBreaker {
+	return &CircuitBreaker{setting: setting.withDefault(), state: CircuitClosed}
+}
+
+// Allow 是否放行一个请求，熔断时间结束后转为半开并放行探测请求
+func (b *CircuitBreaker) Allow() bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	now := time.Now()
+	switch b.state {
+	case CircuitOpen:
+		if now.Sub(b.changedAt) < b.setting.OpenTime {
+			return false
+		}
+		b.setState(CircuitHalfOpen, now)
+	case CircuitHalfOpen:
+		// 探测请求未上报结果(如客户端取消)时，超过熔断时间后重新放行
+		if b.probes >= b.setting.HalfOpenRequests {
+			if now.Sub(b.changedAt) < b.setting.OpenTime {
+				return false
+			}
+			b.setState(CircuitHalfOpen, now)
+		}
+	default:
+		return true
+	}
+	b.probes++
+	return true
+}
+
+// ReportResult 上报请求结果，关闭状态下按窗口统计失败率，半开状态下决定恢复或重新熔断
+func (b *CircuitBreaker) ReportResult(success bool) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	now := time.Now()
+	switch b.state {
+	case CircuitHalfOpen:
+		if !success {
+			b.setState(CircuitOpen, now)
+			return
+		}
+		b.probeSuc++
+		if b.probeSuc >= b.setting.HalfOpenRequests {
+			b.setState(CircuitClosed, now)
+		}
+	case CircuitClosed:
+		b.rollWindow(now)
+		b.total++
+		if !success {
+			b.failed++
+		}
+		b.trip(now)
+	}
+}
+
+// Observe 上报请求耗时，超过SlowTime计为慢请求，半开状态下慢请求视为探测失败
+func (b *CircuitBreaker) Observe(rtt time.Duration) {
+	if b.setting.SlowPercent <= 0 || b.setting.SlowTime <= 0 || rtt < b.setting.SlowTime {
+		return
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	now := time.Now()
+	switch b.state {
+	case CircuitHalfOpen:
+		b.setState(CircuitOpen, now)
+	case CircuitClosed:
+		b.rollWindow(now)
+		b.slow++
+		b.trip(now)
+	}
+}
+
+func (b *CircuitBreaker) State() string {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.state
+}
+
+// trip 窗口内请求数足够且失败率或慢请求占比达到阈值时熔断，调用方需持有mux
+func (b *CircuitBreaker) trip(now time.Time) {
+	if b.total < b.setting.MinRequests {
+		return
+	}
+	if (b.setting.ErrorPercent > 0 && b.failed*100 >= b.total*b.setting.ErrorPercent) ||
+		(b.setting.SlowPercent > 0 && b.slow*100 >= b.total*b.setting.SlowPercent) {
+		b.setState(CircuitOpen, now)
+	}
+}
+
+// rollWindow 窗口结束时清零计数，调用方需持有mux
+func (b *CircuitBreaker) rollWindow(now time.Time) {
+	if now.Sub(b.windowStart) >= b.setting.Window {
+		b.windowStart = now
+		b.total, b.failed, b.slow = 0, 0, 0
+	}
+}
+
+func (b *CircuitBreaker) setState(state string, now time.Time) {
+	b.state = state
+	b.changedAt = now
+	b.probes, b.probeSuc = 0, 0
+	b.windowStart = now
+	b.total, b.failed, b.slow = 0, 0, 0
+}
+
+// CircuitBreakers 服务各节点的熔断器，key为配置中的ip:port
+type CircuitBreakers struct {
+	mux      sync.Mutex
+	setting  CircuitSetting
+	breakers map[string]*CircuitBreaker
+}
+
+func NewCircuitBreakers(setting CircuitSetting) *CircuitBreakers {
+	return &CircuitBreakers{setting: setting, breakers: map[string]*CircuitBreaker{}}
+}
+
+func (c *CircuitBreakers) breaker(ip string) *CircuitBreaker {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	b, ok := c.breakers[ip]
+	if !ok {
+		b = NewCircuitBreaker(c.setting)
+		c.breakers[ip] = b
+	}
+	return b
+}
+
+func (c *CircuitBreakers) Allow(ip string) bool {
+	return c.breaker(ip).Allow()
+}
+
+func (c *CircuitBreakers) ReportResult(ip string, success bool) {
+	c.breaker(ip).ReportResult(success)
+}
+
+func (c *CircuitBreakers) Observe(ip string, rtt time.Duration) {
+	c.breaker(ip).Observe(rtt)
+}
+
+// State 节点的熔断状态，未有请求的节点为关闭
+func (c *CircuitBreakers) State(ip string) string {
+	c.mux.Lock()
+	b, ok := c.breakers[ip]
+	c.mux.Unlock()
+	if !ok {
+		return CircuitClosed
+	}
+	return b.State()
+}
+
+// CircuitChecker 按节点熔断的配置主体实现此接口
+type CircuitChecker interface {
+	Allow(addr string) bool
+}
+
+// AllowNode 节点未熔断时返回true，负载均衡器未配置熔断时总是放行
+func AllowNode(lb LoadBalance, addr string) bool {
+	if holder, ok := lb.(confHolder); ok {
+		if checker, ok := holder.GetLoadBalanceConf().(CircuitChecker); ok {
+			return checker.Allow(addr)
+		}
+	}
+	return true
+}
+
+// NextAllowed addr为负载均衡器选出的节点，熔断中时换一个未尝试过的节点，跳过的节点记入tried
+// 可选节点都处于熔断中时返回ErrCircuitOpen
+func NextAllowed(lb LoadBalance, key, addr string, tried map[string]bool) (string, error) {
+	for !AllowNode(lb, addr) {
+		tried[addr] = true
+		next, ok := GetExcluding(lb, key, tried)
+		if !ok {
+			return "", ErrCircuitOpen
+		}
+		addr = next
+	}
+	return addr, nil
+}
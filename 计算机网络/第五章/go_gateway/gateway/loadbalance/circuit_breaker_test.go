@@ -0,0 +1,93 @@
+package loadbalance
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerErrorRate(t *testing.T) {
+	b := NewCircuitBreaker(CircuitSetting{ErrorPercent: 50, MinRequests: 4, OpenTime: 100 * time.Millisecond, HalfOpenRequests: 2})
+	// 请求数不足时不熔断
+	b.ReportResult(false)
+	b.ReportResult(false)
+	if b.State() != CircuitClosed {
+		t.Fatal("expect closed before min requests")
+	}
+	b.ReportResult(true)
+	b.ReportResult(false)
+	if b.State() != CircuitOpen || b.Allow() {
+		t.Fatal("expect open")
+	}
+
+	// 熔断时间结束后放行探测请求，探测成功后恢复
+	time.Sleep(150 * time.Millisecond)
+	if !b.Allow() || !b.Allow() || b.Allow() {
+		t.Fatal("expect 2 probes allowed")
+	}
+	if b.State() != CircuitHalfOpen {
+		t.Fatal("expect half open")
+	}
+	b.ReportResult(true)
+	b.ReportResult(true)
+	if b.State() != CircuitClosed {
+		t.Fatal("expect closed after probes succeed")
+	}
+}
+
+func TestCircuitBreakerHalfOpenFail(t *testing.T) {
+	b := NewCircuitBreaker(CircuitSetting{ErrorPercent: 50, MinRequests: 1, OpenTime: 50 * time.Millisecond})
+	b.ReportResult(false)
+	time.Sleep(80 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("expect probe allowed")
+	}
+	b.ReportResult(false)
+	if b.State() != CircuitOpen || b.Allow() {
+		t.Fatal("expect open again after probe failed")
+	}
+}
+
+func TestCircuitBreakerSlow(t *testing.T) {
+	b := NewCircuitBreaker(CircuitSetting{SlowPercent: 50, SlowTime: 100 * time.Millisecond, MinRequests: 4})
+	for i := 0; i < 4; i++ {
+		if i%2 == 0 {
+			b.Observe(200 * time.Millisecond)
+		} else {
+			b.Observe(10 * time.Millisecond)
+		}
+		b.ReportResult(true)
+	}
+	if b.State() != CircuitOpen {
+		t.Fatal("expect open on slow requests")
+	}
+	if (CircuitSetting{SlowPercent: 50}).Enabled() {
+		t.Fatal("expect slow percent without slow time disabled")
+	}
+}
+
+func TestNextAllowed(t *testing.T) {
+	mConf := newTestCheckConf("127.0.0.1:2003", "127.0.0.1:2004")
+	rb := LoadBanlanceFactorWithConf(LbRoundRobin, mConf)
+	if addr, err := NextAllowed(rb, "", "http://127.0.0.1:2003", map[string]bool{}); err != nil || addr != "http://127.0.0.1:2003" {
+		t.Fatal("expect allowed without circuit breakers")
+	}
+
+	circuit := NewCircuitBreakers(CircuitSetting{ErrorPercent: 50, MinRequests: 1})
+	mConf.SetCircuitBreakers(circuit)
+	circuit.ReportResult("127.0.0.1:2003", false)
+	for i := 0; i < 4; i++ {
+		addr, _ := rb.Get("")
+		if addr, err := NextAllowed(rb, "", addr, map[string]bool{}); err != nil || addr != "http://127.0.0.1:2004" {
+			t.Fatalf("expect open node skipped, got %s", addr)
+		}
+	}
+	if mConf.NodeStates()[0].Circuit != CircuitOpen {
+		t.Fatal("expect circuit state reported")
+	}
+
+	// 节点都熔断时快速失败
+	circuit.ReportResult("127.0.0.1:2004", false)
+	if _, err := NextAllowed(rb, "", "http://127.0.0.1:2003", map[string]bool{}); err != ErrCircuitOpen {
+		t.Fatalf("expect circuit open error, got %v", err)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 type Hash func(data []byte) uint32
@@ -32,6 +33,14 @@ type ConsistentHashBanlance struct {
 	replicas int               //复制因子
 	keys     UInt32Slice       //已排序的节点hash切片
 	hashMap  map[uint32]string //节点哈希和Key的map,键是hash值，值是节点key
+	nodes    []string          //去重后的节点
+
+	// 有界负载：节点进行中请求数不超过 平均值*balanceFactor/100，超过时沿环顺延，0表示不限制
+	balanceFactor int
+	// 节点进行中的请求数，配置更新时保留
+	loads map[string]*int64
+	// 按服务设置从请求中选取哈希key
+	option LoadBalanceOption
 
 	//观察主体
 	conf LoadBalanceConf
@@ -42,6 +51,7 @@ func NewConsistentHashBanlance(replicas int, fn Hash) *ConsistentHashBanlance {
 		replicas: replicas,
 		hash:     fn,
 		hashMap:  make(map[uint32]string),
+		loads:    map[string]*int64{},
 	}
 	if m.hash == nil {
 		//最多32位,保证是一个2^32-1环
@@ -50,8 +60,19 @@ func NewConsistentHashBanlance(replicas int, fn Hash) *ConsistentHashBanlance {
 	return m
 }
 
+// NewConsistentHashBanlanceWithOption 按服务设置的虚拟节点数、负载系数及哈希key创建
+func NewConsistentHashBanlanceWithOption(option LoadBalanceOption) *ConsistentHashBanlance {
+	option = option.withDefault()
+	m := NewConsistentHashBanlance(option.HashReplicas, nil)
+	m.balanceFactor = option.HashBalanceFactor
+	m.option = option
+	return m
+}
+
 // 验证是否为空
 func (c *ConsistentHashBanlance) IsEmpty() bool {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
 	return len(c.keys) == 0
 }
 
@@ -60,9 +81,23 @@ func (c *ConsistentHashBanlance) Add(params ...string) error {
 	if len(params) == 0 {
 		return errors.New("param len 1 at least")
 	}
-	addr := params[0]
 	c.mux.Lock()
 	defer c.mux.Unlock()
+	c.add(params[0])
+	return nil
+}
+
+// add 调用方需持有mux
+func (c *ConsistentHashBanlance) add(addr string) {
+	if _, ok := c.loads[addr]; !ok {
+		if c.loads == nil {
+			c.loads = map[string]*int64{}
+		}
+		c.loads[addr] = new(int64)
+	}
+	if !containsString(c.nodes, addr) {
+		c.nodes = append(c.nodes, addr)
+	}
 	// 结合复制因子计算所有虚拟节点的hash值，并存入m.keys中，同时在m.hashMap中保存哈希值和key的映射
 	for i := 0; i < c.replicas; i++ {
 		hash := c.hash([]byte(strconv.Itoa(i) + addr))
@@ -71,12 +106,13 @@ func (c *ConsistentHashBanlance) Add(params ...string) error {
 	}
 	// 对所有虚拟节点的哈希值进行排序，方便之后进行二分查找
 	sort.Sort(c.keys)
-	return nil
 }
 
 // Get 方法根据给定的对象获取最靠近它的那个节点
 func (c *ConsistentHashBanlance) Get(key string) (string, error) {
-	if c.IsEmpty() {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	if len(c.keys) == 0 {
 		return "", errors.New("node is empty")
 	}
 	hash := c.hash([]byte(key))
@@ -88,23 +124,100 @@ func (c *ConsistentHashBanlance) Get(key string) (string, error) {
 	if idx == len(c.keys) {
 		idx = 0
 	}
+	if c.balanceFactor <= 0 {
+		return c.hashMap[c.keys[idx]], nil
+	}
+	// 有界负载：顺着环找到第一个未超过负载上限的节点
+	maxLoad := c.maxLoad()
+	for i := 0; i < len(c.keys); i++ {
+		addr := c.hashMap[c.keys[(idx+i)%len(c.keys)]]
+		if c.load(addr)+1 <= maxLoad {
+			return addr, nil
+		}
+	}
+	return c.hashMap[c.keys[idx]], nil
+}
+
+// maxLoad 单个节点允许的进行中请求数上限 ceil((总请求数+1)*balanceFactor/100/节点数)，调用方需持有mux
+func (c *ConsistentHashBanlance) maxLoad() int64 {
+	total := int64(0)
+	for _, addr := range c.nodes {
+		total += c.load(addr)
+	}
+	n := int64(len(c.nodes))
+	return ((total+1)*int64(c.balanceFactor) + 100*n - 1) / (100 * n)
+}
+
+func (c *ConsistentHashBanlance) load(addr string) int64 {
+	counter, ok := c.loads[addr]
+	if !ok {
+		return 0
+	}
+	if load := atomic.LoadInt64(counter); load > 0 {
+		return load
+	}
+	return 0
+}
+
+// Acquire 代理选中节点并开始请求时调用，用于有界负载
+func (c *ConsistentHashBanlance) Acquire(addr string) {
+	c.mux.RLock()
+	counter, ok := c.loads[addr]
+	c.mux.RUnlock()
+	if ok {
+		atomic.AddInt64(counter, 1)
+	}
+}
+
+// Release 请求结束时调用
+func (c *ConsistentHashBanlance) Release(addr string) {
+	c.mux.RLock()
+	counter, ok := c.loads[addr]
+	c.mux.RUnlock()
+	if ok {
+		atomic.AddInt64(counter, -1)
+	}
+}
+
+// Load 节点当前进行中的请求数
+func (c *ConsistentHashBanlance) Load(addr string) int64 {
 	c.mux.RLock()
 	defer c.mux.RUnlock()
-	return c.hashMap[c.keys[idx]], nil
+	return c.load(addr)
+}
+
+func (c *ConsistentHashBanlance) HashKeyOption() LoadBalanceOption {
+	return c.option
 }
 
 func (c *ConsistentHashBanlance) SetConf(conf LoadBalanceConf) {
 	c.conf = conf
 }
 
+func (c *ConsistentHashBanlance) GetLoadBalanceConf() LoadBalanceConf {
+	return c.conf
+}
+
 func (c *ConsistentHashBanlance) Update() {
 	if conf, ok := c.conf.(*LoadBalanceCheckConf); ok {
 		fmt.Println("Update get check conf:", conf.GetConf())
+		// 持锁重建哈希环，避免重建期间请求取不到节点
+		c.mux.Lock()
+		defer c.mux.Unlock()
 		c.keys = nil
+		c.nodes = nil
 		c.hashMap = map[uint32]string{}
-		fmt.Println("conf.GetConf()", conf.GetConf())
 		for _, ip := range conf.GetConf() {
-			c.Add(strings.Split(ip, ",")...)
+			c.add(strings.Split(ip, ",")[0])
+		}
+	}
+}
+
+func containsString(list []string, item string) bool {
+	for _, v := range list {
+		if v == item {
+			return true
 		}
 	}
+	return false
 }
@@ -24,3 +24,40 @@ func TestNewConsistentHashBanlance(t *testing.T) {
 	fmt.Println(rb.Get("192.168.0.1"))
 	fmt.Println(rb.Get("127.0.0.1"))
 }
+
+func TestConsistentHashBoundedLoad(t *testing.T) {
+	nodes := []string{"127.0.0.1:2003", "127.0.0.1:2004", "127.0.0.1:2005", "127.0.0.1:2006"}
+	rb := NewConsistentHashBanlanceWithOption(LoadBalanceOption{HashReplicas: 100, HashBalanceFactor: 125})
+	for _, node := range nodes {
+		rb.Add(node)
+	}
+	// 同一个热点key的并发请求不会全部落到同一节点
+	for i := 0; i < 40; i++ {
+		addr, err := rb.Get("hot_key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rb.Acquire(addr)
+	}
+	for _, node := range nodes {
+		// 上限 ceil(40*1.25/4)
+		if load := rb.Load(node); load > 13 {
+			t.Fatalf("node %s load %d exceed bound", node, load)
+		}
+	}
+
+	// 无负载时仍按哈希结果选择
+	for _, node := range nodes {
+		for rb.Load(node) > 0 {
+			rb.Release(node)
+		}
+	}
+	addr, _ := rb.Get("hot_key")
+	plain := NewConsistentHashBanlance(100, nil)
+	for _, node := range nodes {
+		plain.Add(node)
+	}
+	if expect, _ := plain.Get("hot_key"); addr != expect {
+		t.Fatalf("expect %s, got %s", expect, addr)
+	}
+}
@@ -0,0 +1,447 @@
+package loadbalance
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/net/dns/dnsmessage"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DiscoveryStatic = 0 // 使用配置的ip_list
+	DiscoveryDNS    = 1 // host:port 解析A记录，_service._proto.name 解析SRV记录
+	DiscoveryFile   = 2 // 本地文件，每行 ip:port [weight]
+	DiscoveryHTTP   = 3 // 轮询HTTP接口，返回 [{"addr":"ip:port","weight":50}]
+
+	DefaultDiscoveryWeight   = "50"
+	DefaultDiscoveryInterval = 10 // HTTP轮询间隔、获取失败后的重试间隔，单位s
+	MinDNSTTL                = 1
+	MaxDNSTTL                = 300
+
+	// discoveryWatchDelay 文件变更后延迟读取，合并编辑器保存时产生的多次事件
+	discoveryWatchDelay = 500 * time.Millisecond
+)
+
+// Discovery 服务发现来源，获取到新的节点列表时通过update通知配置主体
+type Discovery interface {
+	// Watch 同步获取一次节点列表，之后在后台持续更新直到closeChan关闭
+	// 首次获取失败时返回错误，后台仍会重试；获取失败或结果为空时保留上次的节点
+	Watch(update func(map[string]string), closeChan chan bool) error
+}
+
+func IsValidDiscoveryType(discoveryType int) bool {
+	switch discoveryType {
+	case DiscoveryStatic, DiscoveryDNS, DiscoveryFile, DiscoveryHTTP:
+		return true
+	}
+	return false
+}
+
+// NewDiscovery 按服务配置的来源创建，静态配置返回nil
+// interval单位s，HTTP为轮询间隔，DNS为TTL的上限
+func NewDiscovery(discoveryType int, target string, interval int) (Discovery, error) {
+	if discoveryType != DiscoveryStatic && target == "" {
+		return nil, errors.New("discovery target is empty")
+	}
+	switch discoveryType {
+	case DiscoveryStatic:
+		return nil, nil
+	case DiscoveryDNS:
+		return &DNSDiscovery{Target: target, MaxTTL: time.Duration(interval) * time.Second}, nil
+	case DiscoveryFile:
+		return &FileDiscovery{Path: target}, nil
+	case DiscoveryHTTP:
+		return &HTTPDiscovery{URL: target, Interval: time.Duration(interval) * time.Second}, nil
+	}
+	return nil, fmt.Errorf("unsupported discovery type %d", discoveryType)
+}
+
+// pollDiscovery 首次同步获取，之后按fetch返回的间隔在后台重新获取
+func pollDiscovery(name string, fetch func() (map[string]string, time.Duration, error),
+	update func(map[string]string), closeChan chan bool) error {
+	retry := time.Duration(DefaultDiscoveryInterval) * time.Second
+	nodes, wait, err := fetch()
+	if err == nil && len(nodes) > 0 {
+		update(nodes)
+	}
+	firstErr := err
+	go func() {
+		for {
+			if err != nil {
+				log.Printf(" [ERROR] %s discovery err:%v\n", name, err)
+				wait = retry
+			}
+			select {
+			case <-closeChan:
+				return
+			case <-time.After(wait):
+			}
+			nodes, wait, err = fetch()
+			if err == nil && len(nodes) > 0 {
+				update(nodes)
+			}
+		}
+	}()
+	return firstErr
+}
+
+// DNSDiscovery 按记录的TTL重新解析
+type DNSDiscovery struct {
+	Target string
+	// Server dns服务器ip:port，默认取/etc/resolv.conf中的第一个nameserver
+	Server  string
+	Timeout time.Duration
+	// MaxTTL 重新解析间隔的上限，默认MaxDNSTTL
+	MaxTTL time.Duration
+}
+
+func (d *DNSDiscovery) Watch(update func(map[string]string), closeChan chan bool) error {
+	return pollDiscovery("dns "+d.Target, d.Resolve, update, closeChan)
+}
+
+// Resolve 解析节点列表及下次解析的间隔，即全部记录的最小TTL
+func (d *DNSDiscovery) Resolve() (map[string]string, time.Duration, error) {
+	var nodes map[string]string
+	var ttl uint32
+	var err error
+	if strings.HasPrefix(d.Target, "_") {
+		nodes, ttl, err = d.resolveSRV(d.Target)
+	} else {
+		nodes, ttl, err = d.resolveA(d.Target)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	wait := time.Duration(ttl) * time.Second
+	maxTTL := d.MaxTTL
+	if maxTTL <= 0 {
+		maxTTL = MaxDNSTTL * time.Second
+	}
+	if wait > maxTTL {
+		wait = maxTTL
+	}
+	if wait < MinDNSTTL*time.Second {
+		wait = MinDNSTTL * time.Second
+	}
+	return nodes, wait, nil
+}
+
+func (d *DNSDiscovery) resolveA(target string) (map[string]string, uint32, error) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, 0, err
+	}
+	if net.ParseIP(host) != nil {
+		return map[string]string{target: DefaultDiscoveryWeight}, MaxDNSTTL, nil
+	}
+	ips, ttl, err := d.lookupA(host)
+	if err != nil {
+		return nil, 0, err
+	}
+	nodes := map[string]string{}
+	for _, ip := range ips {
+		nodes[net.JoinHostPort(ip, port)] = DefaultDiscoveryWeight
+	}
+	return nodes, ttl, nil
+}
+
+// resolveSRV SRV记录的权重作为节点权重，目标主机优先取附加段中的A记录
+func (d *DNSDiscovery) resolveSRV(target string) (map[string]string, uint32, error) {
+	msg, err := d.query(target, dnsmessage.TypeSRV)
+	if err != nil {
+		return nil, 0, err
+	}
+	additional := map[string][]string{}
+	for _, res := range msg.Additionals {
+		if a, ok := res.Body.(*dnsmessage.AResource); ok {
+			name := res.Header.Name.String()
+			additional[name] = append(additional[name], net.IP(a.A[:]).String())
+		}
+	}
+	nodes := map[string]string{}
+	ttl := uint32(MaxDNSTTL)
+	for _, res := range msg.Answers {
+		srv, ok := res.Body.(*dnsmessage.SRVResource)
+		if !ok {
+			continue
+		}
+		ttl = minTTL(ttl, res.Header.TTL)
+		ips, ok := additional[srv.Target.String()]
+		if !ok {
+			var hostTTL uint32
+			if ips, hostTTL, err = d.lookupA(srv.Target.String()); err != nil {
+				return nil, 0, err
+			}
+			ttl = minTTL(ttl, hostTTL)
+		}
+		weight := strconv.Itoa(int(srv.Weight))
+		if srv.Weight == 0 {
+			weight = "1"
+		}
+		for _, ip := range ips {
+			nodes[net.JoinHostPort(ip, strconv.Itoa(int(srv.Port)))] = weight
+		}
+	}
+	return nodes, ttl, nil
+}
+
+func (d *DNSDiscovery) lookupA(host string) ([]string, uint32, error) {
+	msg, err := d.query(host, dnsmessage.TypeA)
+	if err != nil {
+		return nil, 0, err
+	}
+	ips := []string{}
+	ttl := uint32(MaxDNSTTL)
+	for _, res := range msg.Answers {
+		if a, ok := res.Body.(*dnsmessage.AResource); ok {
+			ips = append(ips, net.IP(a.A[:]).String())
+			ttl = minTTL(ttl, res.Header.TTL)
+		}
+	}
+	return ips, ttl, nil
+}
+
+// query 直接向dns服务器发起查询，标准库的解析接口不返回TTL
+func (d *DNSDiscovery) query(name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	req := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: uint16(rand.Intn(1 << 16)), RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := req.Pack()
+	if err != nil {
+		return nil, err
+	}
+	server := d.Server
+	if server == "" {
+		if server, err = defaultNameserver(); err != nil {
+			return nil, err
+		}
+	}
+	timeout := d.Timeout
+	if timeout <= 0 {
+		timeout = time.Duration(DefaultCheckTimeout) * time.Second
+	}
+	conn, err := net.DialTimeout("udp", server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(packed); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	resp := &dnsmessage.Message{}
+	if err := resp.Unpack(buf[:n]); err != nil {
+		return nil, err
+	}
+	if resp.ID != req.ID {
+		return nil, errors.New("dns response id mismatch")
+	}
+	if resp.RCode != dnsmessage.RCodeSuccess {
+		return nil, fmt.Errorf("dns query %s %v: %v", name, qtype, resp.RCode)
+	}
+	return resp, nil
+}
+
+func defaultNameserver() (string, error) {
+	data, err := ioutil.ReadFile("/etc/resolv.conf")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53"), nil
+		}
+	}
+	return "", errors.New("no nameserver in /etc/resolv.conf")
+}
+
+func minTTL(a, b uint32) uint32 {
+	if b < a {
+		return b
+	}
+	return a
+}
+
+// FileDiscovery 监听本地文件，每行 ip:port [weight] 或 ip:port,weight，#开头为注释
+type FileDiscovery struct {
+	Path string
+}
+
+func (d *FileDiscovery) Watch(update func(map[string]string), closeChan chan bool) error {
+	nodes, err := d.Load()
+	if err == nil && len(nodes) > 0 {
+		update(nodes)
+	}
+	firstErr := err
+	// 监听所在目录，编辑器保存时常以新文件替换原文件
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(d.Path)); err != nil {
+		watcher.Close()
+		return err
+	}
+	path := filepath.Clean(d.Path)
+	go func() {
+		defer watcher.Close()
+		var timer *time.Timer
+		for {
+			select {
+			case <-closeChan:
+				if timer != nil {
+					timer.Stop()
+				}
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != path {
+					continue
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(discoveryWatchDelay, func() {
+					nodes, err := d.Load()
+					if err != nil {
+						log.Printf(" [ERROR] file %s discovery err:%v\n", d.Path, err)
+						return
+					}
+					if len(nodes) > 0 {
+						update(nodes)
+					}
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf(" [ERROR] file %s discovery watch err:%v\n", d.Path, err)
+			}
+		}
+	}()
+	return firstErr
+}
+
+func (d *FileDiscovery) Load() (map[string]string, error) {
+	data, err := ioutil.ReadFile(d.Path)
+	if err != nil {
+		return nil, err
+	}
+	return parseNodeLines(string(data))
+}
+
+func parseNodeLines(data string) (map[string]string, error) {
+	nodes := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for num := 1; scanner.Scan(); num++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(strings.Replace(line, ",", " ", 1))
+		weight := DefaultDiscoveryWeight
+		if len(fields) > 1 {
+			weight = fields[1]
+		}
+		if err := checkNode(fields[0], weight); err != nil {
+			return nil, fmt.Errorf("line %d: %v", num, err)
+		}
+		nodes[fields[0]] = weight
+	}
+	return nodes, scanner.Err()
+}
+
+func checkNode(addr string, weight string) error {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return err
+	}
+	if _, err := strconv.ParseUint(weight, 10, 32); err != nil {
+		return fmt.Errorf("invalid weight %s of %s", weight, addr)
+	}
+	return nil
+}
+
+// HTTPDiscovery 定期轮询注册中心接口
+type HTTPDiscovery struct {
+	URL      string
+	Interval time.Duration
+	Client   *http.Client
+}
+
+// DiscoveryNode HTTP接口返回的节点，weight为空时使用默认权重
+type DiscoveryNode struct {
+	Addr   string      `json:"addr"`
+	Weight json.Number `json:"weight"`
+}
+
+func (d *HTTPDiscovery) Watch(update func(map[string]string), closeChan chan bool) error {
+	return pollDiscovery("http "+d.URL, d.poll, update, closeChan)
+}
+
+func (d *HTTPDiscovery) poll() (map[string]string, time.Duration, error) {
+	interval := d.Interval
+	if interval <= 0 {
+		interval = time.Duration(DefaultDiscoveryInterval) * time.Second
+	}
+	nodes, err := d.Fetch()
+	return nodes, interval, err
+}
+
+func (d *HTTPDiscovery) Fetch() (map[string]string, error) {
+	client := d.Client
+	if client == nil {
+		client = &http.Client{Timeout: time.Duration(DefaultCheckTimeout) * time.Second}
+	}
+	resp, err := client.Get(d.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	list := []*DiscoveryNode{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&list); err != nil {
+		return nil, err
+	}
+	nodes := map[string]string{}
+	for _, item := range list {
+		weight := item.Weight.String()
+		if weight == "" {
+			weight = DefaultDiscoveryWeight
+		}
+		if err := checkNode(item.Addr, weight); err != nil {
+			return nil, err
+		}
+		nodes[item.Addr] = weight
+	}
+	return nodes, nil
+}
@@ -0,0 +1,257 @@
+package loadbalance
+
+import (
+	"fmt"
+	"golang.org/x/net/dns/dnsmessage"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDNS 按域名返回A记录，SRV记录指向的主机在附加段中返回
+type fakeDNS struct {
+	conn net.PacketConn
+	mux  sync.Mutex
+	a    map[string][]string
+	srv  map[string][]dnsmessage.SRVResource
+	ttl  uint32
+}
+
+func newFakeDNS(t *testing.T, ttl uint32) *fakeDNS {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &fakeDNS{conn: conn, a: map[string][]string{}, srv: map[string][]dnsmessage.SRVResource{}, ttl: ttl}
+	go d.serve()
+	t.Cleanup(func() { conn.Close() })
+	return d
+}
+
+func (d *fakeDNS) setA(name string, ips ...string) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.a[name] = ips
+}
+
+func (d *fakeDNS) setSRV(name string, records ...dnsmessage.SRVResource) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.srv[name] = records
+}
+
+func (d *fakeDNS) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := d.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req := dnsmessage.Message{}
+		if err := req.Unpack(buf[:n]); err != nil || len(req.Questions) == 0 {
+			continue
+		}
+		q := req.Questions[0]
+		resp := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: req.ID, Response: true},
+			Questions: req.Questions,
+		}
+		d.mux.Lock()
+		switch q.Type {
+		case dnsmessage.TypeA:
+			ips, ok := d.a[q.Name.String()]
+			if !ok {
+				resp.RCode = dnsmessage.RCodeNameError
+			}
+			resp.Answers = d.aResources(q.Name, ips)
+		case dnsmessage.TypeSRV:
+			for _, srv := range d.srv[q.Name.String()] {
+				srv := srv
+				resp.Answers = append(resp.Answers, dnsmessage.Resource{
+					Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET, TTL: d.ttl},
+					Body:   &srv,
+				})
+				resp.Additionals = append(resp.Additionals, d.aResources(srv.Target, d.a[srv.Target.String()])...)
+			}
+		}
+		d.mux.Unlock()
+		packed, _ := resp.Pack()
+		d.conn.WriteTo(packed, addr)
+	}
+}
+
+func (d *fakeDNS) aResources(name dnsmessage.Name, ips []string) []dnsmessage.Resource {
+	resources := []dnsmessage.Resource{}
+	for _, ip := range ips {
+		a := dnsmessage.AResource{}
+		copy(a.A[:], net.ParseIP(ip).To4())
+		resources = append(resources, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: d.ttl},
+			Body:   &a,
+		})
+	}
+	return resources
+}
+
+// waitNodes 等待配置主体的可用节点变为期望值
+func waitNodes(t *testing.T, mConf *LoadBalanceCheckConf, expect ...string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := fmt.Sprint(mConf.GetConf())
+		if got == fmt.Sprint(expect) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect nodes %v, got %s", expect, got)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestDNSDiscoveryA(t *testing.T) {
+	dns := newFakeDNS(t, 1)
+	dns.setA("backend.local.", "10.0.0.1", "10.0.0.2")
+
+	mConf := newTestCheckConf("127.0.0.1:2003")
+	defer mConf.CloseWatch()
+	rb := LoadBanlanceFactorWithConf(LbRoundRobin, mConf)
+	discovery := &DNSDiscovery{Target: "backend.local:8080", Server: dns.conn.LocalAddr().String()}
+	if err := mConf.WatchDiscovery(discovery); err != nil {
+		t.Fatal(err)
+	}
+	waitNodes(t, mConf, "http://10.0.0.1:8080,50", "http://10.0.0.2:8080,50")
+	if addr, _ := rb.Get(""); addr != "http://10.0.0.1:8080" && addr != "http://10.0.0.2:8080" {
+		t.Fatalf("unexpected addr %s", addr)
+	}
+
+	// TTL到期后重新解析
+	dns.setA("backend.local.", "10.0.0.2", "10.0.0.3")
+	waitNodes(t, mConf, "http://10.0.0.2:8080,50", "http://10.0.0.3:8080,50")
+
+	// 解析失败时保留上次的节点
+	dns.mux.Lock()
+	delete(dns.a, "backend.local.")
+	dns.mux.Unlock()
+	time.Sleep(1500 * time.Millisecond)
+	waitNodes(t, mConf, "http://10.0.0.2:8080,50", "http://10.0.0.3:8080,50")
+}
+
+func TestDNSDiscoverySRV(t *testing.T) {
+	dns := newFakeDNS(t, 30)
+	dns.setA("node1.local.", "10.0.0.1")
+	dns.setA("node2.local.", "10.0.0.2")
+	target1, _ := dnsmessage.NewName("node1.local.")
+	target2, _ := dnsmessage.NewName("node2.local.")
+	dns.setSRV("_http._tcp.backend.local.",
+		dnsmessage.SRVResource{Weight: 100, Port: 8001, Target: target1},
+		dnsmessage.SRVResource{Weight: 0, Port: 8002, Target: target2})
+	discovery := &DNSDiscovery{Target: "_http._tcp.backend.local", Server: dns.conn.LocalAddr().String(), MaxTTL: 10 * time.Second}
+	nodes, wait, err := discovery.Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(nodes) != "map[10.0.0.1:8001:100 10.0.0.2:8002:1]" {
+		t.Fatalf("unexpected nodes %v", nodes)
+	}
+	// 重新解析间隔不超过MaxTTL
+	if wait != 10*time.Second {
+		t.Fatalf("unexpected wait %v", wait)
+	}
+}
+
+func TestFileDiscovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nodes.txt")
+	if err := ioutil.WriteFile(path, []byte("# backend\n127.0.0.1:2003 100\n127.0.0.1:2004,20\n127.0.0.1:2005\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mConf := newTestCheckConf("127.0.0.1:2003")
+	defer mConf.CloseWatch()
+	if err := mConf.WatchDiscovery(&FileDiscovery{Path: path}); err != nil {
+		t.Fatal(err)
+	}
+	waitNodes(t, mConf, "http://127.0.0.1:2003,100", "http://127.0.0.1:2004,20", "http://127.0.0.1:2005,50")
+
+	// 以新文件替换，只改变权重也会通知
+	tmp := path + ".tmp"
+	ioutil.WriteFile(tmp, []byte("127.0.0.1:2003 10\n127.0.0.1:2004 20\n"), 0644)
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	waitNodes(t, mConf, "http://127.0.0.1:2003,10", "http://127.0.0.1:2004,20")
+
+	// 格式错误时保留上次的节点
+	ioutil.WriteFile(path, []byte("127.0.0.1\n"), 0644)
+	time.Sleep(2 * discoveryWatchDelay)
+	waitNodes(t, mConf, "http://127.0.0.1:2003,10", "http://127.0.0.1:2004,20")
+}
+
+func TestHTTPDiscovery(t *testing.T) {
+	body := `[{"addr":"127.0.0.1:2003","weight":100},{"addr":"127.0.0.1:2004","weight":"20"},{"addr":"127.0.0.1:2005"}]`
+	bodyMux := sync.Mutex{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bodyMux.Lock()
+		defer bodyMux.Unlock()
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	mConf := newTestCheckConf("127.0.0.1:2003")
+	defer mConf.CloseWatch()
+	if err := mConf.WatchDiscovery(&HTTPDiscovery{URL: server.URL, Interval: 100 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	waitNodes(t, mConf, "http://127.0.0.1:2003,100", "http://127.0.0.1:2004,20", "http://127.0.0.1:2005,50")
+
+	// 空列表不会清空节点
+	bodyMux.Lock()
+	body = `[]`
+	bodyMux.Unlock()
+	time.Sleep(300 * time.Millisecond)
+	waitNodes(t, mConf, "http://127.0.0.1:2003,100", "http://127.0.0.1:2004,20", "http://127.0.0.1:2005,50")
+
+	bodyMux.Lock()
+	body = `[{"addr":"127.0.0.1:2006","weight":30}]`
+	bodyMux.Unlock()
+	waitNodes(t, mConf, "http://127.0.0.1:2006,30")
+}
+
+func TestCheckConfSetNodes(t *testing.T) {
+	mConf := newTestCheckConf("127.0.0.1:2003", "127.0.0.1:2004", "127.0.0.1:2005", "127.0.0.1:2006")
+	rb := LoadBanlanceFactorWithConf(LbRoundRobin, mConf)
+	for i := 0; i < DefaultOutlierMaxErrNum; i++ {
+		ReportResult(rb, "http://127.0.0.1:2003", false)
+	}
+	if !mConf.IsEjected("127.0.0.1:2003") {
+		t.Fatal("expect ejected")
+	}
+	// 移除的节点同时清除摘除状态
+	mConf.SetNodes(map[string]string{"127.0.0.1:2004": "50", "127.0.0.1:2007": "50"})
+	if mConf.IsEjected("127.0.0.1:2003") {
+		t.Fatal("expect eject state removed")
+	}
+	waitNodes(t, mConf, "http://127.0.0.1:2004,50", "http://127.0.0.1:2007,50")
+	if _, ok := mConf.confIp("http://127.0.0.1:2007"); !ok {
+		t.Fatal("expect new node mapped")
+	}
+}
+
+func TestNewDiscovery(t *testing.T) {
+	if d, err := NewDiscovery(DiscoveryStatic, "", 0); d != nil || err != nil {
+		t.Fatal("expect static discovery nil")
+	}
+	if _, err := NewDiscovery(DiscoveryDNS, "", 0); err == nil {
+		t.Fatal("expect target required")
+	}
+	if _, err := NewDiscovery(9, "x", 0); err == nil {
+		t.Fatal("expect unsupported type")
+	}
+	if _, err := parseNodeLines("127.0.0.1:2003 abc"); err == nil {
+		t.Fatal("expect invalid weight")
+	}
+}
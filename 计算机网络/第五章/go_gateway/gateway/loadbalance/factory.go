@@ -7,8 +7,20 @@ const (
 	LbRoundRobin
 	LbWeightRoundRobin
 	LbConsistentHash
+	LbLeastConn
+	LbP2C
+	LbMaglev
 )
 
+// IsValidLbType round_type是否为支持的负载均衡策略
+func IsValidLbType(lbType int) bool {
+	switch LbType(lbType) {
+	case LbRandom, LbRoundRobin, LbWeightRoundRobin, LbConsistentHash, LbLeastConn, LbP2C, LbMaglev:
+		return true
+	}
+	return false
+}
+
 func LoadBanlanceFactory(lbType LbType) LoadBalance {
 	switch lbType {
 	case LbRandom:
@@ -19,12 +31,23 @@ func LoadBanlanceFactory(lbType LbType) LoadBalance {
 		return &RoundRobinBalance{}
 	case LbWeightRoundRobin:
 		return &WeightRoundRobinBalance{}
+	case LbLeastConn:
+		return NewLeastConnBalance()
+	case LbP2C:
+		return NewP2CBalance()
+	case LbMaglev:
+		return NewMaglevBalance(DefaultMaglevTableSize)
 	default:
 		return &RandomBalance{}
 	}
 }
 
 func LoadBanlanceFactorWithConf(lbType LbType, mConf LoadBalanceConf) LoadBalance {
+	return LoadBanlanceFactorWithOption(lbType, mConf, LoadBalanceOption{})
+}
+
+// LoadBanlanceFactorWithOption 按服务的负载均衡设置创建，如哈希key、虚拟节点数
+func LoadBanlanceFactorWithOption(lbType LbType, mConf LoadBalanceConf, option LoadBalanceOption) LoadBalance {
 	//观察者模式
 	switch lbType {
 	case LbRandom:
@@ -34,7 +57,7 @@ func LoadBanlanceFactorWithConf(lbType LbType, mConf LoadBalanceConf) LoadBalanc
 		lb.Update()
 		return lb
 	case LbConsistentHash:
-		lb := NewConsistentHashBanlance(10, nil)
+		lb := NewConsistentHashBanlanceWithOption(option)
 		lb.SetConf(mConf)
 		mConf.Attach(lb)
 		lb.Update()
@@ -46,7 +69,25 @@ func LoadBanlanceFactorWithConf(lbType LbType, mConf LoadBalanceConf) LoadBalanc
 		lb.Update()
 		return lb
 	case LbWeightRoundRobin:
-		lb := &WeightRoundRobinBalance{}
+		lb := NewWeightRoundRobinBalanceWithOption(option)
+		lb.SetConf(mConf)
+		mConf.Attach(lb)
+		lb.Update()
+		return lb
+	case LbLeastConn:
+		lb := NewLeastConnBalance()
+		lb.SetConf(mConf)
+		mConf.Attach(lb)
+		lb.Update()
+		return lb
+	case LbP2C:
+		lb := NewP2CBalance()
+		lb.SetConf(mConf)
+		mConf.Attach(lb)
+		lb.Update()
+		return lb
+	case LbMaglev:
+		lb := NewMaglevBalanceWithOption(option)
 		lb.SetConf(mConf)
 		mConf.Attach(lb)
 		lb.Update()
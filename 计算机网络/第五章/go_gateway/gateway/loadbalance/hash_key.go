@@ -0,0 +1,162 @@
+package loadbalance
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	HashKeyDefault  = 0 // HTTP取请求url，TCP取客户端地址，gRPC取方法名
+	HashKeyClientIP = 1
+	HashKeyHeader   = 2 // HTTP header，gRPC metadata
+	HashKeyCookie   = 3
+	HashKeyQuery    = 4
+	HashKeyJwtClaim = 5 // Authorization中jwt的claim，如iss
+
+	DefaultHashReplicas = 10
+)
+
+// LoadBalanceOption 服务级别的负载均衡设置，零值字段使用默认值
+type LoadBalanceOption struct {
+	// HashKeyType 哈希类负载均衡的key来源，请求中取不到对应的值时退化为客户端ip
+	HashKeyType int
+	// HashKeyName header、cookie、query参数或jwt claim的名称
+	HashKeyName string
+	// HashReplicas 一致性哈希每个节点的虚拟节点数
+	HashReplicas int
+	// HashBalanceFactor 有界负载一致性哈希的负载系数，百分比，如125表示节点进行中请求数不超过平均值的1.25倍，0表示不限制
+	HashBalanceFactor int
+	// SlowStart 加权轮询的慢启动时长，单位s，新加入或恢复的节点在该时间内权重逐步升至配置值，0表示不启用
+	SlowStart int
+}
+
+func (o LoadBalanceOption) withDefault() LoadBalanceOption {
+	if o.HashReplicas <= 0 {
+		o.HashReplicas = DefaultHashReplicas
+	}
+	if o.HashBalanceFactor > 0 && o.HashBalanceFactor < 100 {
+		o.HashBalanceFactor = 100
+	}
+	return o
+}
+
+func IsValidHashKeyType(keyType int) bool {
+	switch keyType {
+	case HashKeyDefault, HashKeyClientIP, HashKeyHeader, HashKeyCookie, HashKeyQuery, HashKeyJwtClaim:
+		return true
+	}
+	return false
+}
+
+// HashKeyer 按服务设置从请求中选取哈希key的负载均衡器实现此接口
+type HashKeyer interface {
+	HashKeyOption() LoadBalanceOption
+}
+
+func hashKeyOption(lb LoadBalance) (LoadBalanceOption, bool) {
+	if keyer, ok := lb.(HashKeyer); ok {
+		return keyer.HashKeyOption(), true
+	}
+	return LoadBalanceOption{}, false
+}
+
+// HTTPHashKey HTTP请求传给负载均衡器Get的key，clientIP为网关识别出的客户端ip
+func HTTPHashKey(lb LoadBalance, req *http.Request, clientIP string) string {
+	opt, ok := hashKeyOption(lb)
+	if !ok || opt.HashKeyType == HashKeyDefault {
+		return req.URL.String()
+	}
+	key := ""
+	switch opt.HashKeyType {
+	case HashKeyHeader:
+		key = req.Header.Get(opt.HashKeyName)
+	case HashKeyCookie:
+		if cookie, err := req.Cookie(opt.HashKeyName); err == nil {
+			key = cookie.Value
+		}
+	case HashKeyQuery:
+		key = req.URL.Query().Get(opt.HashKeyName)
+	case HashKeyJwtClaim:
+		key = jwtClaim(req.Header.Get("Authorization"), opt.HashKeyName)
+	}
+	if key == "" {
+		return clientIP
+	}
+	return key
+}
+
+// TCPHashKey TCP连接传给负载均衡器Get的key，TCP只能按客户端ip选取
+func TCPHashKey(lb LoadBalance, remoteAddr string) string {
+	opt, ok := hashKeyOption(lb)
+	if !ok || opt.HashKeyType == HashKeyDefault {
+		return remoteAddr
+	}
+	return hostOf(remoteAddr)
+}
+
+// GrpcHashKey gRPC请求传给负载均衡器Get的key，cookie、query不适用，按客户端ip选取
+func GrpcHashKey(lb LoadBalance, ctx context.Context, fullMethodName string) string {
+	opt, ok := hashKeyOption(lb)
+	if !ok || opt.HashKeyType == HashKeyDefault {
+		return fullMethodName
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	key := ""
+	switch opt.HashKeyType {
+	case HashKeyHeader:
+		if values := md.Get(opt.HashKeyName); len(values) > 0 {
+			key = values[0]
+		}
+	case HashKeyJwtClaim:
+		if values := md.Get("authorization"); len(values) > 0 {
+			key = jwtClaim(values[0], opt.HashKeyName)
+		}
+	}
+	if key != "" {
+		return key
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return hostOf(p.Addr.String())
+	}
+	return fullMethodName
+}
+
+// jwtClaim 取jwt payload中的claim，签名由鉴权中间件校验，这里只用于选取节点
+func jwtClaim(authorization string, name string) string {
+	token := strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return ""
+	}
+	claims := map[string]interface{}{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	value, ok := claims[name]
+	if !ok || value == nil {
+		return ""
+	}
+	if str, ok := value.(string); ok {
+		return str
+	}
+	return fmt.Sprint(value)
+}
+
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,66 @@
+package loadbalance
+
+import (
+	"context"
+	"encoding/base64"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPHashKey(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"app_id_a","uid":1001}`))
+	req := httptest.NewRequest(http.MethodGet, "http://127.0.0.1:8080/base/getinfo?user=u1", nil)
+	req.Header.Set("X-User", "h1")
+	req.Header.Set("Authorization", "Bearer header."+payload+".sign")
+	req.AddCookie(&http.Cookie{Name: "session", Value: "c1"})
+
+	cases := []struct {
+		option LoadBalanceOption
+		key    string
+	}{
+		{LoadBalanceOption{}, "http://127.0.0.1:8080/base/getinfo?user=u1"},
+		{LoadBalanceOption{HashKeyType: HashKeyClientIP}, "10.0.0.1"},
+		{LoadBalanceOption{HashKeyType: HashKeyHeader, HashKeyName: "X-User"}, "h1"},
+		{LoadBalanceOption{HashKeyType: HashKeyCookie, HashKeyName: "session"}, "c1"},
+		{LoadBalanceOption{HashKeyType: HashKeyQuery, HashKeyName: "user"}, "u1"},
+		{LoadBalanceOption{HashKeyType: HashKeyJwtClaim, HashKeyName: "iss"}, "app_id_a"},
+		{LoadBalanceOption{HashKeyType: HashKeyJwtClaim, HashKeyName: "uid"}, "1001"},
+		// 取不到时退化为客户端ip
+		{LoadBalanceOption{HashKeyType: HashKeyHeader, HashKeyName: "X-None"}, "10.0.0.1"},
+		{LoadBalanceOption{HashKeyType: HashKeyJwtClaim, HashKeyName: "none"}, "10.0.0.1"},
+	}
+	for _, item := range cases {
+		lb := NewConsistentHashBanlanceWithOption(item.option)
+		if key := HTTPHashKey(lb, req, "10.0.0.1"); key != item.key {
+			t.Fatalf("%+v expect %s, got %s", item.option, item.key, key)
+		}
+	}
+	// 非哈希类负载均衡器保持原有key
+	if key := HTTPHashKey(&RoundRobinBalance{}, req, "10.0.0.1"); key != req.URL.String() {
+		t.Fatalf("unexpected key %s", key)
+	}
+}
+
+func TestTCPAndGrpcHashKey(t *testing.T) {
+	lb := NewConsistentHashBanlanceWithOption(LoadBalanceOption{HashKeyType: HashKeyClientIP})
+	if key := TCPHashKey(lb, "10.0.0.1:53211"); key != "10.0.0.1" {
+		t.Fatalf("unexpected tcp key %s", key)
+	}
+	if key := TCPHashKey(NewConsistentHashBanlance(10, nil), "10.0.0.1:53211"); key != "10.0.0.1:53211" {
+		t.Fatalf("unexpected tcp key %s", key)
+	}
+
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 5000}})
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-user", "m1"))
+	if key := GrpcHashKey(lb, ctx, "/echo.Echo/UnaryEcho"); key != "10.0.0.2" {
+		t.Fatalf("unexpected grpc key %s", key)
+	}
+	lb = NewConsistentHashBanlanceWithOption(LoadBalanceOption{HashKeyType: HashKeyHeader, HashKeyName: "x-user"})
+	if key := GrpcHashKey(lb, ctx, "/echo.Echo/UnaryEcho"); key != "m1" {
+		t.Fatalf("unexpected grpc key %s", key)
+	}
+}
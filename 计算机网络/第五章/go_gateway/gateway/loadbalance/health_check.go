@@ -0,0 +1,243 @@
+package loadbalance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CheckMethodTCP  = 0 // 检测端口是否握手成功
+	CheckMethodHTTP = 1 // GET请求指定路径，检查状态码及响应体
+	CheckMethodGRPC = 2 // 标准grpc.health.v1健康检查
+
+	DefaultCheckSuccNum = 1
+	DefaultCheckPath    = "/"
+	DefaultCheckStatus  = "200-399"
+
+	// checkBodyLimit 检查响应体时最多读取的字节数
+	checkBodyLimit = 64 * 1024
+)
+
+// CheckSetting 服务的主动探活设置，零值字段使用默认值
+type CheckSetting struct {
+	Method   int
+	Timeout  int // 单次探活超时，单位s
+	Interval int // 探活间隔，单位s
+	// Path HTTP探活路径；gRPC探活时为grpc.health.v1的服务名，为空时检查整体状态
+	Path string
+	// Status 期望的HTTP状态码，以逗号间隔，支持区间，如 200,204 或 200-299
+	Status string
+	// Body 响应体需包含的内容，为空时不检查
+	Body    string
+	FailNum int // 连续失败次数达到该值时摘除节点
+	SuccNum int // 被摘除的节点连续成功次数达到该值时恢复
+}
+
+func (s CheckSetting) withDefault() CheckSetting {
+	if s.Timeout <= 0 {
+		s.Timeout = DefaultCheckTimeout
+	}
+	if s.Interval <= 0 {
+		s.Interval = DefaultCheckInterval
+	}
+	if s.FailNum <= 0 {
+		s.FailNum = DefaultCheckMaxErrNum
+	}
+	if s.SuccNum <= 0 {
+		s.SuccNum = DefaultCheckSuccNum
+	}
+	if s.Method == CheckMethodHTTP {
+		if s.Path == "" {
+			s.Path = DefaultCheckPath
+		}
+		if s.Status == "" {
+			s.Status = DefaultCheckStatus
+		}
+	}
+	return s
+}
+
+func IsValidCheckMethod(method int) bool {
+	switch method {
+	case CheckMethodTCP, CheckMethodHTTP, CheckMethodGRPC:
+		return true
+	}
+	return false
+}
+
+// HealthChecker 对单个节点执行一次探活
+type HealthChecker interface {
+	Check(addr string) error
+}
+
+func NewHealthChecker(format string, setting CheckSetting) (HealthChecker, error) {
+	setting = setting.withDefault()
+	timeout := time.Duration(setting.Timeout) * time.Second
+	switch setting.Method {
+	case CheckMethodTCP:
+		return &TCPChecker{Timeout: timeout}, nil
+	case CheckMethodHTTP:
+		status, err := ParseCheckStatus(setting.Status)
+		if err != nil {
+			return nil, err
+		}
+		scheme := "http://"
+		if strings.HasPrefix(format, "https://") {
+			scheme = "https://"
+		}
+		path := setting.Path
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		return &HTTPChecker{
+			Timeout: timeout,
+			Scheme:  scheme,
+			Path:    path,
+			Status:  status,
+			Body:    setting.Body,
+			client: &http.Client{
+				Timeout: timeout,
+				// 探活只关心节点本身的响应，不跟随跳转
+				CheckRedirect: func(req *http.Request, via []*http.Request) error {
+					return http.ErrUseLastResponse
+				},
+			},
+		}, nil
+	case CheckMethodGRPC:
+		return &GrpcChecker{Timeout: timeout, Service: setting.Path}, nil
+	}
+	return nil, fmt.Errorf("check method %d not supported", setting.Method)
+}
+
+type TCPChecker struct {
+	Timeout time.Duration
+}
+
+func (c *TCPChecker) Check(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, c.Timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+type HTTPChecker struct {
+	Timeout time.Duration
+	Scheme  string
+	Path    string
+	Status  []CheckStatusRange
+	Body    string
+
+	client *http.Client
+}
+
+func (c *HTTPChecker) Check(addr string) error {
+	client := c.client
+	if client == nil {
+		client = &http.Client{Timeout: c.Timeout}
+	}
+	resp, err := client.Get(c.Scheme + addr + c.Path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if !c.matchStatus(resp.StatusCode) {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, checkBodyLimit))
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	if c.Body == "" {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, checkBodyLimit))
+		return nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, checkBodyLimit))
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(body), c.Body) {
+		return errors.New("response body not match")
+	}
+	return nil
+}
+
+func (c *HTTPChecker) matchStatus(code int) bool {
+	for _, item := range c.Status {
+		if code >= item.Min && code <= item.Max {
+			return true
+		}
+	}
+	return false
+}
+
+type GrpcChecker struct {
+	Timeout time.Duration
+	Service string
+}
+
+func (c *GrpcChecker) Check(addr string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	resp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: c.Service})
+	if err != nil {
+		return err
+	}
+	if resp.GetStatus() != grpc_health_v1.HealthCheckResponse_SERVING {
+		return fmt.Errorf("health status %s", resp.GetStatus())
+	}
+	return nil
+}
+
+type CheckStatusRange struct {
+	Min int
+	Max int
+}
+
+// ParseCheckStatus 解析期望的状态码，如 200,204 或 200-299
+func ParseCheckStatus(status string) ([]CheckStatusRange, error) {
+	if strings.TrimSpace(status) == "" {
+		status = DefaultCheckStatus
+	}
+	out := []CheckStatusRange{}
+	for _, item := range strings.Split(status, ",") {
+		item = strings.TrimSpace(item)
+		bounds := strings.SplitN(item, "-", 2)
+		min, err := parseStatusCode(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		max := min
+		if len(bounds) == 2 {
+			if max, err = parseStatusCode(bounds[1]); err != nil {
+				return nil, err
+			}
+		}
+		if min > max {
+			return nil, fmt.Errorf("invalid status range %s", item)
+		}
+		out = append(out, CheckStatusRange{Min: min, Max: max})
+	}
+	return out, nil
+}
+
+func parseStatusCode(code string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(code))
+	if err != nil || n < 100 || n > 599 {
+		return 0, fmt.Errorf("invalid status code %s", code)
+	}
+	return n, nil
+}
@@ -0,0 +1,139 @@
+package loadbalance
+
+import (
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseCheckStatus(t *testing.T) {
+	status, err := ParseCheckStatus("200, 204,300-399")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checker := &HTTPChecker{Status: status}
+	for code, expect := range map[int]bool{200: true, 204: true, 201: false, 302: true, 404: false} {
+		if checker.matchStatus(code) != expect {
+			t.Fatalf("status %d expect %v", code, expect)
+		}
+	}
+	for _, item := range []string{"abc", "99", "300-200", "200-"} {
+		if _, err := ParseCheckStatus(item); err == nil {
+			t.Fatalf("expect %s invalid", item)
+		}
+	}
+}
+
+func TestHTTPChecker(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			w.Write([]byte(`{"status":"ok"}`))
+		case "/created":
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+	addr := strings.TrimPrefix(ts.URL, "http://")
+
+	cases := []struct {
+		setting CheckSetting
+		healthy bool
+	}{
+		{CheckSetting{Method: CheckMethodHTTP, Path: "/health"}, true},
+		{CheckSetting{Method: CheckMethodHTTP, Path: "health", Body: `"ok"`}, true},
+		{CheckSetting{Method: CheckMethodHTTP, Path: "/health", Body: "fail"}, false},
+		{CheckSetting{Method: CheckMethodHTTP, Path: "/down"}, false},
+		{CheckSetting{Method: CheckMethodHTTP, Path: "/created", Status: "200"}, false},
+		{CheckSetting{Method: CheckMethodHTTP, Path: "/created", Status: "200,201"}, true},
+	}
+	for _, item := range cases {
+		checker, err := NewHealthChecker("http://%s", item.setting)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := checker.Check(addr); (err == nil) != item.healthy {
+			t.Fatalf("%+v expect healthy=%v, err=%v", item.setting, item.healthy, err)
+		}
+	}
+}
+
+func TestGrpcChecker(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	hs := health.NewServer()
+	hs.SetServingStatus("echo", grpc_health_v1.HealthCheckResponse_SERVING)
+	hs.SetServingStatus("down", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	grpc_health_v1.RegisterHealthServer(s, hs)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	for service, healthy := range map[string]bool{"": true, "echo": true, "down": false, "unknown": false} {
+		checker, _ := NewHealthChecker("%s", CheckSetting{Method: CheckMethodGRPC, Timeout: 1, Path: service})
+		if err := checker.Check(lis.Addr().String()); (err == nil) != healthy {
+			t.Fatalf("service %q expect healthy=%v, err=%v", service, healthy, err)
+		}
+	}
+}
+
+type testChecker struct {
+	mux  sync.Mutex
+	down map[string]bool
+}
+
+func (c *testChecker) Check(addr string) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.down[addr] {
+		return errors.New("down")
+	}
+	return nil
+}
+
+func (c *testChecker) set(addr string, down bool) {
+	c.mux.Lock()
+	c.down[addr] = down
+	c.mux.Unlock()
+}
+
+func TestCheckConfThreshold(t *testing.T) {
+	checker := &testChecker{down: map[string]bool{}}
+	mConf := newTestCheckConf("127.0.0.1:2003", "127.0.0.1:2004")
+	mConf.checker = checker
+	// 逐轮驱动探测，不依赖探活协程的计时
+	counter := newCheckCounter(CheckSetting{Interval: 1, FailNum: 2, SuccNum: 2}.withDefault())
+
+	checker.set("127.0.0.1:2003", true)
+	// 第一轮失败未达到阈值
+	mConf.checkOnce(counter)
+	if len(mConf.GetConf()) != 2 {
+		t.Fatalf("expect 2 nodes, got %v", mConf.GetConf())
+	}
+	// 第二轮失败后摘除
+	mConf.checkOnce(counter)
+	if conf := mConf.GetConf(); len(conf) != 1 || conf[0] != "http://127.0.0.1:2004,50" {
+		t.Fatalf("expect 2003 removed, got %v", conf)
+	}
+	// 恢复后需连续成功两轮
+	checker.set("127.0.0.1:2003", false)
+	mConf.checkOnce(counter)
+	if len(mConf.GetConf()) != 1 {
+		t.Fatalf("expect still removed, got %v", mConf.GetConf())
+	}
+	mConf.checkOnce(counter)
+	if len(mConf.GetConf()) != 2 {
+		t.Fatalf("expect recovered, got %v", mConf.GetConf())
+	}
+}
@@ -1,5 +1,10 @@
 package loadbalance
 
+import (
+	"sync"
+	"time"
+)
+
 type LoadBalance interface {
 	Add(...string) error
 	Get(string) (string, error)
@@ -7,3 +12,77 @@ type LoadBalance interface {
 	//后期服务发现补充
 	Update()
 }
+
+// ConnTracker 感知节点进行中请求数的负载均衡器实现此接口
+// 代理选中节点开始请求时调用Acquire，HTTP响应结束、TCP连接关闭、gRPC流结束时调用Release
+type ConnTracker interface {
+	Acquire(addr string)
+	Release(addr string)
+}
+
+// TrackConn 负载均衡器及其观察的配置主体实现ConnTracker时登记一次请求，返回的释放函数可重复调用
+func TrackConn(lb LoadBalance, addr string) func() {
+	trackers := []ConnTracker{}
+	if tracker, ok := lb.(ConnTracker); ok {
+		trackers = append(trackers, tracker)
+	}
+	if holder, ok := lb.(confHolder); ok {
+		if tracker, ok := holder.GetLoadBalanceConf().(ConnTracker); ok {
+			trackers = append(trackers, tracker)
+		}
+	}
+	if len(trackers) == 0 {
+		return func() {}
+	}
+	for _, tracker := range trackers {
+		tracker.Acquire(addr)
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			for _, tracker := range trackers {
+				tracker.Release(addr)
+			}
+		})
+	}
+}
+
+// LatencyTracker 感知节点响应耗时的负载均衡器实现此接口
+// HTTP代理在收到响应头、TCP代理在完成拨号、gRPC代理在收到响应头时上报耗时
+type LatencyTracker interface {
+	Observe(addr string, rtt time.Duration)
+}
+
+// ObserveLatency 将耗时上报给负载均衡器及其观察的配置主体
+func ObserveLatency(lb LoadBalance, addr string, rtt time.Duration) {
+	if tracker, ok := lb.(LatencyTracker); ok {
+		tracker.Observe(addr, rtt)
+	}
+	if holder, ok := lb.(confHolder); ok {
+		if tracker, ok := holder.GetLoadBalanceConf().(LatencyTracker); ok {
+			tracker.Observe(addr, rtt)
+		}
+	}
+}
+
+// ResultReporter 被动健康检查，代理上报真实请求的结果
+// HTTP响应5xx、TCP拨号失败、gRPC返回Unavailable视为失败
+type ResultReporter interface {
+	ReportResult(addr string, success bool)
+}
+
+type confHolder interface {
+	GetLoadBalanceConf() LoadBalanceConf
+}
+
+// ReportResult 将请求结果上报给负载均衡器及其观察的配置主体
+func ReportResult(lb LoadBalance, addr string, success bool) {
+	if reporter, ok := lb.(ResultReporter); ok {
+		reporter.ReportResult(addr, success)
+	}
+	if holder, ok := lb.(confHolder); ok {
+		if reporter, ok := holder.GetLoadBalanceConf().(ResultReporter); ok {
+			reporter.ReportResult(addr, success)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package loadbalance
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// LeastConnBalance 最少连接数负载均衡，选择 进行中的请求数/权重 最小的节点
+// 适合gRPC长连接、TCP会话等耗时差异大的场景，需要代理通过ConnTracker上报连接的建立与释放
+type LeastConnBalance struct {
+	mux      sync.RWMutex
+	curIndex int
+	rss      []*LeastConnNode
+	// 节点进行中的连接数，配置更新时保留，节点被摘除后恢复可继续沿用
+	conns map[string]*int64
+
+	//观察主体
+	conf LoadBalanceConf
+}
+
+type LeastConnNode struct {
+	addr   string
+	weight int64 //权重值
+	conns  *int64
+}
+
+func NewLeastConnBalance() *LeastConnBalance {
+	return &LeastConnBalance{
+		conns: map[string]*int64{},
+	}
+}
+
+// Add 参数为节点地址及可选的权重，权重默认为1
+func (r *LeastConnBalance) Add(params ...string) error {
+	weight, err := nodeWeight(params...)
+	if err != nil {
+		return err
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.rss = append(r.rss, &LeastConnNode{addr: params[0], weight: weight, conns: r.counter(params[0])})
+	return nil
+}
+
+// nodeWeight 解析节点的可选权重，权重默认为1
+func nodeWeight(params ...string) (int64, error) {
+	if len(params) == 0 {
+		return 0, errors.New("param len 1 at least")
+	}
+	weight := int64(1)
+	if len(params) > 1 {
+		parInt, err := strconv.ParseInt(params[1], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		if parInt > 0 {
+			weight = parInt
+		}
+	}
+	return weight, nil
+}
+
+// counter 获取节点的连接计数，调用方需持有写锁
+func (r *LeastConnBalance) counter(addr string) *int64 {
+	if r.conns == nil {
+		r.conns = map[string]*int64{}
+	}
+	conns, ok := r.conns[addr]
+	if !ok {
+		conns = new(int64)
+		r.conns[addr] = conns
+	}
+	return conns
+}
+
+func (r *LeastConnBalance) Next() string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	lens := len(r.rss)
+	if lens == 0 {
+		return ""
+	}
+	// 从上次选中节点的下一个开始比较，连接数相同时轮流选择
+	var best *LeastConnNode
+	var bestConns int64
+	for i := 0; i < lens; i++ {
+		node := r.rss[(r.curIndex+i)%lens]
+		conns := atomic.LoadInt64(node.conns)
+		// conns/weight < bestConns/best.weight
+		if best == nil || conns*best.weight < bestConns*node.weight {
+			best = node
+			bestConns = conns
+		}
+	}
+	r.curIndex = (r.curIndex + 1) % lens
+	return best.addr
+}
+
+func (r *LeastConnBalance) Get(key string) (string, error) {
+	addr := r.Next()
+	if addr == "" {
+		return "", errors.New("node is empty")
+	}
+	return addr, nil
+}
+
+// Acquire 代理选中节点并开始请求时调用
+func (r *LeastConnBalance) Acquire(addr string) {
+	r.mux.RLock()
+	conns, ok := r.conns[addr]
+	r.mux.RUnlock()
+	if ok {
+		atomic.AddInt64(conns, 1)
+	}
+}
+
+// Release 请求结束或连接关闭时调用
+func (r *LeastConnBalance) Release(addr string) {
+	r.mux.RLock()
+	conns, ok := r.conns[addr]
+	r.mux.RUnlock()
+	if ok {
+		atomic.AddInt64(conns, -1)
+	}
+}
+
+// Conns 节点当前进行中的连接数
+func (r *LeastConnBalance) Conns(addr string) int64 {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	conns, ok := r.conns[addr]
+	if !ok {
+		return 0
+	}
+	return atomic.LoadInt64(conns)
+}
+
+func (r *LeastConnBalance) SetConf(conf LoadBalanceConf) {
+	r.conf = conf
+}
+
+func (r *LeastConnBalance) GetLoadBalanceConf() LoadBalanceConf {
+	return r.conf
+}
+
+func (r *LeastConnBalance) Update() {
+	if conf, ok := r.conf.(*LoadBalanceCheckConf); ok {
+		items := conf.GetConf()
+		// 持锁整体替换节点列表，避免更新期间请求取不到节点
+		r.mux.Lock()
+		defer r.mux.Unlock()
+		nodes := make([]*LeastConnNode, 0, len(items))
+		for _, ip := range items {
+			params := strings.Split(ip, ",")
+			weight, err := nodeWeight(params...)
+			if err != nil {
+				continue
+			}
+			nodes = append(nodes, &LeastConnNode{addr: params[0], weight: weight, conns: r.counter(params[0])})
+		}
+		r.rss = nodes
+		r.curIndex = 0
+	}
+}
@@ -0,0 +1,93 @@
+package loadbalance
+
+import (
+	"testing"
+)
+
+func TestLeastConnBalance(t *testing.T) {
+	rb := NewLeastConnBalance()
+	rb.Add("127.0.0.1:2003")
+	rb.Add("127.0.0.1:2004")
+	rb.Add("127.0.0.1:2005")
+
+	// 连接未释放时依次选择连接数最少的节点
+	picked := map[string]int{}
+	for i := 0; i < 3; i++ {
+		addr, err := rb.Get("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rb.Acquire(addr)
+		picked[addr]++
+	}
+	if len(picked) != 3 {
+		t.Fatalf("expect 3 nodes picked, got %v", picked)
+	}
+
+	// 释放一个节点的连接后，该节点连接数最少
+	rb.Release("127.0.0.1:2004")
+	for i := 0; i < 3; i++ {
+		if addr, _ := rb.Get(""); addr != "127.0.0.1:2004" {
+			t.Fatalf("expect 127.0.0.1:2004, got %s", addr)
+		}
+	}
+}
+
+func TestLeastConnBalanceWeight(t *testing.T) {
+	rb := NewLeastConnBalance()
+	rb.Add("127.0.0.1:2003", "3")
+	rb.Add("127.0.0.1:2004", "1")
+
+	picked := map[string]int{}
+	for i := 0; i < 8; i++ {
+		addr, _ := rb.Get("")
+		rb.Acquire(addr)
+		picked[addr]++
+	}
+	if picked["127.0.0.1:2003"] != 6 || picked["127.0.0.1:2004"] != 2 {
+		t.Fatalf("expect 6:2, got %v", picked)
+	}
+}
+
+func TestLeastConnBalanceUpdate(t *testing.T) {
+	mConf := &LoadBalanceCheckConf{
+		format:       "%s",
+		activeList:   []string{"127.0.0.1:2003", "127.0.0.1:2004"},
+		confIpWeight: map[string]string{"127.0.0.1:2003": "50", "127.0.0.1:2004": "50"},
+		closeChan:    make(chan bool),
+	}
+	rb := LoadBanlanceFactorWithConf(LbLeastConn, mConf).(*LeastConnBalance)
+	rb.Acquire("127.0.0.1:2003")
+
+	// 配置更新后连接数保留
+	mConf.UpdateConf([]string{"127.0.0.1:2003", "127.0.0.1:2004"})
+	if conns := rb.Conns("127.0.0.1:2003"); conns != 1 {
+		t.Fatalf("expect 1 conn after update, got %d", conns)
+	}
+	if addr, _ := rb.Get(""); addr != "127.0.0.1:2004" {
+		t.Fatalf("expect 127.0.0.1:2004, got %s", addr)
+	}
+}
+
+func TestLeastConnBalanceUpdateNoGap(t *testing.T) {
+	mConf := newTestCheckConf("127.0.0.1:2003", "127.0.0.1:2004")
+	rb := LoadBanlanceFactorWithConf(LbLeastConn, mConf)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			mConf.UpdateConf([]string{"127.0.0.1:2003", "127.0.0.1:2004"})
+		}
+	}()
+	// 更新期间始终能取到节点
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if _, err := rb.Get(""); err != nil {
+			t.Fatalf("expect node during update, got %v", err)
+		}
+	}
+}
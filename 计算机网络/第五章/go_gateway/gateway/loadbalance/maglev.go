@@ -0,0 +1,182 @@
+package loadbalance
+
+import (
+	"errors"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultMaglevTableSize 查找表大小，需为质数且远大于节点数
+const DefaultMaglevTableSize = 65537
+
+// MaglevBalance Maglev一致性哈希，按节点的偏移量和步长轮流填充查找表，
+// 相比哈希环分布更均匀，节点增减时只有少量key改变映射
+type MaglevBalance struct {
+	mux       sync.RWMutex
+	tableSize uint64
+	table     []string //查找表，下标为key的哈希值取模
+	nodes     []*maglevNode
+
+	// 按服务设置从请求中选取哈希key
+	option LoadBalanceOption
+
+	//观察主体
+	conf LoadBalanceConf
+}
+
+type maglevNode struct {
+	addr   string
+	weight int64
+	offset uint64
+	skip   uint64
+}
+
+func NewMaglevBalance(tableSize int) *MaglevBalance {
+	if tableSize <= 0 {
+		tableSize = DefaultMaglevTableSize
+	}
+	return &MaglevBalance{tableSize: uint64(tableSize)}
+}
+
+// NewMaglevBalanceWithOption 按服务设置的哈希key创建
+func NewMaglevBalanceWithOption(option LoadBalanceOption) *MaglevBalance {
+	m := NewMaglevBalance(DefaultMaglevTableSize)
+	m.option = option
+	return m
+}
+
+// Add 参数为节点地址及可选的权重，权重默认为1，添加后重建查找表
+func (m *MaglevBalance) Add(params ...string) error {
+	if len(params) == 0 {
+		return errors.New("param len 1 at least")
+	}
+	node, err := m.newNode(params...)
+	if err != nil {
+		return err
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	for i, item := range m.nodes {
+		if item.addr == node.addr {
+			m.nodes[i] = node
+			m.populate()
+			return nil
+		}
+	}
+	m.nodes = append(m.nodes, node)
+	m.populate()
+	return nil
+}
+
+func (m *MaglevBalance) newNode(params ...string) (*maglevNode, error) {
+	weight := int64(1)
+	if len(params) > 1 {
+		parInt, err := strconv.ParseInt(params[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if parInt > 0 {
+			weight = parInt
+		}
+	}
+	return &maglevNode{
+		addr:   params[0],
+		weight: weight,
+		offset: maglevHash(params[0], "offset") % m.tableSize,
+		skip:   maglevHash(params[0], "skip")%(m.tableSize-1) + 1,
+	}, nil
+}
+
+// populate 填充查找表，每个节点按 (offset + skip*j) % M 的顺序抢占空位，
+// 权重按轮次控制：权重为最大权重1/n的节点每n轮才抢占一次，调用方需持有mux
+func (m *MaglevBalance) populate() {
+	if len(m.nodes) == 0 {
+		m.table = nil
+		return
+	}
+	// 按地址排序，保证节点顺序不同的网关实例得到相同的查找表
+	sort.Slice(m.nodes, func(i, j int) bool {
+		return m.nodes[i].addr < m.nodes[j].addr
+	})
+	maxWeight := int64(0)
+	for _, node := range m.nodes {
+		if node.weight > maxWeight {
+			maxWeight = node.weight
+		}
+	}
+	table := make([]string, m.tableSize)
+	filled := make([]bool, m.tableSize)
+	next := make([]uint64, len(m.nodes))
+	target := make([]int64, len(m.nodes))
+	count := uint64(0)
+	for round := int64(1); count < m.tableSize; round++ {
+		for i, node := range m.nodes {
+			if count >= m.tableSize {
+				break
+			}
+			if round*node.weight < target[i] {
+				continue
+			}
+			target[i] += maxWeight
+			c := (node.offset + node.skip*next[i]) % m.tableSize
+			for filled[c] {
+				next[i]++
+				c = (node.offset + node.skip*next[i]) % m.tableSize
+			}
+			table[c] = node.addr
+			filled[c] = true
+			next[i]++
+			count++
+		}
+	}
+	m.table = table
+}
+
+func (m *MaglevBalance) Get(key string) (string, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	if len(m.table) == 0 {
+		return "", errors.New("node is empty")
+	}
+	return m.table[maglevHash(key, "")%m.tableSize], nil
+}
+
+func maglevHash(key string, seed string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte(seed))
+	return h.Sum64()
+}
+
+func (m *MaglevBalance) HashKeyOption() LoadBalanceOption {
+	return m.option
+}
+
+func (m *MaglevBalance) SetConf(conf LoadBalanceConf) {
+	m.conf = conf
+}
+
+func (m *MaglevBalance) GetLoadBalanceConf() LoadBalanceConf {
+	return m.conf
+}
+
+func (m *MaglevBalance) Update() {
+	if conf, ok := m.conf.(*LoadBalanceCheckConf); ok {
+		nodes := []*maglevNode{}
+		for _, ip := range conf.GetConf() {
+			node, err := m.newNode(strings.Split(ip, ",")...)
+			if err != nil {
+				continue
+			}
+			nodes = append(nodes, node)
+		}
+		// 持锁重建查找表，避免重建期间请求取不到节点
+		m.mux.Lock()
+		defer m.mux.Unlock()
+		m.nodes = nodes
+		m.populate()
+	}
+}
@@ -0,0 +1,167 @@
+package loadbalance
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func maglevNodes(n int) []string {
+	nodes := []string{}
+	for i := 0; i < n; i++ {
+		nodes = append(nodes, fmt.Sprintf("127.0.0.1:%d", 2003+i))
+	}
+	return nodes
+}
+
+func maglevKeys(n int) []string {
+	keys := []string{}
+	for i := 0; i < n; i++ {
+		keys = append(keys, "http://127.0.0.1:2002/base/getinfo?uid="+strconv.Itoa(i))
+	}
+	return keys
+}
+
+// remapped 节点变化前后映射发生变化的key比例
+func remapped(before, after LoadBalance, keys []string) float64 {
+	moved := 0
+	for _, key := range keys {
+		a, _ := before.Get(key)
+		b, _ := after.Get(key)
+		if a != b {
+			moved++
+		}
+	}
+	return float64(moved) / float64(len(keys))
+}
+
+func TestMaglevBalance(t *testing.T) {
+	rb := NewMaglevBalance(0)
+	if _, err := rb.Get("key"); err == nil {
+		t.Fatal("expect node is empty")
+	}
+	for _, node := range maglevNodes(5) {
+		rb.Add(node)
+	}
+	// 同一个key始终映射到同一节点
+	for _, key := range maglevKeys(100) {
+		a, _ := rb.Get(key)
+		b, _ := rb.Get(key)
+		if a != b {
+			t.Fatalf("key %s mapped to %s and %s", key, a, b)
+		}
+	}
+}
+
+func TestMaglevBalanceUniform(t *testing.T) {
+	nodes := maglevNodes(10)
+	rb := NewMaglevBalance(0)
+	for _, node := range nodes {
+		rb.Add(node)
+	}
+	// 查找表中每个节点的占比
+	counts := map[string]int{}
+	for _, addr := range rb.table {
+		counts[addr]++
+	}
+	avg := float64(len(rb.table)) / float64(len(nodes))
+	for _, node := range nodes {
+		if dev := float64(counts[node])/avg - 1; dev > 0.01 || dev < -0.01 {
+			t.Fatalf("node %s table entries %d, avg %.0f", node, counts[node], avg)
+		}
+	}
+
+	// 带权重时按权重比例分配
+	wb := NewMaglevBalance(0)
+	wb.Add("127.0.0.1:2003", "100")
+	wb.Add("127.0.0.1:2004", "50")
+	wb.Add("127.0.0.1:2005", "50")
+	counts = map[string]int{}
+	for _, addr := range wb.table {
+		counts[addr]++
+	}
+	if ratio := float64(counts["127.0.0.1:2003"]) / float64(counts["127.0.0.1:2004"]); ratio < 1.95 || ratio > 2.05 {
+		t.Fatalf("unexpected weight ratio %.2f", ratio)
+	}
+}
+
+func TestMaglevBalanceDisruption(t *testing.T) {
+	nodes := maglevNodes(10)
+	keys := maglevKeys(20000)
+	build := func(nodes []string) *MaglevBalance {
+		rb := NewMaglevBalance(0)
+		for _, node := range nodes {
+			rb.Add(node)
+		}
+		return rb
+	}
+	full := build(nodes)
+
+	// 摘除一个节点：理想情况下只有该节点上的1/10的key改变映射
+	removed := build(append(append([]string{}, nodes[:3]...), nodes[4:]...))
+	for _, key := range keys {
+		if after, _ := removed.Get(key); after == nodes[3] {
+			t.Fatalf("key %s mapped to removed node", key)
+		}
+	}
+	rate := remapped(full, removed, keys)
+	fmt.Printf("maglev remove 1/10 node, remapped %.4f\n", rate)
+	if rate > 0.1*1.3 {
+		t.Fatalf("remove node remapped %.4f", rate)
+	}
+
+	// 增加一个节点：理想情况下1/11的key迁移到新节点
+	added := build(maglevNodes(11))
+	rate = remapped(full, added, keys)
+	fmt.Printf("maglev add 1/11 node, remapped %.4f\n", rate)
+	if rate > 1.0/11*1.3 {
+		t.Fatalf("add node remapped %.4f", rate)
+	}
+
+	// 节点恢复后映射还原
+	if rate := remapped(full, build(nodes), keys); rate != 0 {
+		t.Fatalf("restore node remapped %.4f", rate)
+	}
+
+	// 节点顺序不影响查找表
+	reversed := []string{}
+	for i := len(nodes) - 1; i >= 0; i-- {
+		reversed = append(reversed, nodes[i])
+	}
+	if rate := remapped(full, build(reversed), keys); rate != 0 {
+		t.Fatalf("reorder node remapped %.4f", rate)
+	}
+
+	// 哈希环作为对比
+	ring := NewConsistentHashBanlance(10, nil)
+	ringRemoved := NewConsistentHashBanlance(10, nil)
+	for i, node := range nodes {
+		ring.Add(node)
+		if i != 3 {
+			ringRemoved.Add(node)
+		}
+	}
+	fmt.Printf("consistent hash remove 1/10 node, remapped %.4f\n", remapped(ring, ringRemoved, keys))
+}
+
+func TestMaglevBalanceWithConf(t *testing.T) {
+	mConf := newTestCheckConf(maglevNodes(4)...)
+	rb := LoadBanlanceFactorWithConf(LbMaglev, mConf)
+	addr, err := rb.Get("hot_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 探活摘除节点后不再选中
+	ips := []string{}
+	for _, node := range maglevNodes(4) {
+		if "http://"+node != addr {
+			ips = append(ips, node)
+		}
+	}
+	mConf.UpdateConf(ips)
+	for _, key := range maglevKeys(1000) {
+		if next, _ := rb.Get(key); next == addr {
+			t.Fatalf("removed node %s picked", addr)
+		}
+	}
+}
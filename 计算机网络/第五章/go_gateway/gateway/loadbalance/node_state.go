@@ -0,0 +1,110 @@
+package loadbalance
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	NodeStateActive    = "active"
+	NodeStateForbidden = "forbidden" // 在禁用列表中，不参与负载均衡及探活
+	NodeStateDraining  = "draining"  // 不再分配新请求，进行中的TCP连接、gRPC流继续完成
+	NodeStateUnhealthy = "unhealthy" // 主动探活失败
+	NodeStateEjected   = "ejected"   // 被动健康检查摘除
+)
+
+// NodeConnStat 服务各节点进行中的请求、连接数
+// 服务配置变更时负载均衡器会重建，统计需跨实例保留，以便观察排空中的节点何时归零
+type NodeConnStat struct {
+	mux   sync.RWMutex
+	conns map[string]*int64
+}
+
+func NewNodeConnStat() *NodeConnStat {
+	return &NodeConnStat{conns: map[string]*int64{}}
+}
+
+func (s *NodeConnStat) counter(ip string) *int64 {
+	s.mux.RLock()
+	counter, ok := s.conns[ip]
+	s.mux.RUnlock()
+	if ok {
+		return counter
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if counter, ok = s.conns[ip]; !ok {
+		counter = new(int64)
+		s.conns[ip] = counter
+	}
+	return counter
+}
+
+func (s *NodeConnStat) Acquire(ip string) {
+	atomic.AddInt64(s.counter(ip), 1)
+}
+
+func (s *NodeConnStat) Release(ip string) {
+	atomic.AddInt64(s.counter(ip), -1)
+}
+
+// Conns 节点当前进行中的请求、连接数
+func (s *NodeConnStat) Conns(ip string) int64 {
+	s.mux.RLock()
+	counter, ok := s.conns[ip]
+	s.mux.RUnlock()
+	if !ok {
+		return 0
+	}
+	if conns := atomic.LoadInt64(counter); conns > 0 {
+		return conns
+	}
+	return 0
+}
+
+// NodeState 节点状态及进行中的连接数
+type NodeState struct {
+	Addr   string `json:"addr"`
+	Weight string `json:"weight"`
+	State  string `json:"state"`
+	Conns  int64  `json:"conns"`
+	// Circuit 熔断状态，未启用熔断时为空
+	Circuit string `json:"circuit,omitempty"`
+}
+
+// NodeStates 配置中全部节点的状态，按地址排序
+func (s *LoadBalanceCheckConf) NodeStates() []*NodeState {
+	s.mux.Lock()
+	states := []*NodeState{}
+	for ip, weight := range s.confIpWeight {
+		state := NodeStateActive
+		if _, ejected := s.ejectedUntil[ip]; ejected {
+			state = NodeStateEjected
+		}
+		if s.downList[ip] {
+			state = NodeStateUnhealthy
+		}
+		if s.drainList[ip] {
+			state = NodeStateDraining
+		}
+		if s.forbidList[ip] {
+			state = NodeStateForbidden
+		}
+		states = append(states, &NodeState{Addr: ip, Weight: weight, State: state})
+	}
+	circuit := s.circuit
+	s.mux.Unlock()
+	for _, item := range states {
+		if s.connStat != nil {
+			item.Conns = s.connStat.Conns(item.Addr)
+		}
+		if circuit != nil {
+			item.Circuit = circuit.State(item.Addr)
+		}
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Addr < states[j].Addr
+	})
+	return states
+}
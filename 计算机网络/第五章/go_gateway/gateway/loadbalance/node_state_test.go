@@ -0,0 +1,67 @@
+package loadbalance
+
+import (
+	"testing"
+)
+
+func TestCheckConfForbidAndDrain(t *testing.T) {
+	mConf := newTestCheckConf("127.0.0.1:2003", "127.0.0.1:2004", "127.0.0.1:2005")
+	mConf.SetConnStat(NewNodeConnStat())
+	for _, lbType := range []LbType{LbRandom, LbRoundRobin, LbWeightRoundRobin, LbConsistentHash, LbLeastConn, LbP2C, LbMaglev} {
+		mConf.observers = nil
+		mConf.SetForbidList(nil)
+		mConf.SetDrainList(nil)
+		rb := LoadBanlanceFactorWithConf(lbType, mConf)
+
+		// 排空前建立的连接
+		release := TrackConn(rb, "http://127.0.0.1:2004")
+		mConf.SetForbidList([]string{"127.0.0.1:2003"})
+		mConf.SetDrainList([]string{"127.0.0.1:2004"})
+		for i := 0; i < 50; i++ {
+			addr, err := rb.Get(string(rune('a' + i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if addr != "http://127.0.0.1:2005" {
+				t.Fatalf("lb %d picked %s", lbType, addr)
+			}
+		}
+
+		states := map[string]*NodeState{}
+		for _, state := range mConf.NodeStates() {
+			states[state.Addr] = state
+		}
+		if states["127.0.0.1:2003"].State != NodeStateForbidden || states["127.0.0.1:2005"].State != NodeStateActive {
+			t.Fatalf("unexpected states %+v %+v", states["127.0.0.1:2003"], states["127.0.0.1:2005"])
+		}
+		if state := states["127.0.0.1:2004"]; state.State != NodeStateDraining || state.Conns != 1 {
+			t.Fatalf("unexpected drain state %+v", state)
+		}
+		// 已有连接结束后归零，重复释放不影响计数
+		release()
+		release()
+		if conns := mConf.connStat.Conns("127.0.0.1:2004"); conns != 0 {
+			t.Fatalf("expect drained, conns %d", conns)
+		}
+	}
+}
+
+func TestNodeConnStatSurviveRebuild(t *testing.T) {
+	stat := NewNodeConnStat()
+	oldConf := newTestCheckConf("127.0.0.1:2003")
+	oldConf.SetConnStat(stat)
+	oldLb := LoadBanlanceFactorWithConf(LbRoundRobin, oldConf)
+	release := TrackConn(oldLb, "http://127.0.0.1:2003")
+
+	// 配置变更后负载均衡器重建，旧连接仍计入同一统计
+	newConf := newTestCheckConf("127.0.0.1:2003")
+	newConf.SetConnStat(stat)
+	newConf.SetDrainList([]string{"127.0.0.1:2003"})
+	if states := newConf.NodeStates(); states[0].Conns != 1 || states[0].State != NodeStateDraining {
+		t.Fatalf("unexpected state %+v", states[0])
+	}
+	release()
+	if states := newConf.NodeStates(); states[0].Conns != 0 {
+		t.Fatalf("unexpected state %+v", states[0])
+	}
+}
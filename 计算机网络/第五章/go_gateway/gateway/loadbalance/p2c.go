@@ -0,0 +1,236 @@
+package loadbalance
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// p2cDecay EWMA衰减时间，距上次上报越久，历史耗时的权重越低
+	p2cDecay = 10 * time.Second
+	// p2cInitLatency 尚未上报耗时的节点使用的初始耗时
+	p2cInitLatency = 100 * time.Millisecond
+	// p2cForcePick 节点超过该时间未被选中时强制选择一次，避免历史耗时过高的节点一直得不到探测
+	p2cForcePick = 3 * time.Second
+)
+
+// P2CBalance 随机选取两个节点，比较 耗时EWMA*(进行中请求数+1)/权重，选择负载较低的节点
+// 需要代理通过ConnTracker上报请求的开始与结束，通过LatencyTracker上报耗时
+type P2CBalance struct {
+	mux sync.RWMutex
+	rss []*P2CNode
+	// 节点统计，配置更新时保留
+	nodes map[string]*P2CNode
+	rand  *rand.Rand
+	// rand.Rand非并发安全
+	randMux sync.Mutex
+
+	//观察主体
+	conf LoadBalanceConf
+}
+
+type P2CNode struct {
+	addr     string
+	weight   int64 //权重值
+	inflight int64 //进行中的请求数
+
+	mux      sync.Mutex
+	ewma     float64 //耗时EWMA，单位ns
+	stamp    time.Time
+	lastPick time.Time
+}
+
+func NewP2CBalance() *P2CBalance {
+	return &P2CBalance{
+		nodes: map[string]*P2CNode{},
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Add 参数为节点地址及可选的权重，权重默认为1
+func (p *P2CBalance) Add(params ...string) error {
+	weight, err := nodeWeight(params...)
+	if err != nil {
+		return err
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.rss = append(p.rss, p.statNode(params[0], weight))
+	return nil
+}
+
+// statNode 获取节点统计并更新权重，节点被摘除后恢复可继续沿用耗时与进行中请求数，调用方需持有写锁
+func (p *P2CBalance) statNode(addr string, weight int64) *P2CNode {
+	if p.nodes == nil {
+		p.nodes = map[string]*P2CNode{}
+	}
+	node, ok := p.nodes[addr]
+	if !ok {
+		node = &P2CNode{addr: addr, lastPick: time.Now()}
+		p.nodes[addr] = node
+	}
+	node.mux.Lock()
+	node.weight = weight
+	node.mux.Unlock()
+	return node
+}
+
+func (p *P2CBalance) Next() string {
+	p.mux.RLock()
+	rss := p.rss
+	p.mux.RUnlock()
+	var pick *P2CNode
+	switch len(rss) {
+	case 0:
+		return ""
+	case 1:
+		pick = rss[0]
+	default:
+		a, b := p.pickTwo(len(rss))
+		nodeA, nodeB := rss[a], rss[b]
+		if nodeA.load() > nodeB.load() {
+			nodeA, nodeB = nodeB, nodeA
+		}
+		pick = nodeA
+		// 负载较高的节点长时间未被选中时强制选择，以便更新其耗时
+		if nodeB.sincePick() > p2cForcePick {
+			pick = nodeB
+		}
+	}
+	pick.mux.Lock()
+	pick.lastPick = time.Now()
+	pick.mux.Unlock()
+	return pick.addr
+}
+
+// pickTwo 随机选取两个不同的下标
+func (p *P2CBalance) pickTwo(n int) (int, int) {
+	p.randMux.Lock()
+	defer p.randMux.Unlock()
+	if p.rand == nil {
+		p.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	a := p.rand.Intn(n)
+	b := p.rand.Intn(n - 1)
+	if b >= a {
+		b++
+	}
+	return a, b
+}
+
+func (p *P2CBalance) Get(key string) (string, error) {
+	addr := p.Next()
+	if addr == "" {
+		return "", errors.New("node is empty")
+	}
+	return addr, nil
+}
+
+func (p *P2CBalance) node(addr string) *P2CNode {
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+	return p.nodes[addr]
+}
+
+// Acquire 代理选中节点并开始请求时调用
+func (p *P2CBalance) Acquire(addr string) {
+	if node := p.node(addr); node != nil {
+		atomic.AddInt64(&node.inflight, 1)
+	}
+}
+
+// Release 请求结束时调用
+func (p *P2CBalance) Release(addr string) {
+	if node := p.node(addr); node != nil {
+		atomic.AddInt64(&node.inflight, -1)
+	}
+}
+
+// Observe 上报一次耗时，按距上次上报的时间衰减历史值
+func (p *P2CBalance) Observe(addr string, rtt time.Duration) {
+	node := p.node(addr)
+	if node == nil {
+		return
+	}
+	if rtt < 0 {
+		rtt = 0
+	}
+	now := time.Now()
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	if node.stamp.IsZero() {
+		node.ewma = float64(rtt)
+	} else {
+		w := math.Exp(-float64(now.Sub(node.stamp)) / float64(p2cDecay))
+		node.ewma = node.ewma*w + float64(rtt)*(1-w)
+	}
+	node.stamp = now
+}
+
+// Latency 节点当前的耗时EWMA
+func (p *P2CBalance) Latency(addr string) time.Duration {
+	node := p.node(addr)
+	if node == nil {
+		return 0
+	}
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	return time.Duration(node.ewma)
+}
+
+// load 节点负载：耗时EWMA*(进行中请求数+1)/权重
+func (n *P2CNode) load() float64 {
+	n.mux.Lock()
+	ewma, weight := n.ewma, n.weight
+	if n.stamp.IsZero() {
+		ewma = float64(p2cInitLatency)
+	}
+	n.mux.Unlock()
+	// 耗时为0时仍按进行中请求数比较
+	if ewma < 1 {
+		ewma = 1
+	}
+	inflight := atomic.LoadInt64(&n.inflight)
+	if inflight < 0 {
+		inflight = 0
+	}
+	return ewma * float64(inflight+1) / float64(weight)
+}
+
+func (n *P2CNode) sincePick() time.Duration {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	return time.Since(n.lastPick)
+}
+
+func (p *P2CBalance) SetConf(conf LoadBalanceConf) {
+	p.conf = conf
+}
+
+func (p *P2CBalance) GetLoadBalanceConf() LoadBalanceConf {
+	return p.conf
+}
+
+func (p *P2CBalance) Update() {
+	if conf, ok := p.conf.(*LoadBalanceCheckConf); ok {
+		items := conf.GetConf()
+		// 持锁整体替换节点列表，避免更新期间请求取不到节点
+		p.mux.Lock()
+		defer p.mux.Unlock()
+		rss := make([]*P2CNode, 0, len(items))
+		for _, ip := range items {
+			params := strings.Split(ip, ",")
+			weight, err := nodeWeight(params...)
+			if err != nil {
+				continue
+			}
+			rss = append(rss, p.statNode(params[0], weight))
+		}
+		p.rss = rss
+	}
+}
@@ -0,0 +1,117 @@
+package loadbalance
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestP2CBalanceLatency(t *testing.T) {
+	rb := NewP2CBalance()
+	rb.Add("127.0.0.1:2003")
+	rb.Add("127.0.0.1:2004")
+	rb.Add("127.0.0.1:2005")
+	rb.Observe("127.0.0.1:2003", 200*time.Millisecond)
+	rb.Observe("127.0.0.1:2004", 10*time.Millisecond)
+	rb.Observe("127.0.0.1:2005", 200*time.Millisecond)
+
+	// 2004耗时最低，参与比较时总会被选中，3个节点中抽中2004的概率为2/3
+	picked := map[string]int{}
+	for i := 0; i < 3000; i++ {
+		addr, err := rb.Get("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		picked[addr]++
+	}
+	if picked["127.0.0.1:2004"] < 1800 {
+		t.Fatalf("expect fast node picked most, got %v", picked)
+	}
+}
+
+func TestP2CBalanceInflight(t *testing.T) {
+	rb := NewP2CBalance()
+	rb.Add("127.0.0.1:2003")
+	rb.Add("127.0.0.1:2004")
+	rb.Observe("127.0.0.1:2003", 10*time.Millisecond)
+	rb.Observe("127.0.0.1:2004", 10*time.Millisecond)
+
+	// 耗时相同时选择进行中请求数少的节点
+	for i := 0; i < 5; i++ {
+		rb.Acquire("127.0.0.1:2003")
+	}
+	for i := 0; i < 10; i++ {
+		if addr, _ := rb.Get(""); addr != "127.0.0.1:2004" {
+			t.Fatalf("expect 127.0.0.1:2004, got %s", addr)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		rb.Release("127.0.0.1:2003")
+	}
+	rb.Acquire("127.0.0.1:2004")
+	if addr, _ := rb.Get(""); addr != "127.0.0.1:2003" {
+		t.Fatalf("expect 127.0.0.1:2003, got %s", addr)
+	}
+}
+
+func TestP2CBalanceEWMA(t *testing.T) {
+	rb := NewP2CBalance()
+	rb.Add("127.0.0.1:2003")
+	rb.Observe("127.0.0.1:2003", 100*time.Millisecond)
+	rb.Observe("127.0.0.1:2003", 10*time.Millisecond)
+	// 两次上报间隔极短，新值权重很小
+	if latency := rb.Latency("127.0.0.1:2003"); latency < 90*time.Millisecond {
+		t.Fatalf("expect ewma close to 100ms, got %v", latency)
+	}
+}
+
+func TestP2CBalanceUpdate(t *testing.T) {
+	mConf := &LoadBalanceCheckConf{
+		format:       "%s",
+		activeList:   []string{"127.0.0.1:2003", "127.0.0.1:2004"},
+		confIpWeight: map[string]string{"127.0.0.1:2003": "50", "127.0.0.1:2004": "50"},
+		closeChan:    make(chan bool),
+	}
+	rb := LoadBanlanceFactorWithConf(LbP2C, mConf).(*P2CBalance)
+	rb.Observe("127.0.0.1:2003", 50*time.Millisecond)
+
+	// 探活摘除节点后不再选中，统计数据保留
+	mConf.UpdateConf([]string{"127.0.0.1:2004"})
+	for i := 0; i < 10; i++ {
+		if addr, _ := rb.Get(""); addr != "127.0.0.1:2004" {
+			t.Fatalf("expect 127.0.0.1:2004, got %s", addr)
+		}
+	}
+	mConf.UpdateConf([]string{"127.0.0.1:2003", "127.0.0.1:2004"})
+	if latency := rb.Latency("127.0.0.1:2003"); latency != 50*time.Millisecond {
+		t.Fatalf("expect latency kept after update, got %v", latency)
+	}
+}
+
+func TestP2CBalanceUpdateNoGap(t *testing.T) {
+	mConf := newTestCheckConf("127.0.0.1:2003", "127.0.0.1:2004")
+	rb := LoadBanlanceFactorWithConf(LbP2C, mConf).(*P2CBalance)
+	rb.Acquire("http://127.0.0.1:2003")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			mConf.UpdateConf([]string{"127.0.0.1:2003", "127.0.0.1:2004"})
+		}
+	}()
+	// 更新期间始终能取到节点
+	for {
+		select {
+		case <-done:
+			// 进行中的请求数同样保留
+			if inflight := atomic.LoadInt64(&rb.node("http://127.0.0.1:2003").inflight); inflight != 1 {
+				t.Fatalf("expect inflight kept after update, got %d", inflight)
+			}
+			return
+		default:
+		}
+		if _, err := rb.Get(""); err != nil {
+			t.Fatalf("expect node during update, got %v", err)
+		}
+	}
+}
@@ -5,11 +5,15 @@ import (
 	"fmt"
 	"math/rand"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
+// RandomBalance 节点列表为只读快照，Next无锁，Add、Update复制后整体替换
 type RandomBalance struct {
-	curIndex int
-	rss      []string
+	rss atomic.Value // []string
+	// mux 串行化Add、Update
+	mux sync.Mutex
 	//观察主体
 	conf LoadBalanceConf
 }
@@ -19,16 +23,19 @@ func (r *RandomBalance) Add(params ...string) error {
 		return errors.New("param len 1 at least")
 	}
 	addr := params[0]
-	r.rss = append(r.rss, addr)
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	rss, _ := r.rss.Load().([]string)
+	r.rss.Store(append(append([]string{}, rss...), addr))
 	return nil
 }
 
 func (r *RandomBalance) Next() string {
-	if len(r.rss) == 0 {
+	rss, _ := r.rss.Load().([]string)
+	if len(rss) == 0 {
 		return ""
 	}
-	r.curIndex = rand.Intn(len(r.rss))
-	return r.rss[r.curIndex]
+	return rss[rand.Intn(len(rss))]
 }
 
 func (r *RandomBalance) Get(key string) (string, error) {
@@ -39,6 +46,10 @@ func (r *RandomBalance) SetConf(conf LoadBalanceConf) {
 	r.conf = conf
 }
 
+func (r *RandomBalance) GetLoadBalanceConf() LoadBalanceConf {
+	return r.conf
+}
+
 func (r *RandomBalance) Update() {
 	//if conf, ok := r.conf.(*LoadBalanceZkConf); ok {
 	//	fmt.Println("Update get zk conf:", conf.GetConf())
@@ -49,9 +60,12 @@ func (r *RandomBalance) Update() {
 	//}
 	if conf, ok := r.conf.(*LoadBalanceCheckConf); ok {
 		fmt.Println("Update get check conf:", conf.GetConf())
-		r.rss = nil
+		rss := []string{}
 		for _, ip := range conf.GetConf() {
-			r.Add(strings.Split(ip, ",")...)
+			rss = append(rss, strings.Split(ip, ",")[0])
 		}
+		r.mux.Lock()
+		defer r.mux.Unlock()
+		r.rss.Store(rss)
 	}
 }
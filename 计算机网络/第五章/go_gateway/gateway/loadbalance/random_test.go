@@ -23,3 +23,15 @@ func TestRandomBalance(t *testing.T) {
 	fmt.Println(rb.Next())
 	fmt.Println(rb.Next())
 }
+
+func BenchmarkRandomParallel(b *testing.B) {
+	rb := &RandomBalance{}
+	for i := 0; i < 10; i++ {
+		rb.Add(fmt.Sprintf("127.0.0.1:%d", 2003+i))
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rb.Next()
+		}
+	})
+}
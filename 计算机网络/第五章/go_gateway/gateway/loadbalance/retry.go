@@ -0,0 +1,150 @@
+package loadbalance
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RetryOnConnectError = "connect_error" // 连接下游失败，请求未发出
+	RetryOnTimeout      = "timeout"       // 单次尝试超时或读取响应头超时
+
+	DefaultRetryOn = "connect_error,timeout,502,503"
+	// DefaultRetryMaxBody 重试需缓存请求体，超过该大小的请求不重试
+	DefaultRetryMaxBody = 1 << 20
+)
+
+// ErrTryTimeout 单次尝试在超时时间内未收到响应头
+var ErrTryTimeout = errors.New("upstream try timeout")
+
+// RetryPolicy HTTP服务的重试策略，请求失败且满足重试条件时换一个未尝试过的节点重新发送
+type RetryPolicy struct {
+	// Attempts 最多尝试次数，含首次请求
+	Attempts int
+	// OnConnectError、OnTimeout、OnStatus 触发重试的条件
+	OnConnectError bool
+	OnTimeout      bool
+	OnStatus       map[int]bool
+	// NonIdempotent 非幂等方法(如POST)是否重试，默认只重试幂等方法
+	NonIdempotent bool
+	// TryTimeout 单次尝试等待响应头的超时，0表示不限制
+	TryTimeout time.Duration
+	// MaxBody 可缓存重放的请求体大小
+	MaxBody int64
+}
+
+// NewRetryPolicy attempts小于2时不重试返回nil，retryOn为空时使用DefaultRetryOn，tryTimeout单位s
+func NewRetryPolicy(attempts int, retryOn string, nonIdempotent bool, tryTimeout int) (*RetryPolicy, error) {
+	if attempts < 2 {
+		return nil, nil
+	}
+	if strings.TrimSpace(retryOn) == "" {
+		retryOn = DefaultRetryOn
+	}
+	policy := &RetryPolicy{
+		Attempts:      attempts,
+		OnStatus:      map[int]bool{},
+		NonIdempotent: nonIdempotent,
+		TryTimeout:    time.Duration(tryTimeout) * time.Second,
+		MaxBody:       DefaultRetryMaxBody,
+	}
+	for _, item := range strings.Split(retryOn, ",") {
+		switch item = strings.TrimSpace(item); item {
+		case RetryOnConnectError:
+			policy.OnConnectError = true
+		case RetryOnTimeout:
+			policy.OnTimeout = true
+		default:
+			code, err := parseStatusCode(item)
+			if err != nil {
+				return nil, fmt.Errorf("retry on %q not supported", item)
+			}
+			policy.OnStatus[code] = true
+		}
+	}
+	return policy, nil
+}
+
+// IsValidRetryOn 重试条件为connect_error、timeout或状态码，逗号分隔
+func IsValidRetryOn(retryOn string) bool {
+	_, err := NewRetryPolicy(2, retryOn, false, 0)
+	return err == nil
+}
+
+// Retryable 请求方法是否允许重试，协议升级请求不重试
+// 与net/http一致，带Idempotency-Key的请求视为幂等
+func (p *RetryPolicy) Retryable(req *http.Request) bool {
+	if req.Header.Get("Upgrade") != "" {
+		return false
+	}
+	if p.NonIdempotent {
+		return true
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	_, ok := req.Header["Idempotency-Key"]
+	if !ok {
+		_, ok = req.Header["X-Idempotency-Key"]
+	}
+	return ok
+}
+
+// RetryError 请求错误是否满足重试条件
+func (p *RetryPolicy) RetryError(err error) bool {
+	if p.OnTimeout {
+		if errors.Is(err, ErrTryTimeout) {
+			return true
+		}
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return true
+		}
+	}
+	if p.OnConnectError {
+		opErr := &net.OpError{}
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryStatus 响应状态码是否满足重试条件
+func (p *RetryPolicy) RetryStatus(code int) bool {
+	return p.OnStatus[code]
+}
+
+// GetExcluding 重试时选择未尝试过的节点，哈希类负载均衡器对相同key总返回同一节点，改变key重新选择，
+// 仍只命中已尝试的节点时按配置主体的可用节点顺序选择，都已尝试过时返回false
+func GetExcluding(lb LoadBalance, key string, tried map[string]bool) (string, bool) {
+	var nodes []string
+	if holder, ok := lb.(confHolder); ok && holder.GetLoadBalanceConf() != nil {
+		nodes = holder.GetLoadBalanceConf().GetConf()
+	}
+	for i := 0; i <= len(tried); i++ {
+		pickKey := key
+		if i > 0 {
+			pickKey = key + "#" + strconv.Itoa(i)
+		}
+		addr, err := lb.Get(pickKey)
+		if err != nil || addr == "" {
+			return "", false
+		}
+		if !tried[addr] {
+			return addr, true
+		}
+	}
+	for _, node := range nodes {
+		addr := strings.Split(node, ",")[0]
+		if !tried[addr] {
+			return addr, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,50 @@
+package loadbalance
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewRetryPolicy(t *testing.T) {
+	if policy, err := NewRetryPolicy(1, "", false, 0); policy != nil || err != nil {
+		t.Fatal("expect retry disabled")
+	}
+	policy, err := NewRetryPolicy(3, "", false, 2)
+	if err != nil || !policy.OnConnectError || !policy.OnTimeout || !policy.RetryStatus(502) || policy.RetryStatus(500) {
+		t.Fatalf("unexpected default policy %+v %v", policy, err)
+	}
+	if IsValidRetryOn("connect_error,abc") || IsValidRetryOn("600") || !IsValidRetryOn("timeout, 504") {
+		t.Fatal("unexpected retry on check")
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	if policy.Retryable(req) {
+		t.Fatal("expect post not retryable")
+	}
+	req.Header.Set("Idempotency-Key", "abc")
+	if !policy.Retryable(req) {
+		t.Fatal("expect post with idempotency key retryable")
+	}
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Upgrade", "websocket")
+	if policy.Retryable(req) {
+		t.Fatal("expect upgrade not retryable")
+	}
+}
+
+func TestGetExcluding(t *testing.T) {
+	mConf := newTestCheckConf("127.0.0.1:2003", "127.0.0.1:2004", "127.0.0.1:2005")
+	rb := LoadBanlanceFactorWithConf(LbConsistentHash, mConf)
+	tried := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		addr, ok := GetExcluding(rb, "/user", tried)
+		if !ok || tried[addr] {
+			t.Fatalf("expect untried addr, got %s", addr)
+		}
+		tried[addr] = true
+	}
+	if _, ok := GetExcluding(rb, "/user", tried); ok {
+		t.Fatal("expect no untried addr")
+	}
+}
@@ -4,11 +4,16 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
+// RoundRobinBalance 节点列表为只读快照，Next无锁，Add、Update复制后整体替换
 type RoundRobinBalance struct {
-	curIndex int
-	rss      []string
+	curIndex uint64
+	rss      atomic.Value // []string
+	// mux 串行化Add、Update
+	mux sync.Mutex
 	//观察主体
 	conf LoadBalanceConf
 }
@@ -18,21 +23,21 @@ func (r *RoundRobinBalance) Add(params ...string) error {
 		return errors.New("param len 1 at least")
 	}
 	addr := params[0]
-	r.rss = append(r.rss, addr)
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	rss, _ := r.rss.Load().([]string)
+	r.rss.Store(append(append([]string{}, rss...), addr))
 	return nil
 }
 
 func (r *RoundRobinBalance) Next() string {
-	if len(r.rss) == 0 {
+	rss, _ := r.rss.Load().([]string)
+	if len(rss) == 0 {
 		return ""
 	}
-	lens := len(r.rss) //5
-	if r.curIndex >= lens {
-		r.curIndex = 0
-	}
-	curAddr := r.rss[r.curIndex]
-	r.curIndex = (r.curIndex + 1) % lens
-	return curAddr
+	lens := uint64(len(rss)) //5
+	curIndex := atomic.AddUint64(&r.curIndex, 1) - 1
+	return rss[curIndex%lens]
 }
 
 func (r *RoundRobinBalance) Get(key string) (string, error) {
@@ -43,6 +48,10 @@ func (r *RoundRobinBalance) SetConf(conf LoadBalanceConf) {
 	r.conf = conf
 }
 
+func (r *RoundRobinBalance) GetLoadBalanceConf() LoadBalanceConf {
+	return r.conf
+}
+
 func (r *RoundRobinBalance) Update() {
 	//if conf, ok := r.conf.(*LoadBalanceZkConf); ok {
 	//	fmt.Println("Update get zk conf:", conf.GetConf())
@@ -53,9 +62,12 @@ func (r *RoundRobinBalance) Update() {
 	//}
 	if conf, ok := r.conf.(*LoadBalanceCheckConf); ok {
 		fmt.Println("Update get check conf:", conf.GetConf())
-		r.rss = nil
+		rss := []string{}
 		for _, ip := range conf.GetConf() {
-			r.Add(strings.Split(ip, ",")...)
+			rss = append(rss, strings.Split(ip, ",")[0])
 		}
+		r.mux.Lock()
+		defer r.mux.Unlock()
+		r.rss.Store(rss)
 	}
 }
@@ -23,3 +23,34 @@ func Test_main(t *testing.T) {
 	fmt.Println(rb.Next())
 	fmt.Println(rb.Next())
 }
+
+// 并发轮询与节点更新，配合 -race 检查
+func TestRoundRobinConcurrent(t *testing.T) {
+	rb := &RoundRobinBalance{}
+	rb.Add("127.0.0.1:2003")
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			rb.Add(fmt.Sprintf("127.0.0.1:%d", 3000+i))
+		}
+		close(done)
+	}()
+	for i := 0; i < 1000; i++ {
+		if rb.Next() == "" {
+			t.Fatal("expect addr")
+		}
+	}
+	<-done
+}
+
+func BenchmarkRoundRobinParallel(b *testing.B) {
+	rb := &RoundRobinBalance{}
+	for i := 0; i < 10; i++ {
+		rb.Add(fmt.Sprintf("127.0.0.1:%d", 2003+i))
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rb.Next()
+		}
+	})
+}
@@ -0,0 +1,106 @@
+package loadbalance
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultStickyCookieName 会话保持cookie的默认名称
+const DefaultStickyCookieName = "gw_sticky"
+
+// StickySetting HTTP服务的会话保持设置，网关通过签名的cookie记录选中的节点，
+// 之后的请求在该节点可用时继续发往该节点，节点被摘除、禁用或排空时回退到配置的负载均衡策略
+type StickySetting struct {
+	CookieName string
+	// TTL cookie有效期，单位s，0表示浏览器会话期间有效
+	TTL int
+	// Secret 签名密钥，网关集群需一致
+	Secret []byte
+}
+
+func (s StickySetting) cookieName() string {
+	if s.CookieName == "" {
+		return DefaultStickyCookieName
+	}
+	return s.CookieName
+}
+
+// IsValidCookieName cookie名称只能包含token字符
+func IsValidCookieName(name string) bool {
+	for _, c := range name {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune("()<>@,;:\\\"/[]?={}", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// SignStickyCookie cookie值为 base64(节点地址).base64(签名)
+func SignStickyCookie(addr string, secret []byte) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(addr))
+	return payload + "." + stickySign(payload, secret)
+}
+
+// ParseStickyCookie 校验签名并取出节点地址
+func ParseStickyCookie(value string, secret []byte) (string, bool) {
+	parts := strings.Split(value, ".")
+	if len(parts) != 2 {
+		return "", false
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(stickySign(parts[0], secret))) {
+		return "", false
+	}
+	addr, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", false
+	}
+	return string(addr), true
+}
+
+func stickySign(payload string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// StickyAddr 请求中cookie指向的节点，节点不可用或cookie无效时返回false
+func StickyAddr(lb LoadBalance, req *http.Request, setting StickySetting) (string, bool) {
+	cookie, err := req.Cookie(setting.cookieName())
+	if err != nil {
+		return "", false
+	}
+	addr, ok := ParseStickyCookie(cookie.Value, setting.Secret)
+	if !ok {
+		return "", false
+	}
+	holder, ok := lb.(confHolder)
+	if !ok {
+		return "", false
+	}
+	conf, ok := holder.GetLoadBalanceConf().(*LoadBalanceCheckConf)
+	if !ok || !conf.IsActive(addr) {
+		return "", false
+	}
+	return addr, true
+}
+
+// NewStickyCookie 记录选中节点的cookie，设置了有效期时每次响应都会续期
+func NewStickyCookie(addr string, req *http.Request, setting StickySetting) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     setting.cookieName(),
+		Value:    SignStickyCookie(addr, setting.Secret),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   req.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if setting.TTL > 0 {
+		cookie.MaxAge = setting.TTL
+		cookie.Expires = time.Now().Add(time.Duration(setting.TTL) * time.Second)
+	}
+	return cookie
+}
@@ -0,0 +1,74 @@
+package loadbalance
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStickyCookieSign(t *testing.T) {
+	secret := []byte("secret")
+	value := SignStickyCookie("http://127.0.0.1:2003", secret)
+	if addr, ok := ParseStickyCookie(value, secret); !ok || addr != "http://127.0.0.1:2003" {
+		t.Fatalf("unexpected addr %s %v", addr, ok)
+	}
+	// 篡改节点地址或使用其他密钥时签名校验失败
+	forged := SignStickyCookie("http://127.0.0.1:2004", []byte("other"))
+	if _, ok := ParseStickyCookie(forged, secret); ok {
+		t.Fatal("expect forged cookie rejected")
+	}
+	tampered := strings.Split(forged, ".")[0] + "." + strings.Split(value, ".")[1]
+	if _, ok := ParseStickyCookie(tampered, secret); ok {
+		t.Fatal("expect tampered cookie rejected")
+	}
+	if _, ok := ParseStickyCookie("abc", secret); ok {
+		t.Fatal("expect invalid cookie rejected")
+	}
+}
+
+func TestStickyAddr(t *testing.T) {
+	mConf := newTestCheckConf("127.0.0.1:2003", "127.0.0.1:2004", "127.0.0.1:2005", "127.0.0.1:2006")
+	rb := LoadBanlanceFactorWithConf(LbRoundRobin, mConf)
+	setting := StickySetting{Secret: []byte("secret"), TTL: 60}
+	addr := "http://127.0.0.1:2004"
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, ok := StickyAddr(rb, req, setting); ok {
+		t.Fatal("expect no sticky addr without cookie")
+	}
+	cookie := NewStickyCookie(addr, req, setting)
+	if cookie.Name != DefaultStickyCookieName || cookie.MaxAge != 60 || !cookie.HttpOnly {
+		t.Fatalf("unexpected cookie %s", cookie)
+	}
+	req.AddCookie(cookie)
+	if got, ok := StickyAddr(rb, req, setting); !ok || got != addr {
+		t.Fatalf("expect sticky addr %s, got %s", addr, got)
+	}
+
+	// 节点被摘除后回退到负载均衡器
+	for i := 0; i < DefaultOutlierMaxErrNum; i++ {
+		ReportResult(rb, addr, false)
+	}
+	if _, ok := StickyAddr(rb, req, setting); ok {
+		t.Fatal("expect ejected node not sticky")
+	}
+
+	// 排空中的节点不再分配新请求
+	mConf.SetDrainList([]string{"127.0.0.1:2005"})
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(NewStickyCookie("http://127.0.0.1:2005", req, setting))
+	if _, ok := StickyAddr(rb, req, setting); ok {
+		t.Fatal("expect draining node not sticky")
+	}
+
+	// cookie名称不匹配时忽略
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(NewStickyCookie("http://127.0.0.1:2003", req, setting))
+	if _, ok := StickyAddr(rb, req, StickySetting{CookieName: "other", Secret: setting.Secret}); ok {
+		t.Fatal("expect other cookie name ignored")
+	}
+	if IsValidCookieName("a b") || !IsValidCookieName("gw_sticky") {
+		t.Fatal("unexpected cookie name check")
+	}
+}
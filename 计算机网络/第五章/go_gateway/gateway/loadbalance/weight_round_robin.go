@@ -5,12 +5,19 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type WeightRoundRobinBalance struct {
+	mux      sync.Mutex
 	curIndex int
 	rss      []*WeightNode
 	rsw      []int
+	// 慢启动时长，新加入或恢复的节点在该时间内权重从低逐步升至配置值，0表示不启用
+	slowStart time.Duration
+	// 首次Update的节点为初始节点，不进行慢启动
+	started bool
 	//观察主体
 	conf LoadBalanceConf
 }
@@ -20,6 +27,14 @@ type WeightNode struct {
 	weight          int //权重值
 	currentWeight   int //节点当前权重
 	effectiveWeight int //有效权重
+
+	// 慢启动开始时间，零值表示已达到完整权重
+	startAt time.Time
+}
+
+// NewWeightRoundRobinBalanceWithOption 按服务设置的慢启动时长创建
+func NewWeightRoundRobinBalanceWithOption(option LoadBalanceOption) *WeightRoundRobinBalance {
+	return &WeightRoundRobinBalance{slowStart: time.Duration(option.SlowStart) * time.Second}
 }
 
 func (r *WeightRoundRobinBalance) Add(params ...string) error {
@@ -32,23 +47,32 @@ func (r *WeightRoundRobinBalance) Add(params ...string) error {
 	}
 	node := &WeightNode{addr: params[0], weight: int(parInt)}
 	node.effectiveWeight = node.weight
+	r.mux.Lock()
+	defer r.mux.Unlock()
 	r.rss = append(r.rss, node)
 	return nil
 }
 
 func (r *WeightRoundRobinBalance) Next() string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	now := time.Now()
 	total := 0
 	var best *WeightNode
 	for i := 0; i < len(r.rss); i++ {
 		w := r.rss[i]
+		maxWeight := w.maxWeight(now, r.slowStart)
+		if w.effectiveWeight > maxWeight {
+			w.effectiveWeight = maxWeight
+		}
 		//step 1 统计所有有效权重之和
 		total += w.effectiveWeight
 
 		//step 2 变更节点临时权重为的节点临时权重+节点有效权重
 		w.currentWeight += w.effectiveWeight
 
-		//step 3 有效权重默认与权重相同，通讯异常时-1, 通讯成功+1，直到恢复到weight大小
-		if w.effectiveWeight < w.weight {
+		//step 3 有效权重默认与权重相同，通讯异常时降低，之后每轮+1，直到恢复到weight大小，慢启动期间不超过当前的权重上限
+		if w.effectiveWeight < maxWeight {
 			w.effectiveWeight++
 		}
 		//step 4 选择最大临时权重点节点
@@ -64,14 +88,70 @@ func (r *WeightRoundRobinBalance) Next() string {
 	return best.addr
 }
 
+// maxWeight 慢启动期间权重按已启动时间占比线性增长，最低为1
+func (w *WeightNode) maxWeight(now time.Time, slowStart time.Duration) int {
+	if w.startAt.IsZero() || slowStart <= 0 {
+		return w.weight
+	}
+	elapsed := now.Sub(w.startAt)
+	if elapsed >= slowStart {
+		w.startAt = time.Time{}
+		return w.weight
+	}
+	weight := int(int64(w.weight) * int64(elapsed) / int64(slowStart))
+	if weight < 1 {
+		weight = 1
+	}
+	return weight
+}
+
 func (r *WeightRoundRobinBalance) Get(key string) (string, error) {
 	return r.Next(), nil
 }
 
+// ReportResult 请求失败时按 权重/DefaultOutlierMaxErrNum 降低有效权重，
+// 连续失败到被动健康检查摘除时有效权重降为0，之后随轮询逐步恢复
+func (r *WeightRoundRobinBalance) ReportResult(addr string, success bool) {
+	if success {
+		return
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for _, w := range r.rss {
+		if w.addr != addr {
+			continue
+		}
+		penalty := w.weight / DefaultOutlierMaxErrNum
+		if penalty < 1 {
+			penalty = 1
+		}
+		w.effectiveWeight -= penalty
+		if w.effectiveWeight < 0 {
+			w.effectiveWeight = 0
+		}
+	}
+}
+
+// EffectiveWeight 节点当前的有效权重
+func (r *WeightRoundRobinBalance) EffectiveWeight(addr string) int {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for _, w := range r.rss {
+		if w.addr == addr {
+			return w.effectiveWeight
+		}
+	}
+	return 0
+}
+
 func (r *WeightRoundRobinBalance) SetConf(conf LoadBalanceConf) {
 	r.conf = conf
 }
 
+func (r *WeightRoundRobinBalance) GetLoadBalanceConf() LoadBalanceConf {
+	return r.conf
+}
+
 func (r *WeightRoundRobinBalance) Update() {
 	//if conf, ok := r.conf.(*LoadBalanceZkConf); ok {
 	//	fmt.Println("WeightRoundRobinBalance get zk conf:", conf.GetConf())
@@ -83,9 +163,35 @@ func (r *WeightRoundRobinBalance) Update() {
 	// 主动探测
 	if conf, ok := r.conf.(*LoadBalanceCheckConf); ok {
 		fmt.Println("WeightRoundRobinBalance get check conf:", conf.GetConf())
-		r.rss = nil
+		r.mux.Lock()
+		defer r.mux.Unlock()
+		// 保留已有节点的有效权重，新加入或恢复的节点从慢启动开始
+		nodes := map[string]*WeightNode{}
+		for _, w := range r.rss {
+			nodes[w.addr] = w
+		}
+		rss := []*WeightNode{}
 		for _, ip := range conf.GetConf() {
-			r.Add(strings.Split(ip, ",")...)
+			params := strings.Split(ip, ",")
+			if len(params) != 2 {
+				continue
+			}
+			weight, err := strconv.ParseInt(params[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			node, ok := nodes[params[0]]
+			if !ok {
+				node = &WeightNode{addr: params[0], effectiveWeight: int(weight)}
+				if r.started && r.slowStart > 0 {
+					node.startAt = time.Now()
+					node.effectiveWeight = 1
+				}
+			}
+			node.weight = int(weight)
+			rss = append(rss, node)
 		}
+		r.rss = rss
+		r.started = true
 	}
 }
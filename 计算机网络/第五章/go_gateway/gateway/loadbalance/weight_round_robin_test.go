@@ -3,6 +3,7 @@ package loadbalance
 import (
 	"fmt"
 	"testing"
+	"time"
 )
 
 func TestLB(t *testing.T) {
@@ -26,3 +27,95 @@ func TestLB(t *testing.T) {
 	fmt.Println(rb.Next())
 	fmt.Println(rb.Next())
 }
+
+// wrrCounts 轮询n次各节点被选中的次数
+func wrrCounts(rb *WeightRoundRobinBalance, n int) map[string]int {
+	counts := map[string]int{}
+	for i := 0; i < n; i++ {
+		counts[rb.Next()]++
+	}
+	return counts
+}
+
+func TestWeightRoundRobinFailure(t *testing.T) {
+	mConf := newTestCheckConf("127.0.0.1:2003", "127.0.0.1:2004")
+	rb := LoadBanlanceFactorWithConf(LbWeightRoundRobin, mConf).(*WeightRoundRobinBalance)
+	addr := "http://127.0.0.1:2003"
+
+	// 每次失败降低 权重/DefaultOutlierMaxErrNum
+	ReportResult(rb, addr, false)
+	if weight := rb.EffectiveWeight(addr); weight != 50-50/DefaultOutlierMaxErrNum {
+		t.Fatalf("unexpected effective weight %d", weight)
+	}
+	for i := 0; i < DefaultOutlierMaxErrNum; i++ {
+		rb.ReportResult(addr, false)
+	}
+	if weight := rb.EffectiveWeight(addr); weight != 0 {
+		t.Fatalf("expect effective weight 0, got %d", weight)
+	}
+	// 有效权重降低后选中次数减少
+	counts := wrrCounts(rb, 20)
+	if counts[addr] >= counts["http://127.0.0.1:2004"] {
+		t.Fatalf("failed node picked %d times", counts[addr])
+	}
+	// 成功不改变有效权重，之后随轮询逐步恢复
+	rb.ReportResult(addr, true)
+	wrrCounts(rb, 100)
+	if weight := rb.EffectiveWeight(addr); weight != 50 {
+		t.Fatalf("expect effective weight recovered, got %d", weight)
+	}
+
+	// 配置更新保留有效权重
+	rb.ReportResult(addr, false)
+	mConf.UpdateConf([]string{"127.0.0.1:2003", "127.0.0.1:2004"})
+	if weight := rb.EffectiveWeight(addr); weight != 50-50/DefaultOutlierMaxErrNum {
+		t.Fatalf("effective weight lost after update, got %d", weight)
+	}
+}
+
+func TestWeightRoundRobinSlowStart(t *testing.T) {
+	mConf := newTestCheckConf("127.0.0.1:2003", "127.0.0.1:2004")
+	rb := LoadBanlanceFactorWithOption(LbWeightRoundRobin, mConf, LoadBalanceOption{SlowStart: 10}).(*WeightRoundRobinBalance)
+	addr := "http://127.0.0.1:2004"
+	// 初始节点直接使用完整权重
+	if weight := rb.EffectiveWeight(addr); weight != 50 {
+		t.Fatalf("expect initial node full weight, got %d", weight)
+	}
+
+	// 节点摘除后恢复，从权重1开始
+	mConf.UpdateConf([]string{"127.0.0.1:2003"})
+	mConf.UpdateConf([]string{"127.0.0.1:2003", "127.0.0.1:2004"})
+	if weight := rb.EffectiveWeight(addr); weight != 1 {
+		t.Fatalf("expect slow start weight 1, got %d", weight)
+	}
+	counts := wrrCounts(rb, 100)
+	if counts[addr] > 5 {
+		t.Fatalf("slow start node picked %d times", counts[addr])
+	}
+
+	// 慢启动进行到一半时权重上限为一半
+	rb.mux.Lock()
+	for _, w := range rb.rss {
+		if w.addr == addr {
+			w.startAt = time.Now().Add(-5 * time.Second)
+		}
+	}
+	rb.mux.Unlock()
+	wrrCounts(rb, 100)
+	if weight := rb.EffectiveWeight(addr); weight < 24 || weight > 25 {
+		t.Fatalf("expect half weight, got %d", weight)
+	}
+
+	// 慢启动结束后恢复完整权重
+	rb.mux.Lock()
+	for _, w := range rb.rss {
+		if w.addr == addr {
+			w.startAt = time.Now().Add(-10 * time.Second)
+		}
+	}
+	rb.mux.Unlock()
+	wrrCounts(rb, 100)
+	if weight := rb.EffectiveWeight(addr); weight != 50 {
+		t.Fatalf("expect full weight, got %d", weight)
+	}
+}
@@ -2,40 +2,51 @@ package middleware
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var FlowCounterHandler *FlowCounter
 
+// FlowCounter 计数器按全站、服务、租户创建，数量有限且几乎只读，
+// 映射为只读快照，请求路径无锁读取，新建时复制快照后整体替换
 type FlowCounter struct {
-	RedisFlowCountMap   map[string]*RedisFlowCountService
-	RedisFlowCountSlice []*RedisFlowCountService
-	Locker              sync.RWMutex
+	RedisFlowCountMap atomic.Value // map[string]*RedisFlowCountService
+	Locker            sync.Mutex
 }
 
 func NewFlowCounter() *FlowCounter {
-	return &FlowCounter{
-		RedisFlowCountMap:   map[string]*RedisFlowCountService{},
-		RedisFlowCountSlice: []*RedisFlowCountService{},
-		Locker:              sync.RWMutex{},
-	}
+	counter := &FlowCounter{}
+	counter.RedisFlowCountMap.Store(map[string]*RedisFlowCountService{})
+	return counter
 }
 
 func init() {
 	FlowCounterHandler = NewFlowCounter()
 }
 
+func (counter *FlowCounter) items() map[string]*RedisFlowCountService {
+	items, _ := counter.RedisFlowCountMap.Load().(map[string]*RedisFlowCountService)
+	return items
+}
+
 func (counter *FlowCounter) GetCounter(serverName string) (*RedisFlowCountService, error) {
-	for _, item := range counter.RedisFlowCountSlice {
-		if item.AppID == serverName {
-			return item, nil
-		}
+	if item, ok := counter.items()[serverName]; ok {
+		return item, nil
 	}
 
-	newCounter := NewRedisFlowCountService(serverName, 1*time.Second)
-	counter.RedisFlowCountSlice = append(counter.RedisFlowCountSlice, newCounter)
 	counter.Locker.Lock()
 	defer counter.Locker.Unlock()
-	counter.RedisFlowCountMap[serverName] = newCounter
+	// 并发请求可能已创建，重复创建会多启动一个上报协程
+	if item, ok := counter.items()[serverName]; ok {
+		return item, nil
+	}
+	newCounter := NewRedisFlowCountService(serverName, 1*time.Second)
+	items := map[string]*RedisFlowCountService{}
+	for name, item := range counter.items() {
+		items[name] = item
+	}
+	items[serverName] = newCounter
+	counter.RedisFlowCountMap.Store(items)
 	return newCounter, nil
 }
@@ -2,49 +2,88 @@ package middleware
 
 import (
 	"golang.org/x/time/rate"
+	"hash/fnv"
+	"strings"
 	"sync"
 )
 
+// flowLimiterShardNum 分片数，客户端ip限流器的key随客户端数量增长，按key哈希分片以减少锁竞争
+const flowLimiterShardNum = 64
+
 var FlowLimiterHandler *FlowLimiter
 
 type FlowLimiter struct {
-	FlowLmiterMap   map[string]*FlowLimiterItem
-	FlowLmiterSlice []*FlowLimiterItem
-	Locker          sync.RWMutex
+	shards [flowLimiterShardNum]*flowLimiterShard
+}
+
+type flowLimiterShard struct {
+	Locker        sync.RWMutex
+	FlowLmiterMap map[string]*FlowLimiterItem
 }
 
 type FlowLimiterItem struct {
 	ServiceName string
 	Limter      *rate.Limiter
+	// 创建所用的qps，与请求匹配的配置不一致时不再返回
+	Qps float64
 }
 
 func NewFlowLimiter() *FlowLimiter {
-	return &FlowLimiter{
-		FlowLmiterMap:   map[string]*FlowLimiterItem{},
-		FlowLmiterSlice: []*FlowLimiterItem{},
-		Locker:          sync.RWMutex{},
+	limiter := &FlowLimiter{}
+	for i := range limiter.shards {
+		limiter.shards[i] = &flowLimiterShard{FlowLmiterMap: map[string]*FlowLimiterItem{}}
 	}
+	return limiter
 }
 
 func init() {
 	FlowLimiterHandler = NewFlowLimiter()
 }
 
+func (counter *FlowLimiter) shard(serverName string) *flowLimiterShard {
+	h := fnv.New32a()
+	h.Write([]byte(serverName))
+	return counter.shards[h.Sum32()%flowLimiterShardNum]
+}
+
+// GetLimiter 按key获取限流器，缓存的qps与本次不一致时按本次qps重建，
+// 匹配到旧快照的请求在Update之后写入的限流器会被之后的请求替换
 func (counter *FlowLimiter) GetLimiter(serverName string, qps float64) (*rate.Limiter, error) {
-	for _, item := range counter.FlowLmiterSlice {
-		if item.ServiceName == serverName {
-			return item.Limter, nil
-		}
+	shard := counter.shard(serverName)
+	shard.Locker.RLock()
+	item, ok := shard.FlowLmiterMap[serverName]
+	shard.Locker.RUnlock()
+	if ok && item.Qps == qps {
+		return item.Limter, nil
 	}
 
+	shard.Locker.Lock()
+	defer shard.Locker.Unlock()
+	// 并发请求可能已创建
+	if item, ok := shard.FlowLmiterMap[serverName]; ok && item.Qps == qps {
+		return item.Limter, nil
+	}
 	newLimiter := rate.NewLimiter(rate.Limit(qps), int(qps*3))
-	item := &FlowLimiterItem{
+	shard.FlowLmiterMap[serverName] = &FlowLimiterItem{
 		ServiceName: serverName,
 		Limter:      newLimiter,
+		Qps:         qps,
 	}
-	counter.FlowLmiterSlice = append(counter.FlowLmiterSlice, item)
-	counter.Locker.Lock()
-	defer counter.Locker.Unlock()
-	counter.FlowLmiterMap[serverName] = item
 	return newLimiter, nil
 }
+
+// Update 服务或租户配置变更后，移除对应的服务限流器及客户端ip限流器(key_clientIP)
+func (counter *FlowLimiter) Update(changedKeys []string) {
+	for _, shard := range counter.shards {
+		shard.Locker.Lock()
+		for name := range shard.FlowLmiterMap {
+			for _, key := range changedKeys {
+				if name == key || strings.HasPrefix(name, key+"_") {
+					delete(shard.FlowLmiterMap, name)
+					break
+				}
+			}
+		}
+		shard.Locker.Unlock()
+	}
+}
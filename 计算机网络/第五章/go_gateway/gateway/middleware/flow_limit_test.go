@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestFlowLimiterUpdate(t *testing.T) {
+	limiter := NewFlowLimiter()
+	serviceLimiter, _ := limiter.GetLimiter("flow_service_a", 10)
+	if l, _ := limiter.GetLimiter("flow_service_a", 10); l != serviceLimiter {
+		t.Fatal("expect limiter reused")
+	}
+	clientLimiter, _ := limiter.GetLimiter("flow_service_a_127.0.0.1", 10)
+	otherLimiter, _ := limiter.GetLimiter("flow_service_ab_127.0.0.1", 10)
+
+	// 服务配置变更时同时移除服务及其客户端ip限流器
+	limiter.Update([]string{"flow_service_a"})
+	if l, _ := limiter.GetLimiter("flow_service_a", 20); l == serviceLimiter || l.Limit() != 20 {
+		t.Fatal("expect service limiter rebuilt")
+	}
+	if l, _ := limiter.GetLimiter("flow_service_a_127.0.0.1", 10); l == clientLimiter {
+		t.Fatal("expect client limiter rebuilt")
+	}
+	if l, _ := limiter.GetLimiter("flow_service_ab_127.0.0.1", 10); l != otherLimiter {
+		t.Fatal("expect other service limiter kept")
+	}
+}
+
+func TestFlowLimiterConcurrent(t *testing.T) {
+	limiter := NewFlowLimiter()
+	wg := sync.WaitGroup{}
+	results := make([]interface{}, 16)
+	for i := 0; i < len(results); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = limiter.GetLimiter("flow_service_a_127.0.0.1", 10)
+		}(i)
+	}
+	wg.Wait()
+	for _, l := range results {
+		if l != results[0] {
+			t.Fatal("expect single limiter created")
+		}
+	}
+}
+
+// 单次获取的开销不随客户端ip数量增长
+func BenchmarkFlowLimiterGetLimiter(b *testing.B) {
+	for _, clients := range []int{100, 10000, 100000} {
+		b.Run(fmt.Sprintf("clients_%d", clients), func(b *testing.B) {
+			limiter := NewFlowLimiter()
+			keys := make([]string, clients)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("flow_service_a_10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff)
+				limiter.GetLimiter(keys[i], 10)
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					limiter.GetLimiter(keys[i%clients], 10)
+					i++
+				}
+			})
+		})
+	}
+}
+
+// 单次获取的开销不随服务数量增长
+func BenchmarkFlowCounterGetCounter(b *testing.B) {
+	for _, services := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("services_%d", services), func(b *testing.B) {
+			counter := NewFlowCounter()
+			// 直接写入快照，避免启动上报协程
+			items := map[string]*RedisFlowCountService{}
+			keys := make([]string, services)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("flow_service_%d", i)
+				items[keys[i]] = &RedisFlowCountService{AppID: keys[i]}
+			}
+			counter.RedisFlowCountMap.Store(items)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					counter.GetCounter(keys[i%services])
+					i++
+				}
+			})
+		})
+	}
+}
+
+// 匹配到旧快照的请求在Update之后重建的限流器，不被当前配置的请求复用
+func TestFlowLimiterStaleQps(t *testing.T) {
+	limiter := NewFlowLimiter()
+	limiter.Update([]string{"flow_service_a"})
+	stale, _ := limiter.GetLimiter("flow_service_a", 10)
+	current, _ := limiter.GetLimiter("flow_service_a", 20)
+	if current == stale || current.Limit() != 20 {
+		t.Fatal("expect limiter rebuilt with current qps")
+	}
+	if l, _ := limiter.GetLimiter("flow_service_a", 20); l != current {
+		t.Fatal("expect current limiter reused")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/pkg/errors"
 	"go_gateway/bussiness/mvc/dao"
 	"go_gateway/gateway/middleware"
+	"go_gateway/gateway/proxy"
 	"strings"
 )
 
@@ -18,11 +19,16 @@ func HTTPHeaderTransferMiddleware() gin.HandlerFunc {
 			return
 		}
 		serviceDetail := serverInterface.(*dao.ServiceDetail)
+		websocket := proxy.IsWebSocketRequest(c.Request)
 		for _, item := range strings.Split(serviceDetail.HTTPRule.HeaderTransfor, ",") {
 			items := strings.Split(item, " ")
 			if len(items) != 3 {
 				continue
 			}
+			// websocket握手相关header不允许转换，否则下游无法完成升级
+			if websocket && isWebSocketHeader(items[1]) {
+				continue
+			}
 			if items[0] == "add" || items[0] == "edit" {
 				c.Request.Header.Set(items[1], items[2])
 			}
@@ -33,3 +39,8 @@ func HTTPHeaderTransferMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+func isWebSocketHeader(name string) bool {
+	name = strings.ToLower(name)
+	return name == "connection" || name == "upgrade" || strings.HasPrefix(name, "sec-websocket-")
+}
@@ -6,6 +6,7 @@ import (
 	"go_gateway/bussiness/mvc/dao"
 	"go_gateway/common"
 	"go_gateway/gateway/middleware"
+	"go_gateway/gateway/proxy"
 	"strings"
 )
 
@@ -24,6 +25,15 @@ func HTTPJwtAuthTokenMiddleware() gin.HandlerFunc {
 		// decode jwt token
 		// app_id 与  app_list 取得 appInfo
 		// appInfo 放到 gin.context
+		// 浏览器建立websocket时无法设置header，允许通过token参数传递，并补到header中供后续按jwt_claim哈希
+		// 移出后从地址中删除，不转发给下游也不写入请求日志
+		if proxy.IsWebSocketRequest(c.Request) && c.GetHeader("Authorization") == "" && c.Query("token") != "" {
+			c.Request.Header.Set("Authorization", "Bearer "+c.Query("token"))
+			query := c.Request.URL.Query()
+			query.Del("token")
+			c.Request.URL.RawQuery = query.Encode()
+			c.Request.RequestURI = c.Request.URL.RequestURI()
+		}
 		token := strings.ReplaceAll(c.GetHeader("Authorization"), "Bearer ", "")
 		//fmt.Println("token",token)
 		appMatched := false
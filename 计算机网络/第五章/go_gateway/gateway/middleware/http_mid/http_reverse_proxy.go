@@ -6,6 +6,7 @@ import (
 	"go_gateway/bussiness/mvc/dao"
 	"go_gateway/gateway/middleware"
 	"go_gateway/gateway/proxy"
+	"time"
 )
 
 func HTTPReverseProxyMiddleware() gin.HandlerFunc {
@@ -31,7 +32,28 @@ func HTTPReverseProxyMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		proxy := proxy.NewLoadBalanceReverseProxy(c, lb, trans)
+		// websocket连接整个生命周期由WebSocketProxy转发
+		if proxy.IsWebSocketRequest(c.Request) {
+			proxy.NewLoadBalanceWebSocketProxy(c, lb, trans, proxy.WebSocketOption{
+				Sticky:      serviceDetail.LoadBalance.GetStickySetting(),
+				IdleTimeout: time.Duration(serviceDetail.HTTPRule.WebsocketIdleTimeout) * time.Second,
+			}).ServeHTTP(c.Writer, c.Request)
+			c.Abort()
+			return
+		}
+
+		retry, err := serviceDetail.LoadBalance.GetRetryPolicy()
+		if err != nil {
+			middleware.ResponseError(c, 2004, err)
+			c.Abort()
+			return
+		}
+
+		proxy := proxy.NewLoadBalanceReverseProxyWithOption(c, lb, trans, proxy.ReverseProxyOption{
+			Sticky:      serviceDetail.LoadBalance.GetStickySetting(),
+			Retry:       retry,
+			CaptureBody: serviceDetail.LoadBalance.CaptureBody,
+		})
 		proxy.ServeHTTP(c.Writer, c.Request)
 		c.Abort()
 		return
@@ -0,0 +1,32 @@
+package http_mid
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"go_gateway/bussiness/mvc/dao"
+	"go_gateway/gateway/middleware"
+	"go_gateway/gateway/proxy"
+)
+
+//未开启websocket的服务拒绝升级请求
+func HTTPWebSocketMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !proxy.IsWebSocketRequest(c.Request) {
+			c.Next()
+			return
+		}
+		serverInterface, ok := c.Get("service")
+		if !ok {
+			middleware.ResponseError(c, 2001, errors.New("service not found"))
+			c.Abort()
+			return
+		}
+		serviceDetail := serverInterface.(*dao.ServiceDetail)
+		if serviceDetail.HTTPRule.NeedWebsocket != 1 {
+			middleware.ResponseError(c, 2005, errors.New("websocket not enabled"))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,134 @@
+package http_mid
+
+import (
+	"bufio"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"go_gateway/bussiness/mvc/dao"
+	"go_gateway/common"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// upgradeRequest 下游收到的握手请求
+type upgradeRequest struct {
+	hits          int32
+	rawQuery      atomic.Value
+	authorization atomic.Value
+}
+
+func wsUpstream(t *testing.T, got *upgradeRequest) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&got.hits, 1)
+		got.rawQuery.Store(r.URL.RawQuery)
+		got.authorization.Store(r.Header.Get("Authorization"))
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		buf.Flush()
+	}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+// wsGateway 按网关的中间件顺序代理到upstream
+func wsGateway(t *testing.T, name string, upstream string, needWebsocket int) string {
+	service := &dao.ServiceDetail{
+		Info:          &dao.ServiceInfo{ServiceName: name},
+		HTTPRule:      &dao.HttpRule{NeedWebsocket: needWebsocket},
+		AccessControl: &dao.AccessControl{},
+		LoadBalance:   &dao.LoadBalance{IpList: upstream, WeightList: "50"},
+	}
+	// 负载均衡器与连接池只为当前快照中的服务缓存
+	manager := dao.ServiceManagerHandler
+	manager.Locker.Lock()
+	serviceMap := map[string]*dao.ServiceDetail{name: service}
+	for serviceName, item := range manager.ServiceMap {
+		serviceMap[serviceName] = item
+	}
+	manager.ServiceMap = serviceMap
+	manager.Locker.Unlock()
+	router := gin.New()
+	router.Use(
+		func(c *gin.Context) {
+			c.Set("service", service)
+			c.Next()
+		},
+		HTTPWebSocketMiddleware(),
+		HTTPJwtAuthTokenMiddleware(),
+		HTTPReverseProxyMiddleware())
+	server := httptest.NewServer(router)
+	t.Cleanup(func() {
+		server.Close()
+		manager.Locker.Lock()
+		serviceMap := map[string]*dao.ServiceDetail{}
+		for serviceName, item := range manager.ServiceMap {
+			if serviceName != name {
+				serviceMap[serviceName] = item
+			}
+		}
+		manager.ServiceMap = serviceMap
+		manager.Locker.Unlock()
+		dao.LoadBalancerHandler.Delete(name)
+		dao.TransportorHandler.Remove(name)
+	})
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func wsHandshake(t *testing.T, addr string, uri string) *http.Response {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("GET " + uri + " HTTP/1.1\r\nHost: " + addr + "\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestWebSocketRejectedWhenDisabled(t *testing.T) {
+	got := &upgradeRequest{}
+	addr := wsGateway(t, "ws_disabled_service", wsUpstream(t, got), 0)
+	resp := wsHandshake(t, addr, "/chat")
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusSwitchingProtocols || !strings.Contains(string(body), `"errno":2005`) {
+		t.Fatalf("expect error 2005, got %d %s", resp.StatusCode, body)
+	}
+	if atomic.LoadInt32(&got.hits) != 0 {
+		t.Fatal("expect upgrade never reaches upstream")
+	}
+}
+
+func TestWebSocketQueryTokenStripped(t *testing.T) {
+	token, err := common.JwtEncode(jwt.StandardClaims{Issuer: "app_id", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &upgradeRequest{}
+	addr := wsGateway(t, "ws_token_service", wsUpstream(t, got), 1)
+	resp := wsHandshake(t, addr, "/chat?room=1&token="+token)
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expect upgraded, got %d", resp.StatusCode)
+	}
+	// token移到Authorization后不再出现在转发的地址中
+	if rawQuery := got.rawQuery.Load(); rawQuery != "room=1" {
+		t.Fatalf("expect token stripped from query, got %v", rawQuery)
+	}
+	if authorization := got.authorization.Load(); authorization != "Bearer "+token {
+		t.Fatalf("expect token moved to header, got %v", authorization)
+	}
+}
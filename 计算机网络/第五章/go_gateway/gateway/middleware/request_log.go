@@ -7,9 +7,25 @@ import (
 	"go_gateway/common"
 	"go_gateway/common/log"
 	"io/ioutil"
+	"net/url"
+	"strings"
 	"time"
 )
 
+// logURI 请求日志中的地址，隐藏websocket握手通过token参数传递的jwt
+func logURI(uri string) string {
+	index := strings.Index(uri, "?")
+	if index < 0 {
+		return uri
+	}
+	query, err := url.ParseQuery(uri[index+1:])
+	if err != nil || query.Get("token") == "" {
+		return uri
+	}
+	query.Set("token", "***")
+	return uri[:index] + "?" + query.Encode()
+}
+
 // 请求进入日志
 func RequestInLog(c *gin.Context) {
 	traceContext := log.NewTrace()
@@ -27,7 +43,7 @@ func RequestInLog(c *gin.Context) {
 	c.Request.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes)) // Write body back
 
 	log.Log.TagInfo(traceContext, "_com_request_in", map[string]interface{}{
-		"uri":    c.Request.RequestURI,
+		"uri":    logURI(c.Request.RequestURI),
 		"method": c.Request.Method,
 		"args":   c.Request.PostForm,
 		"body":   string(bodyBytes),
@@ -44,7 +60,7 @@ func RequestOutLog(c *gin.Context) {
 
 	startExecTime, _ := st.(time.Time)
 	util.ComLogNotice(c, "_com_request_out", map[string]interface{}{
-		"uri":       c.Request.RequestURI,
+		"uri":       logURI(c.Request.RequestURI),
 		"method":    c.Request.Method,
 		"args":      c.Request.PostForm,
 		"from":      c.ClientIP(),
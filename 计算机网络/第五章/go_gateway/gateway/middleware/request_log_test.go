@@ -0,0 +1,12 @@
+package middleware
+
+import "testing"
+
+func TestLogURI(t *testing.T) {
+	if got := logURI("/chat?room=1&token=abc"); got != "/chat?room=1&token=%2A%2A%2A" {
+		t.Fatalf("expect token hidden, got %s", got)
+	}
+	if got := logURI("/chat?room=1"); got != "/chat?room=1" {
+		t.Fatalf("expect uri unchanged, got %s", got)
+	}
+}
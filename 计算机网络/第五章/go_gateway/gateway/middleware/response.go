@@ -19,6 +19,7 @@ const (
 	InternalErrorCode
 
 	InvalidRequestErrorCode ResponseCode = 401
+	CircuitOpenErrorCode    ResponseCode = 503 // 下游节点熔断中
 	CustomizeCode           ResponseCode = 1000
 
 	GROUPALL_SAVE_FLOWERROR ResponseCode = 2001
@@ -7,6 +7,7 @@ import (
 	"github.com/go-playground/locales/zh"
 	"github.com/go-playground/universal-translator"
 	"go_gateway/common"
+	"go_gateway/gateway/loadbalance"
 	"gopkg.in/go-playground/validator.v9"
 	en_translations "gopkg.in/go-playground/validator.v9/translations/en"
 	zh_translations "gopkg.in/go-playground/validator.v9/translations/zh"
@@ -111,6 +112,77 @@ func TranslationMiddleware() gin.HandlerFunc {
 				return true
 			})
 
+			val.RegisterValidation("valid_round_type", func(fl validator.FieldLevel) bool {
+				return loadbalance.IsValidLbType(int(fl.Field().Int()))
+			})
+			val.RegisterValidation("valid_check_method", func(fl validator.FieldLevel) bool {
+				return loadbalance.IsValidCheckMethod(int(fl.Field().Int()))
+			})
+			val.RegisterValidation("valid_check_status", func(fl validator.FieldLevel) bool {
+				_, err := loadbalance.ParseCheckStatus(fl.Field().String())
+				return err == nil
+			})
+			val.RegisterValidation("valid_hash_key_type", func(fl validator.FieldLevel) bool {
+				return loadbalance.IsValidHashKeyType(int(fl.Field().Int()))
+			})
+			val.RegisterValidation("valid_discovery_type", func(fl validator.FieldLevel) bool {
+				return loadbalance.IsValidDiscoveryType(int(fl.Field().Int()))
+			})
+			val.RegisterValidation("valid_cookie_name", func(fl validator.FieldLevel) bool {
+				return loadbalance.IsValidCookieName(fl.Field().String())
+			})
+			val.RegisterValidation("valid_retry_on", func(fl validator.FieldLevel) bool {
+				return loadbalance.IsValidRetryOn(fl.Field().String())
+			})
+			// 禁用、排空的节点需在ip_list中，避免拼写错误的节点被静默忽略；开启服务发现时节点不固定，只校验格式
+			val.RegisterValidation("valid_node_list", func(fl validator.FieldLevel) bool {
+				top := reflect.Indirect(fl.Top())
+				nodes := map[string]bool{}
+				if ipList := top.FieldByName("IpList"); ipList.IsValid() {
+					for _, item := range strings.Split(ipList.String(), ",") {
+						nodes[strings.TrimSpace(item)] = true
+					}
+				}
+				discovery := top.FieldByName("DiscoveryType")
+				checkNodes := !discovery.IsValid() || discovery.Int() == loadbalance.DiscoveryStatic
+				for _, item := range strings.Split(fl.Field().String(), ",") {
+					item = strings.TrimSpace(item)
+					if item == "" {
+						continue
+					}
+					if checkNodes && !nodes[item] {
+						return false
+					}
+				}
+				return true
+			})
+			val.RegisterValidation("valid_node_remain", func(fl validator.FieldLevel) bool {
+				// 禁用与排空的节点合计不能覆盖ip_list中的全部节点，否则没有节点可选
+				top := reflect.Indirect(fl.Top())
+				discovery := top.FieldByName("DiscoveryType")
+				if discovery.IsValid() && discovery.Int() != loadbalance.DiscoveryStatic {
+					return true
+				}
+				removed := map[string]bool{}
+				for _, name := range []string{"ForbidList", "DrainList"} {
+					if list := top.FieldByName(name); list.IsValid() {
+						for _, item := range strings.Split(list.String(), ",") {
+							removed[strings.TrimSpace(item)] = true
+						}
+					}
+				}
+				ipList := top.FieldByName("IpList")
+				if !ipList.IsValid() {
+					return true
+				}
+				for _, item := range strings.Split(ipList.String(), ",") {
+					if !removed[strings.TrimSpace(item)] {
+						return true
+					}
+				}
+				return false
+			})
+
 			//自定义翻译器
 			//https://github.com/go-playground/validator/blob/v9/_examples/translations/main.go
 			val.RegisterTranslation("valid_username", trans, func(ut ut.Translator) error {
@@ -161,6 +233,60 @@ func TranslationMiddleware() gin.HandlerFunc {
 				t, _ := ut.T("valid_weightlist", fe.Field())
 				return t
 			})
+			val.RegisterTranslation("valid_round_type", trans, func(ut ut.Translator) error {
+				return ut.Add("valid_round_type", "{0} 不支持", true)
+			}, func(ut ut.Translator, fe validator.FieldError) string {
+				t, _ := ut.T("valid_round_type", fe.Field())
+				return t
+			})
+			val.RegisterTranslation("valid_check_method", trans, func(ut ut.Translator) error {
+				return ut.Add("valid_check_method", "{0} 不支持", true)
+			}, func(ut ut.Translator, fe validator.FieldError) string {
+				t, _ := ut.T("valid_check_method", fe.Field())
+				return t
+			})
+			val.RegisterTranslation("valid_check_status", trans, func(ut ut.Translator) error {
+				return ut.Add("valid_check_status", "{0} 格式错误，如200,204或200-299", true)
+			}, func(ut ut.Translator, fe validator.FieldError) string {
+				t, _ := ut.T("valid_check_status", fe.Field())
+				return t
+			})
+			val.RegisterTranslation("valid_hash_key_type", trans, func(ut ut.Translator) error {
+				return ut.Add("valid_hash_key_type", "{0} 不支持", true)
+			}, func(ut ut.Translator, fe validator.FieldError) string {
+				t, _ := ut.T("valid_hash_key_type", fe.Field())
+				return t
+			})
+			val.RegisterTranslation("valid_discovery_type", trans, func(ut ut.Translator) error {
+				return ut.Add("valid_discovery_type", "{0} 不支持", true)
+			}, func(ut ut.Translator, fe validator.FieldError) string {
+				t, _ := ut.T("valid_discovery_type", fe.Field())
+				return t
+			})
+			val.RegisterTranslation("valid_cookie_name", trans, func(ut ut.Translator) error {
+				return ut.Add("valid_cookie_name", "{0} 包含不允许的字符", true)
+			}, func(ut ut.Translator, fe validator.FieldError) string {
+				t, _ := ut.T("valid_cookie_name", fe.Field())
+				return t
+			})
+			val.RegisterTranslation("valid_node_list", trans, func(ut ut.Translator) error {
+				return ut.Add("valid_node_list", "{0} 中的节点不在ip_list中", true)
+			}, func(ut ut.Translator, fe validator.FieldError) string {
+				t, _ := ut.T("valid_node_list", fe.Field())
+				return t
+			})
+			val.RegisterTranslation("valid_node_remain", trans, func(ut ut.Translator) error {
+				return ut.Add("valid_node_remain", "{0} 与drain_list不能包含ip_list中的全部节点", true)
+			}, func(ut ut.Translator, fe validator.FieldError) string {
+				t, _ := ut.T("valid_node_remain", fe.Field())
+				return t
+			})
+			val.RegisterTranslation("valid_retry_on", trans, func(ut ut.Translator) error {
+				return ut.Add("valid_retry_on", "{0} 格式错误，如connect_error,timeout,502,503", true)
+			}, func(ut ut.Translator, fe validator.FieldError) string {
+				t, _ := ut.T("valid_retry_on", fe.Field())
+				return t
+			})
 			break
 		}
 		c.Set(common.TranslatorKey, trans)
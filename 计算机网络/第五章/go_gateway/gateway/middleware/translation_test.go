@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"go_gateway/bussiness/mvc/dto"
+	"go_gateway/bussiness/util"
+	"go_gateway/common"
+	"gopkg.in/go-playground/validator.v9"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type nodeListInput struct {
+	IpList        string
+	DiscoveryType int
+	ForbidList    string `validate:"valid_node_list,valid_node_remain"`
+	DrainList     string `validate:"valid_node_list"`
+}
+
+func testValidator(t *testing.T) *validator.Validate {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/", nil)
+	TranslationMiddleware()(c)
+	val, ok := c.Get(common.ValidatorKey)
+	if !ok {
+		t.Fatal("validator not set")
+	}
+	return val.(*validator.Validate)
+}
+
+func TestValidNodeList(t *testing.T) {
+	val := testValidator(t)
+	cases := []struct {
+		input nodeListInput
+		valid bool
+	}{
+		{nodeListInput{IpList: "127.0.0.1:80,127.0.0.1:81", ForbidList: ""}, true},
+		{nodeListInput{IpList: "127.0.0.1:80,127.0.0.1:81", ForbidList: "127.0.0.1:81"}, true},
+		// 拼写错误的节点
+		{nodeListInput{IpList: "127.0.0.1:80,127.0.0.1:81", ForbidList: "127.0.0.1:8l"}, false},
+		// 开启服务发现时节点不在ip_list中
+		{nodeListInput{IpList: "127.0.0.1:80", DiscoveryType: 3, ForbidList: "10.0.0.1:80"}, true},
+		// 禁用与排空覆盖全部节点
+		{nodeListInput{IpList: "127.0.0.1:80,127.0.0.1:81", ForbidList: "127.0.0.1:80", DrainList: "127.0.0.1:81"}, false},
+		{nodeListInput{IpList: "127.0.0.1:80", ForbidList: "127.0.0.1:80"}, false},
+	}
+	for i, item := range cases {
+		if err := val.Struct(&item.input); (err == nil) != item.valid {
+			t.Fatalf("case %d expect valid=%v, got %v", i, item.valid, err)
+		}
+	}
+}
+
+func TestValidNodeListEmbedded(t *testing.T) {
+	form := url.Values{
+		"service_name": {"test_tcp_service"},
+		"service_desc": {"desc"},
+		"port":         {"8011"},
+		"ip_list":      {"127.0.0.1:80,127.0.0.1:81"},
+		"weight_list":  {"50,50"},
+		"check_method": {"1"},
+	}
+	bind := func(forbidList string) (*dto.ServiceAddTcpInput, error) {
+		form.Set("forbid_list", forbidList)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+		c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		TranslationMiddleware()(c)
+		params := &dto.ServiceAddTcpInput{}
+		return params, util.DefaultGetValidParams(c, params)
+	}
+	// 嵌入的负载均衡设置同样绑定并校验
+	params, err := bind("127.0.0.1:81")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.ForbidList != "127.0.0.1:81" || params.CheckMethod != 1 {
+		t.Fatalf("expect embedded setting bound, got %+v", params.LoadBalanceSettingInput)
+	}
+	if _, err := bind("127.0.0.1:82"); err == nil {
+		t.Fatal("expect forbid_list outside ip_list rejected")
+	}
+}
@@ -5,30 +5,51 @@ import (
 	"go_gateway/common"
 	"go_gateway/gateway/loadbalance"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
-	"log"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"sync"
+	"time"
 )
 
 func NewGrpcLoadBalanceHandler(lb loadbalance.LoadBalance) grpc.StreamHandler {
-	return func() grpc.StreamHandler {
+	return func(srv interface{}, stream grpc.ServerStream) error {
+		// 流结束时释放选中节点的请求计数
+		release := func() {}
+		defer func() {
+			release()
+		}()
 		// 定义入口函数：实用负载均衡算法获取下游主机地址
 		director := func(ctx context.Context, fullMethodName string) (context.Context, *grpc.ClientConn, error) {
-			nextAddr, err := lb.Get(fullMethodName)
+			hashKey := loadbalance.GrpcHashKey(lb, ctx, fullMethodName)
+			// 没有可用节点或都处于熔断中时只让本次调用失败
+			nextAddr, err := lb.Get(hashKey)
 			if err != nil {
-				log.Fatal("get next address fail")
+				return ctx, nil, status.Error(codes.Unavailable, err.Error())
+			}
+			// 节点熔断时换一个节点，都处于熔断中时快速失败
+			nextAddr, err = loadbalance.NextAllowed(lb, hashKey, nextAddr, map[string]bool{})
+			if err != nil {
+				return ctx, nil, status.Error(codes.Unavailable, err.Error())
 			}
 			c, err := grpc.DialContext(ctx, nextAddr,
 				// 自定义编码
 				grpc.WithDefaultCallOptions(grpc.CallContentSubtype(common.Codec().Name())),
 				// 禁用安全传输
-				grpc.WithTransportCredentials(insecure.NewCredentials()))
+				grpc.WithTransportCredentials(insecure.NewCredentials()),
+				// 上报耗时及请求结果
+				grpc.WithStreamInterceptor(trackStreamInterceptor(lb, nextAddr)))
+			if err == nil {
+				release = loadbalance.TrackConn(lb, nextAddr)
+			}
 			//md, _ := metadata.FromIncomingContext(ctx)
 			//outCtx, _ := context.WithCancel(ctx)
 			//outCtx = metadata.NewOutgoingContext(outCtx, md.Copy())
 			return ctx, c, err
 		}
-		return TransparentHandler(director)
-	}()
+		return TransparentHandler(director)(srv, stream)
+	}
 
 	//return func() grpc.StreamHandler {
 	//	nextAddr, err := lb.Get("")
@@ -49,3 +70,55 @@ func NewGrpcLoadBalanceHandler(lb loadbalance.LoadBalance) grpc.StreamHandler {
 	//	return TransparentHandler(director)
 	//}()
 }
+
+// trackStreamInterceptor 统计从发起流到收到下游响应头的耗时，并将Unavailable错误计为节点失败，上报给负载均衡器
+func trackStreamInterceptor(lb loadbalance.LoadBalance, addr string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+		streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := time.Now()
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			if status.Code(err) == codes.Unavailable {
+				loadbalance.ReportResult(lb, addr, false)
+			}
+			return nil, err
+		}
+		return &trackClientStream{ClientStream: cs, lb: lb, addr: addr, start: start}, nil
+	}
+}
+
+type trackClientStream struct {
+	grpc.ClientStream
+	lb    loadbalance.LoadBalance
+	addr  string
+	start time.Time
+	once  sync.Once
+	// 同一个流只计一次失败
+	failOnce sync.Once
+}
+
+func (s *trackClientStream) Header() (metadata.MD, error) {
+	md, err := s.ClientStream.Header()
+	s.report(err)
+	if err == nil {
+		s.once.Do(func() {
+			loadbalance.ObserveLatency(s.lb, s.addr, time.Since(s.start))
+			loadbalance.ReportResult(s.lb, s.addr, true)
+		})
+	}
+	return md, err
+}
+
+func (s *trackClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	s.report(err)
+	return err
+}
+
+func (s *trackClientStream) report(err error) {
+	if err != nil && status.Code(err) == codes.Unavailable {
+		s.failOnce.Do(func() {
+			loadbalance.ReportResult(s.lb, s.addr, false)
+		})
+	}
+}
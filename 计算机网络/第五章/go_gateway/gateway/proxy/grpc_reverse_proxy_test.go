@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"context"
+	"go_gateway/gateway/loadbalance"
+	"go_gateway/gateway/proxy/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGrpcProxyNoNode(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer(grpc.UnknownServiceHandler(NewGrpcLoadBalanceHandler(&loadbalance.RoundRobinBalance{})))
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	// 节点全部被禁用时只让本次调用失败
+	_, err = proto.NewEchoClient(conn).UnaryEcho(ctx, &proto.EchoRequest{Message: "hello"})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("expect Unavailable, got %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// captureBody 转发响应体的同时保留前limit字节，关闭时回调
+type captureBody struct {
+	io.ReadCloser
+	limit   int
+	buf     bytes.Buffer
+	onClose func(prefix []byte)
+	closed  bool
+}
+
+func newCaptureBody(body io.ReadCloser, limit int, onClose func(prefix []byte)) io.ReadCloser {
+	return &captureBody{ReadCloser: body, limit: limit, onClose: onClose}
+}
+
+func (b *captureBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if remain := b.limit - b.buf.Len(); remain > 0 && n > 0 {
+		if remain > n {
+			remain = n
+		}
+		b.buf.Write(p[:remain])
+	}
+	return n, err
+}
+
+func (b *captureBody) Close() error {
+	if !b.closed {
+		b.closed = true
+		b.onClose(b.buf.Bytes())
+	}
+	return b.ReadCloser.Close()
+}
+
+// gzipSlack gzip头部及块开销，压缩前缀多保留这部分才能解压出足够的明文
+const gzipSlack = 512
+
+// captureLimit 压缩的响应体需多保留一些字节
+func captureLimit(limit int, encoding string) int {
+	if strings.Contains(encoding, "gzip") {
+		return limit + gzipSlack
+	}
+	return limit
+}
+
+// plainPrefix gzip压缩的响应体前缀解压为明文用于日志，不影响转发给客户端的内容
+// 无法解压的编码不记录，避免二进制内容写入日志
+func plainPrefix(prefix []byte, encoding string, limit int) []byte {
+	switch {
+	case encoding == "" || encoding == "identity":
+		if len(prefix) > limit {
+			prefix = prefix[:limit]
+		}
+		return prefix
+	case strings.Contains(encoding, "gzip"):
+		gr, err := gzip.NewReader(bytes.NewReader(prefix))
+		if err != nil {
+			return nil
+		}
+		// 前缀不完整，解压到末尾时的错误忽略
+		plain, _ := ioutil.ReadAll(io.LimitReader(gr, int64(limit)))
+		return plain
+	}
+	return nil
+}
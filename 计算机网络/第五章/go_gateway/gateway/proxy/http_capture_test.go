@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"github.com/gin-gonic/gin"
+	"go_gateway/gateway/loadbalance"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func gzipUpstream(t *testing.T, body string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gw := gzip.NewWriter(w)
+		gw.Write([]byte(body))
+		gw.Close()
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func serveCapture(lb loadbalance.LoadBalance, capture int) (*httptest.ResponseRecorder, *gin.Context) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/echo", nil)
+	c.Request.Header.Set("Accept-Encoding", "gzip")
+	proxy := NewLoadBalanceReverseProxyWithOption(c, lb, &http.Transport{}, ReverseProxyOption{CaptureBody: capture})
+	proxy.ServeHTTP(w, c.Request)
+	return w, c
+}
+
+func TestCaptureBodyGzip(t *testing.T) {
+	lb := &loadbalance.RoundRobinBalance{}
+	lb.Add(gzipUpstream(t, "hello gateway"))
+
+	// 默认不记录响应体，压缩内容原样转发
+	w, c := serveCapture(lb, 0)
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expect gzip encoding kept, got %q", w.Header().Get("Content-Encoding"))
+	}
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, _ := ioutil.ReadAll(gr)
+	if string(plain) != "hello gateway" {
+		t.Fatalf("expect body intact, got %q", plain)
+	}
+	if _, ok := c.Get("payload"); ok {
+		t.Fatal("expect no payload captured by default")
+	}
+
+	// 记录前5字节的明文
+	w, c = serveCapture(lb, 5)
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expect gzip encoding kept, got %q", w.Header().Get("Content-Encoding"))
+	}
+	if response := c.GetString("response"); response != "hello" {
+		t.Fatalf("expect captured prefix, got %q", response)
+	}
+}
+
+func TestStreamResponse(t *testing.T) {
+	done := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first\n"))
+		w.(http.Flusher).Flush()
+		<-done
+		w.Write([]byte("second\n"))
+	}))
+	defer upstream.Close()
+	defer close(done)
+	lb := &loadbalance.RoundRobinBalance{}
+	lb.Add(upstream.URL)
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, _ := gin.CreateTestContext(w)
+		c.Request = r
+		NewLoadBalanceReverseProxyWithOption(c, lb, &http.Transport{}, ReverseProxyOption{CaptureBody: 64}).ServeHTTP(w, r)
+	}))
+	defer gateway.Close()
+
+	resp, err := http.Get(gateway.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	// 下游未写完时客户端已能读到第一段
+	line := make(chan string, 1)
+	go func() {
+		s, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		line <- s
+	}()
+	select {
+	case s := <-line:
+		if s != "first\n" {
+			t.Fatalf("expect first chunk, got %q", s)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("response not streamed")
+	}
+}
+
+func TestPlainPrefix(t *testing.T) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	gw.Write([]byte(strings.Repeat("a", 1000)))
+	gw.Close()
+	if got := plainPrefix(buf.Bytes(), "gzip", 10); string(got) != strings.Repeat("a", 10) {
+		t.Fatalf("expect 10 plain bytes, got %q", got)
+	}
+	if got := plainPrefix([]byte("raw"), "", 10); string(got) != "raw" {
+		t.Fatalf("expect raw prefix, got %q", got)
+	}
+	if got := plainPrefix(buf.Bytes()[:5], "gzip", 10); len(got) != 0 {
+		t.Fatalf("expect nothing for truncated gzip header, got %q", got)
+	}
+	if got := plainPrefix([]byte("raw"), "br", 10); len(got) != 0 {
+		t.Fatalf("expect nothing for unsupported encoding, got %q", got)
+	}
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"go_gateway/gateway/loadbalance"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// retryTransport 按重试策略发送请求，每次重试换一个未尝试过的节点
+type retryTransport struct {
+	trans  http.RoundTripper
+	policy *loadbalance.RetryPolicy
+	// next 选择一个未尝试过的节点，没有时返回false
+	next func() (string, bool)
+	// abandon 放弃本次尝试，释放节点的请求计数并上报结果
+	abandon func(resp *http.Response, err error)
+	// use 切换到新节点并改写请求地址
+	use func(req *http.Request, addr string) error
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.policy.Retryable(req) {
+		return t.tryRoundTrip(req)
+	}
+	body, replayable := bufferBody(req, t.policy.MaxBody)
+	for attempt := 1; ; attempt++ {
+		if replayable && body != nil {
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+			req.GetBody = func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(body)), nil
+			}
+		}
+		resp, err := t.tryRoundTrip(req)
+		// 客户端已取消时不再重试
+		if req.Context().Err() != nil || !replayable || attempt >= t.policy.Attempts {
+			return resp, err
+		}
+		if err == nil && !t.policy.RetryStatus(resp.StatusCode) {
+			return resp, nil
+		}
+		if err != nil && !t.policy.RetryError(err) {
+			return resp, err
+		}
+		addr, ok := t.next()
+		if !ok {
+			return resp, err
+		}
+		t.abandon(resp, err)
+		if resp != nil {
+			// 读完剩余的响应体以复用连接
+			io.CopyN(ioutil.Discard, resp.Body, 4096)
+			resp.Body.Close()
+		}
+		req = req.Clone(req.Context())
+		if err := t.use(req, addr); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// tryRoundTrip 单次尝试，超过TryTimeout未收到响应头时取消请求
+// 收到响应头后不再计时，响应体读取受客户端连接控制
+func (t *retryTransport) tryRoundTrip(req *http.Request) (*http.Response, error) {
+	if t.policy.TryTimeout <= 0 {
+		return t.trans.RoundTrip(req)
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	timer := time.AfterFunc(t.policy.TryTimeout, cancel)
+	resp, err := t.trans.RoundTrip(req.WithContext(ctx))
+	if !timer.Stop() {
+		// 计时已到，即使刚收到响应也已被取消
+		if resp != nil {
+			resp.Body.Close()
+		}
+		cancel()
+		return nil, loadbalance.ErrTryTimeout
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = newReleaseBody(resp.Body, cancel)
+	return resp, nil
+}
+
+// bufferBody 缓存请求体以便重放，请求体超过maxBody或读取失败时原样拼回并返回不可重放
+func bufferBody(req *http.Request, maxBody int64) ([]byte, bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxBody+1))
+	if err != nil || int64(len(body)) > maxBody {
+		req.Body = &multiReadCloser{Reader: io.MultiReader(bytes.NewReader(body), req.Body), Closer: req.Body}
+		return nil, false
+	}
+	req.Body.Close()
+	return body, true
+}
+
+type multiReadCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"github.com/gin-gonic/gin"
+	"go_gateway/gateway/loadbalance"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// retryUpstream 记录请求次数，按status返回，body为收到的请求体
+func retryUpstream(t *testing.T, status int, delay time.Duration, hits *int32) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		time.Sleep(delay)
+		body, _ := ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+		w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// closedAddr 没有监听的地址，连接会被拒绝
+func closedAddr(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis.Close()
+	return "http://" + lis.Addr().String()
+}
+
+func serveRetry(lb loadbalance.LoadBalance, policy *loadbalance.RetryPolicy, method, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, "/echo", strings.NewReader(body))
+	proxy := NewLoadBalanceReverseProxyWithOption(c, lb, &http.Transport{}, ReverseProxyOption{Retry: policy})
+	// gin.responseWriter包装httptest.ResponseRecorder时不支持CloseNotify，直接写入recorder
+	proxy.ServeHTTP(w, c.Request)
+	return w
+}
+
+func TestRetryConnectError(t *testing.T) {
+	var hits int32
+	lb := &loadbalance.RoundRobinBalance{}
+	lb.Add(closedAddr(t))
+	lb.Add(retryUpstream(t, http.StatusOK, 0, &hits))
+	policy, _ := loadbalance.NewRetryPolicy(2, "", false, 0)
+
+	w := serveRetry(lb, policy, http.MethodGet, "")
+	if w.Code != http.StatusOK || hits != 1 {
+		t.Fatalf("expect failover to healthy node, got %d hits %d", w.Code, hits)
+	}
+}
+
+func TestRetryStatus(t *testing.T) {
+	var badHits, okHits int32
+	lb := &loadbalance.RoundRobinBalance{}
+	lb.Add(retryUpstream(t, http.StatusServiceUnavailable, 0, &badHits))
+	lb.Add(retryUpstream(t, http.StatusOK, 0, &okHits))
+	policy, _ := loadbalance.NewRetryPolicy(3, "503", false, 0)
+
+	// 重试发往另一个节点，请求体可重放
+	w := serveRetry(lb, policy, http.MethodPut, "payload")
+	if w.Code != http.StatusOK || w.Body.String() != "payload" {
+		t.Fatalf("expect retry with body, got %d %s", w.Code, w.Body.String())
+	}
+
+	// 非幂等方法默认不重试
+	w = serveRetry(lb, policy, http.MethodPost, "payload")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expect post not retried, got %d", w.Code)
+	}
+
+	policy.NonIdempotent = true
+	w = serveRetry(lb, policy, http.MethodPost, "payload")
+	if w.Code != http.StatusOK || w.Body.String() != "payload" {
+		t.Fatalf("expect post retried, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRetryAttempts(t *testing.T) {
+	var hits int32
+	lb := &loadbalance.RoundRobinBalance{}
+	for i := 0; i < 4; i++ {
+		lb.Add(retryUpstream(t, http.StatusBadGateway, 0, &hits))
+	}
+	policy, _ := loadbalance.NewRetryPolicy(3, "", false, 0)
+	w := serveRetry(lb, policy, http.MethodGet, "")
+	if w.Code != http.StatusBadGateway || hits != 3 {
+		t.Fatalf("expect 3 attempts, got %d hits %d", w.Code, hits)
+	}
+
+	// 节点都已尝试过时不再重试
+	hits = 0
+	lb = &loadbalance.RoundRobinBalance{}
+	lb.Add(retryUpstream(t, http.StatusBadGateway, 0, &hits))
+	w = serveRetry(lb, policy, http.MethodGet, "")
+	if w.Code != http.StatusBadGateway || hits != 1 {
+		t.Fatalf("expect single attempt, got %d hits %d", w.Code, hits)
+	}
+}
+
+func TestRetryTryTimeout(t *testing.T) {
+	var slowHits, okHits int32
+	lb := &loadbalance.RoundRobinBalance{}
+	lb.Add(retryUpstream(t, http.StatusOK, time.Second, &slowHits))
+	lb.Add(retryUpstream(t, http.StatusOK, 0, &okHits))
+	policy, _ := loadbalance.NewRetryPolicy(2, "timeout", false, 0)
+	policy.TryTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	w := serveRetry(lb, policy, http.MethodGet, "")
+	if w.Code != http.StatusOK || okHits != 1 || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("expect timeout retried, got %d hits %d", w.Code, okHits)
+	}
+}
+
+func TestCircuitOpenFailFast(t *testing.T) {
+	var hits int32
+	addr := retryUpstream(t, http.StatusOK, 0, &hits)
+	mConf, err := loadbalance.NewLoadBalanceCheckConf("%s", map[string]string{addr: "50"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mConf.CloseWatch()
+	lb := loadbalance.LoadBanlanceFactorWithConf(loadbalance.LbRoundRobin, mConf)
+	circuit := loadbalance.NewCircuitBreakers(loadbalance.CircuitSetting{ErrorPercent: 50, MinRequests: 1})
+	mConf.SetCircuitBreakers(circuit)
+	circuit.ReportResult(addr, false)
+
+	w := serveRetry(lb, nil, http.MethodGet, "")
+	if hits != 0 || !strings.Contains(w.Body.String(), `"errno":503`) {
+		t.Fatalf("expect fail fast, got hits %d body %s", hits, w.Body.String())
+	}
+}
+
+func TestReverseProxyNoNode(t *testing.T) {
+	// 节点全部被禁用时只让本次请求失败
+	w := serveRetry(&loadbalance.RoundRobinBalance{}, nil, http.MethodGet, "")
+	if !strings.Contains(w.Body.String(), "get next addr fail") {
+		t.Fatalf("expect no node error, got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -1,30 +1,70 @@
 package proxy
 
 import (
-	"bytes"
-	"compress/gzip"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"go_gateway/gateway/loadbalance"
 	"go_gateway/gateway/middleware"
-	"io/ioutil"
+	"io"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"strconv"
 	"strings"
+	"time"
 )
 
 func NewLoadBalanceReverseProxy(c *gin.Context, lb loadbalance.LoadBalance, trans *http.Transport) *httputil.ReverseProxy {
-	// 请求协调者
-	director := func(req *http.Request) {
-		nextAddr, err := lb.Get(req.URL.String())
-		if err != nil || nextAddr == "" {
-			panic("get next addr fail")
-		}
-		target, err := url.Parse(nextAddr)
+	return NewLoadBalanceReverseProxyWithOption(c, lb, trans, ReverseProxyOption{})
+}
+
+// NewLoadBalanceReverseProxyWithSticky sticky不为nil时开启会话保持，
+// 优先选择cookie记录的节点，节点不可用时由负载均衡器选择并更新cookie
+func NewLoadBalanceReverseProxyWithSticky(c *gin.Context, lb loadbalance.LoadBalance, trans *http.Transport, sticky *loadbalance.StickySetting) *httputil.ReverseProxy {
+	return NewLoadBalanceReverseProxyWithOption(c, lb, trans, ReverseProxyOption{Sticky: sticky})
+}
+
+// ReverseProxyOption HTTP服务的代理设置，零值字段表示不启用
+type ReverseProxyOption struct {
+	// Sticky 会话保持
+	Sticky *loadbalance.StickySetting
+	// Retry 请求失败时换节点重试
+	Retry *loadbalance.RetryPolicy
+	// CaptureBody 记录到请求日志的响应体前缀长度，0表示不记录
+	CaptureBody int
+}
+
+func NewLoadBalanceReverseProxyWithOption(c *gin.Context, lb loadbalance.LoadBalance, trans *http.Transport, option ReverseProxyOption) *httputil.ReverseProxy {
+	sticky := option.Sticky
+	// 释放选中节点的请求计数，响应体读取完毕或出错时调用
+	release := func() {}
+	// 选中的节点及请求开始时间，收到响应头时上报耗时
+	var nextAddr string
+	var start time.Time
+	// 是否已收到下游响应，已收到时不再把ModifyResponse的错误计为节点失败
+	responded := false
+	// 请求cookie中记录且仍可用的节点
+	var stickyAddr string
+	// 重试时以改写前的地址重新拼接，并换用未尝试过的节点
+	var hashKey string
+	var reqURL url.URL
+	tried := map[string]bool{}
+	// 可选节点都处于熔断中时不发送请求，由errFunc快速失败
+	var circuitErr error
+	// 没有可用节点或节点地址无效时同样不发送请求，只让本次请求失败
+	var selectErr error
+
+	// 切换到节点addr并改写请求地址
+	use := func(req *http.Request, addr string) error {
+		target, err := url.Parse(addr)
 		if err != nil {
-			panic(err)
+			return err
 		}
+		nextAddr = addr
+		tried[addr] = true
+		release = loadbalance.TrackConn(lb, addr)
+		start = time.Now()
+		u := reqURL
+		req.URL = &u
 		targetQuery := target.RawQuery
 		req.URL.Scheme = target.Scheme
 		req.URL.Host = target.Host
@@ -35,6 +75,33 @@ func NewLoadBalanceReverseProxy(c *gin.Context, lb loadbalance.LoadBalance, tran
 		} else {
 			req.URL.RawQuery = targetQuery + "&" + req.URL.RawQuery
 		}
+		return nil
+	}
+
+	// 请求协调者
+	director := func(req *http.Request) {
+		var err error
+		if sticky != nil {
+			stickyAddr, _ = loadbalance.StickyAddr(lb, req, *sticky)
+		}
+		hashKey = loadbalance.HTTPHashKey(lb, req, c.ClientIP())
+		addr := stickyAddr
+		if addr == "" {
+			addr, err = lb.Get(hashKey)
+		}
+		if err != nil || addr == "" {
+			selectErr = errors.New("get next addr fail")
+			return
+		}
+		addr, circuitErr = loadbalance.NextAllowed(lb, hashKey, addr, tried)
+		if circuitErr != nil {
+			return
+		}
+		reqURL = *req.URL
+		if err := use(req, addr); err != nil {
+			selectErr = err
+			return
+		}
 		if _, ok := req.Header["User-Agent"]; !ok {
 			req.Header.Set("User-Agent", "user-agent")
 		}
@@ -42,45 +109,121 @@ func NewLoadBalanceReverseProxy(c *gin.Context, lb loadbalance.LoadBalance, tran
 
 	//更改内容
 	modifyFunc := func(resp *http.Response) error {
+		responded = true
+		loadbalance.ObserveLatency(lb, nextAddr, time.Since(start))
+		// 被动健康检查：5xx计为失败
+		loadbalance.ReportResult(lb, nextAddr, resp.StatusCode < http.StatusInternalServerError)
+		resp.Body = newReleaseBody(resp.Body, release)
+		if sticky != nil && (nextAddr != stickyAddr || sticky.TTL > 0) {
+			resp.Header.Add("Set-Cookie", loadbalance.NewStickyCookie(nextAddr, c.Request, *sticky).String())
+		}
+		c.Set("status_code", resp.StatusCode)
 		if strings.Contains(resp.Header.Get("Connection"), "Upgrade") {
 			return nil
 		}
-
-		var payload []byte
-		var readErr error
-
-		if strings.Contains(resp.Header.Get("Content-Encoding"), "gzip") {
-			gr, err := gzip.NewReader(resp.Body)
-			if err != nil {
-				return err
-			}
-			payload, readErr = ioutil.ReadAll(gr)
-			resp.Header.Del("Content-Encoding")
-		} else {
-			payload, readErr = ioutil.ReadAll(resp.Body)
+		// 响应体边读边转发，Content-Encoding保持不变，需要记录日志时只保留前CaptureBody字节
+		if option.CaptureBody > 0 {
+			encoding := resp.Header.Get("Content-Encoding")
+			resp.Body = newCaptureBody(resp.Body, captureLimit(option.CaptureBody, encoding), func(prefix []byte) {
+				payload := plainPrefix(prefix, encoding, option.CaptureBody)
+				c.Set("payload", payload)
+				c.Set("response", string(payload))
+			})
 		}
-		if readErr != nil {
-			return readErr
-		}
-
-		c.Set("status_code", resp.StatusCode)
-		c.Set("payload", payload)
-		resp.Body = ioutil.NopCloser(bytes.NewBuffer(payload))
-		resp.ContentLength = int64(len(payload))
-		resp.Header.Set("Content-Length", strconv.FormatInt(int64(len(payload)), 10))
 		return nil
 	}
 
 	// 错误回调 ：关闭real_server时测试，错误回调
 	// 范围：transport.RoundTrip发生的错误、以及ModifyResponse发生的错误
 	errFunc := func(w http.ResponseWriter, r *http.Request, err error) {
+		if circuitErr != nil {
+			middleware.ResponseError(c, middleware.CircuitOpenErrorCode, circuitErr)
+			return
+		}
+		if selectErr != nil {
+			middleware.ResponseError(c, 999, selectErr)
+			return
+		}
+		release()
+		// 连接下游失败计为节点失败，客户端主动取消的不计
+		if !responded && r.Context().Err() == nil {
+			loadbalance.ReportResult(lb, nextAddr, false)
+		}
 		middleware.ResponseError(c, 999, err)
 	}
+	var transport http.RoundTripper = trans
+	if option.Retry != nil {
+		transport = &retryTransport{
+			trans:  trans,
+			policy: option.Retry,
+			next: func() (string, bool) {
+				addr, ok := loadbalance.GetExcluding(lb, hashKey, tried)
+				if !ok {
+					return "", false
+				}
+				addr, err := loadbalance.NextAllowed(lb, hashKey, addr, tried)
+				return addr, err == nil
+			},
+			abandon: func(resp *http.Response, err error) {
+				release()
+				if resp != nil {
+					loadbalance.ReportResult(lb, nextAddr, resp.StatusCode < http.StatusInternalServerError)
+				} else {
+					loadbalance.ReportResult(lb, nextAddr, false)
+				}
+			},
+			use: use,
+		}
+	}
+	upstream := transport
+	transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if circuitErr != nil {
+			return nil, circuitErr
+		}
+		if selectErr != nil {
+			return nil, selectErr
+		}
+		return upstream.RoundTrip(req)
+	})
 	return &httputil.ReverseProxy{
 		Director:       director,
 		ModifyResponse: modifyFunc,
 		ErrorHandler:   errFunc,
-		Transport:      trans}
+		Transport:      transport}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// newReleaseBody 响应体关闭时调用release，协议升级时响应体为双向连接，需保留io.ReadWriteCloser
+func newReleaseBody(body io.ReadCloser, release func()) io.ReadCloser {
+	if rwc, ok := body.(io.ReadWriteCloser); ok {
+		return &releaseReadWriteCloser{ReadWriteCloser: rwc, release: release}
+	}
+	return &releaseReadCloser{ReadCloser: body, release: release}
+}
+
+type releaseReadCloser struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseReadCloser) Close() error {
+	defer b.release()
+	return b.ReadCloser.Close()
+}
+
+type releaseReadWriteCloser struct {
+	io.ReadWriteCloser
+	release func()
+}
+
+func (b *releaseReadWriteCloser) Close() error {
+	defer b.release()
+	return b.ReadWriteCloser.Close()
 }
 
 func singleJoiningSlash(a, b string) string {
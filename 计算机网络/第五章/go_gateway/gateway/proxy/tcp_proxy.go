@@ -26,6 +26,10 @@ type TCPReverseProxy struct {
 	// 执行指定的负载均衡算法，返回 TCP 服务器地址
 	Director func(remoteAddr string) (string, error)
 
+	// 连接结束回调，可选
+	// 由 Director 设置，负载均衡器据此统计节点进行中的连接数
+	Release func()
+
 	// 修改响应，可选
 	// 如果返回错误，则由 ErrorHandler 处理
 	ModifyResponse func(net.Conn) error
@@ -43,15 +47,36 @@ func NewTcpLoadBalanceReverseProxy(c context.Context, lb loadbalance.LoadBalance
 	}
 	// 定义入口函数：通过负载均衡算法得出TCP服务器地址
 	director := func(remoteAddr string) (nextAddr string, err error) {
-		nextAddr, err = lb.Get(remoteAddr)
+		hashKey := loadbalance.TCPHashKey(lb, remoteAddr)
+		// 没有可用节点或都处于熔断中时只关闭本次连接
+		nextAddr, err = lb.Get(hashKey)
+		if err != nil {
+			return
+		}
+		// 节点熔断时换一个节点，都处于熔断中时快速失败
+		nextAddr, err = loadbalance.NextAllowed(lb, hashKey, nextAddr, map[string]bool{})
 		if err != nil {
-			log.Fatal("get next addr fail")
+			return
 		}
 		// 给代理实例属性赋值
 		pxy.Addr = nextAddr
+		pxy.Release = loadbalance.TrackConn(lb, nextAddr)
 		return
 	}
 	pxy.Director = director
+	// 拨号耗时及拨号失败上报给负载均衡器
+	pxy.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		start := time.Now()
+		dst, err := (&net.Dialer{
+			Timeout:   pxy.DialTimeout,
+			KeepAlive: pxy.KeepAlivePeriod,
+		}).DialContext(ctx, network, address)
+		if err == nil {
+			loadbalance.ObserveLatency(lb, address, time.Since(start))
+		}
+		loadbalance.ReportResult(lb, address, err == nil)
+		return dst, err
+	}
 	return pxy
 }
 
@@ -96,7 +121,16 @@ func (pxy *TCPReverseProxy) ServeTCP(ctx context.Context, src net.Conn) {
 	}
 
 	// 执行入口函数：获取下游TCP服务器地址
-	pxy.Director(src.RemoteAddr().String())
+	if pxy.Director != nil {
+		if _, err := pxy.Director(src.RemoteAddr().String()); err != nil {
+			pxy.getErrorHandler()(src, err)
+			src.Close()
+			return
+		}
+	}
+	if pxy.Release != nil {
+		defer pxy.Release()
+	}
 
 	// 向下游发送请求
 	dst, err := pxy.DialContext(ctx, "tcp", pxy.Addr)
@@ -0,0 +1,22 @@
+package proxy
+
+import (
+	"context"
+	"go_gateway/gateway/loadbalance"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTcpProxyNoNode(t *testing.T) {
+	// 节点全部被禁用时只关闭本次连接
+	pxy := NewTcpLoadBalanceReverseProxy(context.Background(), &loadbalance.RoundRobinBalance{})
+	client, server := net.Pipe()
+	defer client.Close()
+	go pxy.ServeTCP(context.Background(), server)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expect connection closed, got %v", err)
+	}
+}
@@ -78,8 +78,13 @@ func ListenAndServe(addr string, handler TCPHandler) error {
 }
 
 func (srv *TCPServer) Serve(l net.Listener) error {
+	srv.mu.Lock()
 	srv.l = &onceCloseListener{Listener: l}
+	srv.mu.Unlock()
 	defer srv.l.Close() // 执行监听器的关闭
+	if srv.shuttingDown() { // Serve前已被关闭
+		return ErrServerClosed
+	}
 
 	if srv.BaseCtx == nil {
 		srv.BaseCtx = context.Background()
@@ -168,9 +173,17 @@ func (oc *onceCloseListener) close() {
 //
 func (srv *TCPServer) Close() error {
 	//srv.inShutdown = 1
-	atomic.StoreInt32(&srv.inShutdown, 1) // 用原子操作修改服务器状态字段：1-关闭
-	if srv.doneChan != nil {
-		close(srv.doneChan) // 关闭channel
+	if !atomic.CompareAndSwapInt32(&srv.inShutdown, 0, 1) { // 用原子操作修改服务器状态字段：1-关闭，重复关闭直接返回
+		return nil
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.doneChan == nil {
+		srv.doneChan = make(chan struct{})
+	}
+	close(srv.doneChan) // 关闭channel
+	if srv.l == nil {   // 尚未开始Serve，Serve时会检查关闭状态
+		return nil
 	}
 	return srv.l.Close() // 关闭监听：listener
 }
@@ -2,7 +2,9 @@ package proxy
 
 import (
 	"log"
+	"net"
 	"testing"
+	"time"
 )
 
 // TCP 服务器，实现服务与代理分离
@@ -28,3 +30,40 @@ func TestTcpServer(t *testing.T) {
 	log.Println("Starting TCP server at " + addr)
 	tcpServer.ListenAndServe()
 }
+
+// 动态重启监听：关闭后端口立即可被重新监听，Serve前关闭不会panic
+func TestTcpServerCloseRelisten(t *testing.T) {
+	addr := "127.0.0.1:0"
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr = lis.Addr().String()
+	tcpServer := &TCPServer{Addr: addr, Handler: &tcpHandler{}}
+	// Serve前关闭
+	tcpServer.Close()
+	if err := tcpServer.Serve(lis); err != ErrServerClosed {
+		t.Fatalf("Serve after Close err:%v", err)
+	}
+
+	lis, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcpServer = &TCPServer{Addr: addr, Handler: &tcpHandler{}}
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- tcpServer.Serve(lis)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	tcpServer.Close()
+	tcpServer.Close()
+	if err := <-errChan; err != ErrServerClosed {
+		t.Fatalf("Serve err:%v", err)
+	}
+	lis, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("relisten %v err:%v", addr, err)
+	}
+	lis.Close()
+}
@@ -0,0 +1,270 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go_gateway/gateway/loadbalance"
+	"go_gateway/gateway/middleware"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultWebSocketIdleTimeout 双向都没有数据时关闭连接
+const DefaultWebSocketIdleTimeout = 60 * time.Second
+
+// IsWebSocketRequest 请求是否为websocket握手
+func IsWebSocketRequest(req *http.Request) bool {
+	if !strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, value := range req.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WebSocketOption websocket服务的代理设置，零值字段使用默认值
+type WebSocketOption struct {
+	// Sticky 会话保持
+	Sticky *loadbalance.StickySetting
+	// IdleTimeout 空闲超时，0表示DefaultWebSocketIdleTimeout
+	IdleTimeout time.Duration
+}
+
+// WebSocketProxy 由负载均衡器选择节点完成握手，之后在客户端与下游之间双向转发数据帧
+type WebSocketProxy struct {
+	c      *gin.Context
+	lb     loadbalance.LoadBalance
+	trans  *http.Transport
+	option WebSocketOption
+}
+
+func NewLoadBalanceWebSocketProxy(c *gin.Context, lb loadbalance.LoadBalance, trans *http.Transport, option WebSocketOption) *WebSocketProxy {
+	if option.IdleTimeout <= 0 {
+		option.IdleTimeout = DefaultWebSocketIdleTimeout
+	}
+	return &WebSocketProxy{c: c, lb: lb, trans: trans, option: option}
+}
+
+func (p *WebSocketProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	hashKey := loadbalance.HTTPHashKey(p.lb, req, p.c.ClientIP())
+	var addr, stickyAddr string
+	var err error
+	if p.option.Sticky != nil {
+		stickyAddr, _ = loadbalance.StickyAddr(p.lb, req, *p.option.Sticky)
+	}
+	addr = stickyAddr
+	if addr == "" {
+		addr, err = p.lb.Get(hashKey)
+	}
+	if err != nil || addr == "" {
+		middleware.ResponseError(p.c, 999, errors.New("get next addr fail"))
+		return
+	}
+	addr, err = loadbalance.NextAllowed(p.lb, hashKey, addr, map[string]bool{})
+	if err != nil {
+		middleware.ResponseError(p.c, middleware.CircuitOpenErrorCode, err)
+		return
+	}
+	target, err := url.Parse(addr)
+	if err != nil {
+		middleware.ResponseError(p.c, 999, err)
+		return
+	}
+	// 连接存续期间计为节点的活跃请求
+	release := loadbalance.TrackConn(p.lb, addr)
+	defer release()
+
+	start := time.Now()
+	upstream, err := p.dial(req.Context(), target)
+	if err != nil {
+		loadbalance.ReportResult(p.lb, addr, false)
+		middleware.ResponseError(p.c, 999, err)
+		return
+	}
+	defer upstream.Close()
+
+	outreq := p.upstreamRequest(req, target)
+	upstream.SetDeadline(time.Now().Add(p.option.IdleTimeout))
+	if err := outreq.Write(upstream); err != nil {
+		loadbalance.ReportResult(p.lb, addr, false)
+		middleware.ResponseError(p.c, 999, err)
+		return
+	}
+	upstreamBuf := bufio.NewReader(upstream)
+	resp, err := http.ReadResponse(upstreamBuf, outreq)
+	if err != nil {
+		loadbalance.ReportResult(p.lb, addr, false)
+		middleware.ResponseError(p.c, 999, err)
+		return
+	}
+	defer resp.Body.Close()
+	loadbalance.ObserveLatency(p.lb, addr, time.Since(start))
+	loadbalance.ReportResult(p.lb, addr, resp.StatusCode < http.StatusInternalServerError)
+	p.c.Set("status_code", resp.StatusCode)
+	if p.option.Sticky != nil && (addr != stickyAddr || p.option.Sticky.TTL > 0) {
+		resp.Header.Add("Set-Cookie", loadbalance.NewStickyCookie(addr, req, *p.option.Sticky).String())
+	}
+
+	// 下游拒绝升级时原样返回响应
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		for key, values := range resp.Header {
+			for _, value := range values {
+				w.Header().Add(key, value)
+			}
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		middleware.ResponseError(p.c, 999, errors.New("websocket: response does not support hijack"))
+		return
+	}
+	client, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		middleware.ResponseError(p.c, 999, err)
+		return
+	}
+	defer client.Close()
+
+	idle := &idleDeadline{conns: []net.Conn{client, upstream}, timeout: p.option.IdleTimeout}
+	// 清除http server设置的读写超时
+	idle.touch()
+	fmt.Fprintf(clientBuf, "HTTP/1.1 %s\r\n", resp.Status)
+	resp.Header.Write(clientBuf)
+	clientBuf.WriteString("\r\n")
+	if err := clientBuf.Flush(); err != nil {
+		return
+	}
+
+	// 握手后已读入缓冲区的数据也需转发，任一方向结束时关闭两端连接
+	var wg sync.WaitGroup
+	var once sync.Once
+	closeBoth := func() {
+		client.Close()
+		upstream.Close()
+	}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		idle.copy(upstream, clientBuf.Reader)
+		once.Do(closeBoth)
+	}()
+	go func() {
+		defer wg.Done()
+		idle.copy(client, upstreamBuf)
+		once.Do(closeBoth)
+	}()
+	wg.Wait()
+}
+
+// upstreamRequest 改写为下游地址的握手请求
+func (p *WebSocketProxy) upstreamRequest(req *http.Request, target *url.URL) *http.Request {
+	outreq := req.Clone(req.Context())
+	outreq.URL.Scheme = target.Scheme
+	outreq.URL.Host = target.Host
+	outreq.URL.Path = singleJoiningSlash(target.Path, req.URL.Path)
+	outreq.URL.RawPath = ""
+	if target.RawQuery == "" || req.URL.RawQuery == "" {
+		outreq.URL.RawQuery = target.RawQuery + req.URL.RawQuery
+	} else {
+		outreq.URL.RawQuery = target.RawQuery + "&" + req.URL.RawQuery
+	}
+	outreq.Host = target.Host
+	outreq.RequestURI = ""
+	outreq.Body = nil
+	outreq.ContentLength = 0
+	if clientIP, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		if prior := req.Header.Get("X-Forwarded-For"); prior != "" {
+			clientIP = prior + ", " + clientIP
+		}
+		outreq.Header.Set("X-Forwarded-For", clientIP)
+	}
+	if _, ok := outreq.Header["User-Agent"]; !ok {
+		outreq.Header.Set("User-Agent", "user-agent")
+	}
+	return outreq
+}
+
+// dial 按服务的transport设置连接下游，https节点使用tls并限定http/1.1
+func (p *WebSocketProxy) dial(ctx context.Context, target *url.URL) (net.Conn, error) {
+	secure := target.Scheme == "https" || target.Scheme == "wss"
+	host := target.Host
+	if target.Port() == "" {
+		if secure {
+			host = net.JoinHostPort(target.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(target.Hostname(), "80")
+		}
+	}
+	dialContext := (&net.Dialer{Timeout: 30 * time.Second}).DialContext
+	if p.trans != nil && p.trans.DialContext != nil {
+		dialContext = p.trans.DialContext
+	}
+	conn, err := dialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	if !secure {
+		return conn, nil
+	}
+	config := &tls.Config{}
+	if p.trans != nil && p.trans.TLSClientConfig != nil {
+		config = p.trans.TLSClientConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = target.Hostname()
+	}
+	config.NextProtos = []string{"http/1.1"}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// idleDeadline 任一方向有数据时顺延两端连接的超时，双向空闲超过timeout时读写失败
+type idleDeadline struct {
+	conns   []net.Conn
+	timeout time.Duration
+}
+
+func (d *idleDeadline) touch() {
+	deadline := time.Now().Add(d.timeout)
+	for _, conn := range d.conns {
+		conn.SetDeadline(deadline)
+	}
+}
+
+func (d *idleDeadline) copy(dst io.Writer, src io.Reader) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			d.touch()
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"bufio"
+	"github.com/gin-gonic/gin"
+	"go_gateway/gateway/loadbalance"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echoUpgradeUpstream 完成升级后原样回写收到的数据，非升级请求返回403
+func echoUpgradeUpstream(t *testing.T) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !IsWebSocketRequest(r) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nX-Path: " + r.URL.Path + "\r\n\r\n")
+		buf.Flush()
+		io.Copy(conn, buf)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func serveWebSocket(t *testing.T, lb loadbalance.LoadBalance, option WebSocketOption) string {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, _ := gin.CreateTestContext(w)
+		c.Request = r
+		NewLoadBalanceWebSocketProxy(c, lb, &http.Transport{}, option).ServeHTTP(w, r)
+	}))
+	t.Cleanup(gateway.Close)
+	return strings.TrimPrefix(gateway.URL, "http://")
+}
+
+// dialWebSocket 发送握手请求，返回连接及响应
+func dialWebSocket(t *testing.T, addr, upgrade string) (net.Conn, *bufio.Reader, *http.Response) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.Write([]byte("GET /chat HTTP/1.1\r\nHost: " + addr + "\r\nUpgrade: " + upgrade + "\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, br, resp
+}
+
+func TestWebSocketProxyEcho(t *testing.T) {
+	lb := &loadbalance.RoundRobinBalance{}
+	lb.Add(echoUpgradeUpstream(t))
+	conn, br, resp := dialWebSocket(t, serveWebSocket(t, lb, WebSocketOption{}), "websocket")
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("X-Path") != "/chat" {
+		t.Fatalf("expect upgraded to /chat, got %d %q", resp.StatusCode, resp.Header.Get("X-Path"))
+	}
+	for _, msg := range []string{"ping", "pong"} {
+		conn.Write([]byte(msg))
+		buf := make([]byte, len(msg))
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := io.ReadFull(br, buf); err != nil || string(buf) != msg {
+			t.Fatalf("expect echo %q, got %q %v", msg, buf, err)
+		}
+	}
+}
+
+func TestWebSocketProxyIdleTimeout(t *testing.T) {
+	lb := &loadbalance.RoundRobinBalance{}
+	lb.Add(echoUpgradeUpstream(t))
+	conn, br, resp := dialWebSocket(t, serveWebSocket(t, lb, WebSocketOption{IdleTimeout: 200 * time.Millisecond}), "websocket")
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expect upgraded, got %d", resp.StatusCode)
+	}
+	// 空闲超时后网关关闭连接
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Fatalf("expect connection closed after idle timeout, got %v", err)
+	}
+}
+
+func TestWebSocketProxyUpstreamRejected(t *testing.T) {
+	lb := &loadbalance.RoundRobinBalance{}
+	lb.Add(echoUpgradeUpstream(t))
+	// 下游不接受的升级协议，原样返回下游响应
+	_, _, resp := dialWebSocket(t, serveWebSocket(t, lb, WebSocketOption{}), "h2c")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expect upstream 403, got %d", resp.StatusCode)
+	}
+}
+
+func TestIsWebSocketRequest(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Upgrade", "WebSocket")
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	if !IsWebSocketRequest(req) {
+		t.Fatal("expect websocket request")
+	}
+	req.Header.Set("Connection", "keep-alive")
+	if IsWebSocketRequest(req) {
+		t.Fatal("expect no upgrade without connection token")
+	}
+}
@@ -3,65 +3,114 @@ package router
 import (
 	"fmt"
 	"go_gateway/bussiness/mvc/dao"
-	_ "go_gateway/common"
+	"go_gateway/common"
 	"go_gateway/gateway/middleware/grpc_mid"
 	"go_gateway/gateway/proxy"
 	"google.golang.org/grpc"
 	"log"
 	"net"
+	"sync"
 )
 
-var grpcServerList = []*wrapGrpcServer{}
+// grpcServerMap 运行中的GRPC服务，key为服务名
+var (
+	grpcServerMap    = map[string]*wrapGrpcServer{}
+	grpcServerLocker sync.Mutex
+)
 
 type wrapGrpcServer struct {
 	Addr string
+	// 启动时的服务配置签名，配置变更后需重启监听
+	Signature string
+	Listener  net.Listener
 	*grpc.Server
 }
 
 func GrpcServerRun() {
 	serviceList := dao.ServiceManagerHandler.GetGrpcServiceList()
+	grpcServerLocker.Lock()
+	defer grpcServerLocker.Unlock()
 	for _, serviceItem := range serviceList {
-		tempItem := serviceItem
-		go func(serviceDetail *dao.ServiceDetail) {
-			addr := fmt.Sprintf(":%d", serviceDetail.GRPCRule.Port)
-			rb, err := dao.LoadBalancerHandler.GetLoadBalancer(serviceDetail)
-			if err != nil {
-				log.Fatalf(" [INFO] GetTcpLoadBalancer %v err:%v\n", addr, err)
-				return
-			}
-			lis, err := net.Listen("tcp", addr)
-			if err != nil {
-				log.Fatalf(" [INFO] GrpcListen %v err:%v\n", addr, err)
-			}
-			grpcHandler := proxy.NewGrpcLoadBalanceHandler(rb)
-			s := grpc.NewServer(
-				grpc.ChainStreamInterceptor(
-					grpc_mid.GrpcFlowCountMiddleware(serviceDetail),
-					grpc_mid.GrpcFlowLimitMiddleware(serviceDetail),
-					//grpc_mid.GrpcJwtAuthTokenMiddleware(serviceDetail),
-					//grpc_mid.GrpcJwtFlowCountMiddleware(serviceDetail),
-					//grpc_mid.GrpcJwtFlowLimitMiddleware(serviceDetail),
-					grpc_mid.GrpcWhiteListMiddleware(serviceDetail),
-					grpc_mid.GrpcBlackListMiddleware(serviceDetail),
-					grpc_mid.GrpcHeaderTransferMiddleware(serviceDetail),
-				),
-				grpc.UnknownServiceHandler(grpcHandler))
+		startGrpcServer(serviceItem)
+	}
+}
+
+// GrpcServerSync 服务变更后同步GRPC监听：先停止已删除、已变更的服务，再启动新增、已变更的服务，其余监听不受影响
+func GrpcServerSync() {
+	serviceList := dao.ServiceManagerHandler.GetGrpcServiceList()
+	grpcServerLocker.Lock()
+	defer grpcServerLocker.Unlock()
 
-			grpcServerList = append(grpcServerList, &wrapGrpcServer{
-				Addr:   addr,
-				Server: s,
-			})
-			log.Printf(" [INFO] grpc_proxy_run %v\n", addr)
-			if err := s.Serve(lis); err != nil {
-				log.Printf(" [INFO] grpc_proxy_run %v err:%v\n", addr, err)
-			}
-		}(tempItem)
+	serviceMap := map[string]*dao.ServiceDetail{}
+	for _, serviceItem := range serviceList {
+		serviceMap[serviceItem.Info.ServiceName] = serviceItem
+	}
+	for serviceName, grpcServer := range grpcServerMap {
+		serviceDetail, ok := serviceMap[serviceName]
+		if ok && common.Obj2Json(serviceDetail) == grpcServer.Signature {
+			continue
+		}
+		// 先关闭监听释放端口，再异步等待进行中的请求处理完成
+		grpcServer.Listener.Close()
+		go grpcServer.GracefulStop()
+		delete(grpcServerMap, serviceName)
+		log.Printf(" [INFO] grpc_proxy_stop %v stopped\n", grpcServer.Addr)
+	}
+	for _, serviceItem := range serviceList {
+		if _, ok := grpcServerMap[serviceItem.Info.ServiceName]; ok {
+			continue
+		}
+		startGrpcServer(serviceItem)
 	}
 }
 
 func GrpcServerStop() {
-	for _, grpcServer := range grpcServerList {
+	grpcServerLocker.Lock()
+	defer grpcServerLocker.Unlock()
+	for serviceName, grpcServer := range grpcServerMap {
 		grpcServer.GracefulStop()
+		delete(grpcServerMap, serviceName)
 		log.Printf(" [INFO] grpc_proxy_stop %v stopped\n", grpcServer.Addr)
 	}
 }
+
+// startGrpcServer 同步完成监听后再异步处理请求，调用方需持有grpcServerLocker
+func startGrpcServer(serviceDetail *dao.ServiceDetail) {
+	addr := fmt.Sprintf(":%d", serviceDetail.GRPCRule.Port)
+	rb, err := dao.LoadBalancerHandler.GetLoadBalancer(serviceDetail)
+	if err != nil {
+		log.Printf(" [ERROR] GetGrpcLoadBalancer %v err:%v\n", addr, err)
+		return
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf(" [ERROR] GrpcListen %v err:%v\n", addr, err)
+		return
+	}
+	grpcHandler := proxy.NewGrpcLoadBalanceHandler(rb)
+	s := grpc.NewServer(
+		grpc.ChainStreamInterceptor(
+			grpc_mid.GrpcFlowCountMiddleware(serviceDetail),
+			grpc_mid.GrpcFlowLimitMiddleware(serviceDetail),
+			//grpc_mid.GrpcJwtAuthTokenMiddleware(serviceDetail),
+			//grpc_mid.GrpcJwtFlowCountMiddleware(serviceDetail),
+			//grpc_mid.GrpcJwtFlowLimitMiddleware(serviceDetail),
+			grpc_mid.GrpcWhiteListMiddleware(serviceDetail),
+			grpc_mid.GrpcBlackListMiddleware(serviceDetail),
+			grpc_mid.GrpcHeaderTransferMiddleware(serviceDetail),
+		),
+		grpc.UnknownServiceHandler(grpcHandler))
+
+	grpcServerMap[serviceDetail.Info.ServiceName] = &wrapGrpcServer{
+		Addr:      addr,
+		Signature: common.Obj2Json(serviceDetail),
+		Listener:  lis,
+		Server:    s,
+	}
+	log.Printf(" [INFO] grpc_proxy_run %v\n", addr)
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			log.Printf(" [INFO] grpc_proxy_run %v err:%v\n", addr, err)
+		}
+	}()
+}
@@ -23,6 +23,7 @@ func InitRouter(middlewares ...gin.HandlerFunc) *gin.Engine {
 
 	router.Use(
 		http_mid.HTTPAccessModeMiddleware(),
+		http_mid.HTTPWebSocketMiddleware(),
 		http_mid.HTTPFlowCountMiddleware(),
 		http_mid.HTTPFlowLimitMiddleware(),
 		http_mid.HTTPJwtAuthTokenMiddleware(),
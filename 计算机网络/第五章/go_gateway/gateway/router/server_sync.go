@@ -0,0 +1,9 @@
+package router
+
+// ServerSyncObserver 服务配置变更监听者，reload后同步TCP、GRPC监听
+type ServerSyncObserver struct{}
+
+func (o *ServerSyncObserver) Update(changedKeys []string) {
+	TcpServerSync()
+	GrpcServerSync()
+}
@@ -4,13 +4,25 @@ import (
 	"context"
 	"fmt"
 	"go_gateway/bussiness/mvc/dao"
+	"go_gateway/common"
 	"go_gateway/gateway/middleware/tcp_mid"
 	"go_gateway/gateway/proxy"
 	"log"
 	"net"
+	"sync"
 )
 
-var tcpServerList = []*proxy.TCPServer{}
+// tcpServerMap 运行中的TCP服务，key为服务名
+var (
+	tcpServerMap    = map[string]*wrapTcpServer{}
+	tcpServerLocker sync.Mutex
+)
+
+type wrapTcpServer struct {
+	// 启动时的服务配置签名，配置变更后需重启监听
+	Signature string
+	*proxy.TCPServer
+}
 
 type tcpHandler struct {
 }
@@ -21,52 +33,97 @@ func (t *tcpHandler) ServeTCP(ctx context.Context, src net.Conn) {
 
 func TcpServerRun() {
 	serviceList := dao.ServiceManagerHandler.GetTcpServiceList()
+	tcpServerLocker.Lock()
+	defer tcpServerLocker.Unlock()
 	for _, serviceItem := range serviceList {
-		tempItem := serviceItem
-		go func(serviceDetail *dao.ServiceDetail) {
-			addr := fmt.Sprintf(":%d", serviceDetail.TCPRule.Port)
-			rb, err := dao.LoadBalancerHandler.GetLoadBalancer(serviceDetail)
-			if err != nil {
-				log.Fatalf(" [INFO] GetTcpLoadBalancer %v err:%v\n", addr, err)
-				return
-			}
-
-			// 构建路由及设置中间件
-			router := tcp_mid.NewTcpSliceRouter()
-			router.Group("/").Use(
-				//tcp_mid.TCPRecoveryMiddleware(),
-				tcp_mid.TCPFlowCountMiddleware(),
-				tcp_mid.TCPFlowLimitMiddleware(),
-				tcp_mid.TCPWhiteListMiddleware(),
-				tcp_mid.TCPBlackListMiddleware(),
-			)
+		startTcpServer(serviceItem)
+	}
+}
 
-			// 构建回调handler
-			routerHandler := tcp_mid.NewTcpSliceRouterHandler(
-				func(c *tcp_mid.TcpSliceRouterContext) proxy.TCPHandler {
-					return proxy.NewTcpLoadBalanceReverseProxy(c.Ctx, rb)
-				}, router)
+// TcpServerSync 服务变更后同步TCP监听：先停止已删除、已变更的服务，再启动新增、已变更的服务，其余监听不受影响
+func TcpServerSync() {
+	serviceList := dao.ServiceManagerHandler.GetTcpServiceList()
+	tcpServerLocker.Lock()
+	defer tcpServerLocker.Unlock()
 
-			baseCtx := context.WithValue(context.Background(), "service", serviceDetail)
-			tcpServer := &proxy.TCPServer{
-				Addr:    addr,
-				Handler: routerHandler,
-				BaseCtx: baseCtx,
-			}
-			tcpServerList = append(tcpServerList, tcpServer)
-			log.Printf(" [INFO] tcp_proxy_run %v\n", addr)
-			// 启动TCP服务，并处理服务异常
-			// proxy.ErrServerClosed: 不处理服务关闭异常
-			if err := tcpServer.ListenAndServe(); err != nil && err != proxy.ErrServerClosed {
-				log.Printf(" [INFO] tcp_proxy_run %v err:%v\n", addr, err)
-			}
-		}(tempItem)
+	serviceMap := map[string]*dao.ServiceDetail{}
+	for _, serviceItem := range serviceList {
+		serviceMap[serviceItem.Info.ServiceName] = serviceItem
+	}
+	for serviceName, tcpServer := range tcpServerMap {
+		serviceDetail, ok := serviceMap[serviceName]
+		if ok && common.Obj2Json(serviceDetail) == tcpServer.Signature {
+			continue
+		}
+		tcpServer.Close()
+		delete(tcpServerMap, serviceName)
+		log.Printf(" [INFO] tcp_proxy_stop %v stopped\n", tcpServer.Addr)
+	}
+	for _, serviceItem := range serviceList {
+		if _, ok := tcpServerMap[serviceItem.Info.ServiceName]; ok {
+			continue
+		}
+		startTcpServer(serviceItem)
 	}
 }
 
 func TcpServerStop() {
-	for _, tcpServer := range tcpServerList {
+	tcpServerLocker.Lock()
+	defer tcpServerLocker.Unlock()
+	for serviceName, tcpServer := range tcpServerMap {
 		tcpServer.Close()
+		delete(tcpServerMap, serviceName)
 		log.Printf(" [INFO] tcp_proxy_stop %v stopped\n", tcpServer.Addr)
 	}
 }
+
+// startTcpServer 同步完成监听后再异步处理连接，保证端口变更时旧监听已释放、新监听已占用
+// 调用方需持有tcpServerLocker
+func startTcpServer(serviceDetail *dao.ServiceDetail) {
+	addr := fmt.Sprintf(":%d", serviceDetail.TCPRule.Port)
+	rb, err := dao.LoadBalancerHandler.GetLoadBalancer(serviceDetail)
+	if err != nil {
+		log.Printf(" [ERROR] GetTcpLoadBalancer %v err:%v\n", addr, err)
+		return
+	}
+
+	// 构建路由及设置中间件
+	router := tcp_mid.NewTcpSliceRouter()
+	router.Group("/").Use(
+		//tcp_mid.TCPRecoveryMiddleware(),
+		tcp_mid.TCPFlowCountMiddleware(),
+		tcp_mid.TCPFlowLimitMiddleware(),
+		tcp_mid.TCPWhiteListMiddleware(),
+		tcp_mid.TCPBlackListMiddleware(),
+	)
+
+	// 构建回调handler
+	routerHandler := tcp_mid.NewTcpSliceRouterHandler(
+		func(c *tcp_mid.TcpSliceRouterContext) proxy.TCPHandler {
+			return proxy.NewTcpLoadBalanceReverseProxy(c.Ctx, rb)
+		}, router)
+
+	baseCtx := context.WithValue(context.Background(), "service", serviceDetail)
+	tcpServer := &proxy.TCPServer{
+		Addr:    addr,
+		Handler: routerHandler,
+		BaseCtx: baseCtx,
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf(" [ERROR] tcp_proxy_run %v err:%v\n", addr, err)
+		return
+	}
+	tcpServerMap[serviceDetail.Info.ServiceName] = &wrapTcpServer{
+		Signature: common.Obj2Json(serviceDetail),
+		TCPServer: tcpServer,
+	}
+	log.Printf(" [INFO] tcp_proxy_run %v\n", addr)
+	go func() {
+		// 启动TCP服务，并处理服务异常
+		// proxy.ErrServerClosed: 不处理服务关闭异常
+		if err := tcpServer.Serve(lis); err != nil && err != proxy.ErrServerClosed {
+			log.Printf(" [INFO] tcp_proxy_run %v err:%v\n", addr, err)
+		}
+	}()
+}
@@ -59,6 +59,25 @@ CREATE TABLE `gateway_app` (
 INSERT INTO `gateway_app` VALUES ('31', 'app_id_a', '租户A', '449441eb5e72dca9c42a12f3924ea3a2', 'white_ips', '100000', '100', '2022-11-09 20:44:20', '2022-11-09 20:44:20', '0');
 INSERT INTO `gateway_app` VALUES ('32', 'app_id_b', '租户B', '8d7b11ec9be0e59a36b52f32366c09cb', '', '20', '0', '2022-11-09 20:44:20', '2022-11-09 20:44:20', '0');
 
+-- ----------------------------
+-- Table structure for gateway_conf_audit
+-- ----------------------------
+DROP TABLE IF EXISTS `gateway_conf_audit`;
+CREATE TABLE `gateway_conf_audit` (
+  `id` bigint NOT NULL AUTO_INCREMENT COMMENT '自增主键',
+  `target_type` varchar(32) NOT NULL DEFAULT '' COMMENT '对象类型 service/app',
+  `target_id` bigint NOT NULL DEFAULT '0' COMMENT '服务id或租户自增id',
+  `target_name` varchar(255) NOT NULL DEFAULT '' COMMENT '服务名或租户app_id',
+  `version` int NOT NULL DEFAULT '0' COMMENT '对象内递增的版本号',
+  `action` varchar(32) NOT NULL DEFAULT '' COMMENT '操作 add/update/delete/rollback',
+  `before_data` mediumtext COMMENT '变更前快照json',
+  `after_data` mediumtext COMMENT '变更后快照json',
+  `admin_user` varchar(255) NOT NULL DEFAULT '' COMMENT '操作管理员',
+  `create_at` datetime NOT NULL COMMENT '操作时间',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uniq_target_version` (`target_type`,`target_id`,`version`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb3 COMMENT='网关配置审计表';
+
 -- ----------------------------
 -- Table structure for gateway_service_access_control
 -- ----------------------------
@@ -117,17 +136,18 @@ CREATE TABLE `gateway_service_http_rule` (
   `need_websocket` tinyint NOT NULL DEFAULT '0' COMMENT '是否支持websocket 1=支持',
   `url_rewrite` varchar(5000) NOT NULL DEFAULT '' COMMENT 'url重写功能 格式：^/gatekeeper/test_service(.*) $1 多个逗号间隔',
   `header_transfor` varchar(5000) NOT NULL DEFAULT '' COMMENT 'header转换支持增加(add)、删除(del)、修改(edit) 格式: add headname headvalue 多个逗号间隔',
+  `websocket_idle_timeout` int NOT NULL DEFAULT '0' COMMENT 'websocket空闲超时, 单位s, 0=默认60',
   PRIMARY KEY (`id`)
 ) ENGINE=InnoDB AUTO_INCREMENT=182 DEFAULT CHARSET=utf8mb3 COMMENT='网关路由匹配表';
 
 -- ----------------------------
 -- Records of gateway_service_http_rule
 -- ----------------------------
-INSERT INTO `gateway_service_http_rule` VALUES ('177', '56', '0', '/test_http_service', '1', '1', '1', '^/test_http_service/abb/(.*) /test_http_service/bba/$1', 'add header_name header_value');
-INSERT INTO `gateway_service_http_rule` VALUES ('178', '59', '1', 'test.com', '0', '1', '1', '', 'add headername headervalue');
-INSERT INTO `gateway_service_http_rule` VALUES ('179', '60', '0', '/test_strip_uri', '0', '1', '0', '^/aaa/(.*) /bbb/$1', '');
-INSERT INTO `gateway_service_http_rule` VALUES ('180', '61', '0', '/test_https_server', '1', '1', '0', '', '');
-INSERT INTO `gateway_service_http_rule` VALUES ('181', '62', '0', '/test_httpservice_lwzy', '1', '0', '0', '', '');
+INSERT INTO `gateway_service_http_rule` VALUES ('177', '56', '0', '/test_http_service', '1', '1', '1', '^/test_http_service/abb/(.*) /test_http_service/bba/$1', 'add header_name header_value', '0');
+INSERT INTO `gateway_service_http_rule` VALUES ('178', '59', '1', 'test.com', '0', '1', '1', '', 'add headername headervalue', '0');
+INSERT INTO `gateway_service_http_rule` VALUES ('179', '60', '0', '/test_strip_uri', '0', '1', '0', '^/aaa/(.*) /bbb/$1', '', '0');
+INSERT INTO `gateway_service_http_rule` VALUES ('180', '61', '0', '/test_https_server', '1', '1', '0', '', '', '0');
+INSERT INTO `gateway_service_http_rule` VALUES ('181', '62', '0', '/test_httpservice_lwzy', '1', '0', '0', '', '', '0');
 
 -- ----------------------------
 -- Table structure for gateway_service_info
@@ -162,10 +182,10 @@ DROP TABLE IF EXISTS `gateway_service_load_balance`;
 CREATE TABLE `gateway_service_load_balance` (
   `id` bigint NOT NULL AUTO_INCREMENT COMMENT '自增主键',
   `service_id` bigint NOT NULL DEFAULT '0' COMMENT '服务id',
-  `check_method` tinyint NOT NULL DEFAULT '0' COMMENT '检查方法 0=tcpchk,检测端口是否握手成功',
+  `check_method` tinyint NOT NULL DEFAULT '0' COMMENT '检查方法 0=tcpchk,检测端口是否握手成功 1=httpchk 2=grpc.health.v1',
   `check_timeout` int NOT NULL DEFAULT '0' COMMENT 'check超时时间,单位s',
   `check_interval` int NOT NULL DEFAULT '0' COMMENT '检查间隔, 单位s',
-  `round_type` tinyint NOT NULL DEFAULT '2' COMMENT '轮询方式 0=random 1=round-robin 2=weight_round-robin 3=ip_hash',
+  `round_type` tinyint NOT NULL DEFAULT '2' COMMENT '轮询方式 0=random 1=round-robin 2=weight_round-robin 3=ip_hash 4=least_conn 5=p2c 6=maglev',
   `ip_list` varchar(2000) NOT NULL DEFAULT '' COMMENT 'ip列表',
   `weight_list` varchar(2000) NOT NULL DEFAULT '' COMMENT '权重列表',
   `forbid_list` varchar(2000) NOT NULL DEFAULT '' COMMENT '禁用ip列表',
@@ -173,15 +193,42 @@ CREATE TABLE `gateway_service_load_balance` (
   `upstream_header_timeout` int NOT NULL DEFAULT '0' COMMENT '获取header超时, 单位s',
   `upstream_idle_timeout` int NOT NULL DEFAULT '0' COMMENT '链接最大空闲时间, 单位s',
   `upstream_max_idle` int NOT NULL DEFAULT '0' COMMENT '最大空闲链接数',
+  `check_path` varchar(255) NOT NULL DEFAULT '' COMMENT 'httpchk检查路径, grpc检查时为服务名',
+  `check_status` varchar(255) NOT NULL DEFAULT '' COMMENT 'httpchk期望状态码, 如200,204或200-299',
+  `check_body` varchar(255) NOT NULL DEFAULT '' COMMENT 'httpchk响应体需包含的内容',
+  `check_fail_num` int NOT NULL DEFAULT '0' COMMENT '连续失败次数达到该值时摘除',
+  `check_succ_num` int NOT NULL DEFAULT '0' COMMENT '连续成功次数达到该值时恢复',
+  `hash_key_type` tinyint NOT NULL DEFAULT '0' COMMENT '哈希key 0=默认 1=客户端ip 2=header 3=cookie 4=query 5=jwt_claim',
+  `hash_key_name` varchar(255) NOT NULL DEFAULT '' COMMENT 'header、cookie、query参数或jwt claim名称',
+  `hash_replicas` int NOT NULL DEFAULT '0' COMMENT '一致性哈希虚拟节点数, 0=默认10',
+  `hash_balance_factor` int NOT NULL DEFAULT '0' COMMENT '有界负载系数, 百分比, 如125, 0=不限制',
+  `drain_list` varchar(2000) NOT NULL DEFAULT '' COMMENT '排空中的ip列表, 不再分配新请求',
+  `discovery_type` tinyint NOT NULL DEFAULT '0' COMMENT '服务发现 0=ip_list 1=dns 2=file 3=http',
+  `discovery_target` varchar(255) NOT NULL DEFAULT '' COMMENT 'dns为host:port或_service._proto.name, file为文件路径, http为注册中心地址',
+  `discovery_interval` int NOT NULL DEFAULT '0' COMMENT 'http轮询间隔, dns重新解析间隔上限, 单位s',
+  `slow_start` int NOT NULL DEFAULT '0' COMMENT '加权轮询慢启动时长, 单位s, 0=不启用',
+  `sticky_session` tinyint NOT NULL DEFAULT '0' COMMENT 'http会话保持 0=关闭 1=开启',
+  `sticky_cookie_name` varchar(255) NOT NULL DEFAULT '' COMMENT '会话保持cookie名称, 默认gw_sticky',
+  `sticky_ttl` int NOT NULL DEFAULT '0' COMMENT '会话保持cookie有效期, 单位s, 0=浏览器会话',
+  `retry_attempts` int NOT NULL DEFAULT '0' COMMENT 'http最多尝试次数, 含首次请求, 0或1=不重试',
+  `retry_on` varchar(255) NOT NULL DEFAULT '' COMMENT '重试条件 connect_error,timeout或状态码, 默认connect_error,timeout,502,503',
+  `retry_non_idempotent` tinyint NOT NULL DEFAULT '0' COMMENT '非幂等方法是否重试 0=否 1=是',
+  `retry_try_timeout` int NOT NULL DEFAULT '0' COMMENT '单次尝试等待响应头超时, 单位s, 0=不限制',
+  `circuit_error_rate` int NOT NULL DEFAULT '0' COMMENT '熔断失败率, 百分比, 0=不按失败率熔断',
+  `circuit_slow_rate` int NOT NULL DEFAULT '0' COMMENT '熔断慢请求占比, 百分比, 0=不按耗时熔断',
+  `circuit_slow_time` int NOT NULL DEFAULT '0' COMMENT '慢请求耗时阈值, 单位ms',
+  `circuit_min_request` int NOT NULL DEFAULT '0' COMMENT '统计窗口内最少请求数, 0=默认20',
+  `circuit_open_time` int NOT NULL DEFAULT '0' COMMENT '熔断持续时间, 单位s, 0=默认30',
+  `capture_body` int NOT NULL DEFAULT '0' COMMENT '记录到请求日志的响应体前缀长度, 单位byte, 0=不记录',
   PRIMARY KEY (`id`)
 ) ENGINE=InnoDB AUTO_INCREMENT=191 DEFAULT CHARSET=utf8mb3 COMMENT='网关负载表';
 
 -- ----------------------------
 -- Records of gateway_service_load_balance
 -- ----------------------------
-INSERT INTO `gateway_service_load_balance` VALUES ('185', '57', '0', '2', '5', '2', '127.0.0.1:6379', '50', '', '0', '0', '0', '0');
-INSERT INTO `gateway_service_load_balance` VALUES ('186', '58', '0', '2', '5', '2', '127.0.0.1:8005', '50', '', '0', '0', '0', '0');
-INSERT INTO `gateway_service_load_balance` VALUES ('190', '62', '0', '2', '5', '2', '127.0.0.1:8080', '100', '', '0', '0', '0', '0');
+INSERT INTO `gateway_service_load_balance` VALUES ('185', '57', '0', '2', '5', '2', '127.0.0.1:6379', '50', '', '0', '0', '0', '0', '', '', '', '0', '0', '0', '', '0', '0', '', '0', '', '0', '0', '0', '', '0', '0', '', '0', '0', '0', '0', '0', '0', '0', '0');
+INSERT INTO `gateway_service_load_balance` VALUES ('186', '58', '0', '2', '5', '2', '127.0.0.1:8005', '50', '', '0', '0', '0', '0', '', '', '', '0', '0', '0', '', '0', '0', '', '0', '', '0', '0', '0', '', '0', '0', '', '0', '0', '0', '0', '0', '0', '0', '0');
+INSERT INTO `gateway_service_load_balance` VALUES ('190', '62', '0', '2', '5', '2', '127.0.0.1:8080', '100', '', '0', '0', '0', '0', '', '', '', '0', '0', '0', '', '0', '0', '', '0', '', '0', '0', '0', '', '0', '0', '', '0', '0', '0', '0', '0', '0', '0', '0');
 
 -- ----------------------------
 -- Table structure for gateway_service_tcp_rule
@@ -2,23 +2,31 @@ package main
 
 import (
 	"flag"
+	"go_gateway/bussiness/dashboard_router"
 	"go_gateway/bussiness/mvc/dao"
 	"go_gateway/common"
+	"go_gateway/gateway/middleware"
 	"go_gateway/gateway/router"
+	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 //endpoint dashboard后台管理  server代理服务器
 //config ./conf/prod/ 对应配置文件夹
+//conf_dir 单机模式的服务、租户配置目录，设置后不依赖mysql
 
 var (
 	endpoint = flag.String("endpoint", "server", "input endpoint dashboard or server")
 	config   = flag.String("config", "./conf/dev/", "input config file like ./conf/dev/")
+	confDir  = flag.String("conf_dir", "", "input services and apps dir like ./conf/dev/standalone/ to run without mysql")
 )
 
+// go run main.go -config=./conf/dev/ -endpoint dashboard
 // go run main.go -config=./conf/dev/ -endpoint server
+// go run main.go -config=./conf/dev/ -endpoint server -conf_dir=./conf/dev/standalone/
 func main() {
 	flag.Parse()
 	if *endpoint == "" {
@@ -30,10 +38,66 @@ func main() {
 		os.Exit(1)
 	}
 
-	common.InitModule(*config)
+	if *endpoint == "dashboard" {
+		common.InitModule(*config)
+		defer common.Destroy()
+
+		go func() {
+			dashboard_router.HttpServerRun()
+		}()
+
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		<-quit
+
+		dashboard_router.HttpServerStop()
+		return
+	}
+
+	if *confDir != "" {
+		common.InitStandaloneModule(*config)
+		dao.ConfSourceHandler = dao.NewFileConfSource(*confDir)
+	} else {
+		common.InitModule(*config)
+	}
 	defer common.Destroy()
-	dao.ServiceManagerHandler.LoadOnce()
-	dao.AppManagerHandler.LoadOnce()
+	if err := dao.ServiceManagerHandler.LoadOnce(); err != nil && *confDir != "" {
+		log.Fatalf(" [ERROR] load services from %s err:%v\n", *confDir, err)
+	}
+	if err := dao.AppManagerHandler.LoadOnce(); err != nil && *confDir != "" {
+		log.Fatalf(" [ERROR] load apps from %s err:%v\n", *confDir, err)
+	}
+
+	// 服务、租户变更时热加载，并失效对应的限流器
+	dao.ServiceManagerHandler.Attach(middleware.FlowLimiterHandler)
+	dao.AppManagerHandler.Attach(middleware.FlowLimiterHandler)
+	dao.ServiceManagerHandler.Attach(&router.ServerSyncObserver{})
+	reloadInterval := common.GetIntConf("base.cluster.reload_interval")
+	if reloadInterval <= 0 {
+		reloadInterval = dao.DefaultConfReloadInterval
+	}
+	dao.WatchConfVersion(time.Duration(reloadInterval) * time.Second)
+	// 上报节点状态及连接数，后台据此展示排空进度
+	dao.ReportNodeStates(dao.NodeStateReportInterval())
+	if fileSource, ok := dao.ConfSourceHandler.(*dao.FileConfSource); ok {
+		// 配置文件变更时立即reload，轮询作为兜底
+		if err := fileSource.Watch(); err != nil {
+			log.Printf(" [ERROR] watch %s err:%v\n", fileSource.Dir, err)
+		}
+	} else {
+		// 集群内其他节点或后台的变更通过redis订阅实时同步
+		dao.SubscribeConfChange()
+	}
+	// kill -HUP 手动触发reload
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			if err := dao.ReloadConf(); err != nil {
+				log.Printf(" [ERROR] conf_reload err:%v\n", err)
+			}
+		}
+	}()
 
 	go func() {
 		router.HttpServerRun()